@@ -0,0 +1,470 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/martenwallewein/easy-going/pkg/egorm"
+	"gorm.io/gorm"
+)
+
+// errRejectedData marks commands that completed, but rejected some of the data.
+var errRejectedData = errors.New("egorm: Data was rejected")
+
+// errDryRun rolls back the transaction of a dry run.
+var errDryRun = errors.New("egorm: Dry run")
+
+// wherePattern parses -where conditions like "name=jane", "age >= 18" or "role in a,b".
+var wherePattern = regexp.MustCompile(`(?i)^\s*([A-Za-z_][A-Za-z0-9_.>-]*?)\s*(!=|<>|<=|>=|=|<|>|\s+not\s+like\s+|\s+like\s+|\s+not\s+in\s+|\s+in\s+)\s*(.*)$`)
+
+type cli struct {
+	ctx           context.Context
+	stdin         io.Reader
+	stdout        io.Writer
+	stderr        io.Writer
+	migrationsDir string
+}
+
+func (c *cli) dispatch(command string, args []string) error {
+	switch command {
+	case "ping":
+		return c.ping(args)
+	case "tables":
+		return c.tables(args)
+	case "schema":
+		if len(args) != 1 || args[0] != "dump" {
+			return usageError("expected schema dump")
+		}
+		return c.schemaDump()
+	case "migrate":
+		return c.migrate(args)
+	case "export":
+		return c.export(args)
+	case "import":
+		return c.importRows(args)
+	case "backup":
+		return c.backup(args)
+	case "restore":
+		return c.restore(args)
+	case "query":
+		return c.query(args)
+	}
+	return usageError("unknown command %q", command)
+}
+
+// parseFlags parses the flags of a command and checks the number of positional arguments.
+func (c *cli) parseFlags(flags *flag.FlagSet, args []string, positional int) error {
+	flags.SetOutput(c.stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != positional {
+		return usageError("%s expects %d argument(s), got %d", flags.Name(), positional, flags.NArg())
+	}
+	return nil
+}
+
+func (c *cli) ping(args []string) error {
+	if err := c.parseFlags(flag.NewFlagSet("ping", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	start := time.Now()
+	if err := egorm.Ping(c.ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "ok (%s)\n", time.Since(start).Round(time.Microsecond))
+	return nil
+}
+
+func (c *cli) tables(args []string) error {
+	if err := c.parseFlags(flag.NewFlagSet("tables", flag.ContinueOnError), args, 0); err != nil {
+		return err
+	}
+	tables, err := egorm.Tables(c.ctx)
+	if err != nil {
+		return err
+	}
+	for _, table := range tables {
+		fmt.Fprintln(c.stdout, table)
+	}
+	return nil
+}
+
+func (c *cli) schemaDump() error {
+	dump, err := egorm.SchemaDump(c.ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(c.stdout, dump)
+	return nil
+}
+
+func (c *cli) migrate(args []string) error {
+	if len(args) == 0 {
+		return usageError("expected migrate up|down|status|create")
+	}
+	flags := flag.NewFlagSet("migrate "+args[0], flag.ContinueOnError)
+	steps := flags.Int("steps", 0, "maximum number of migrations")
+	dryRun := flags.Bool("dry-run", false, "only print what would be done")
+
+	switch args[0] {
+	case "up", "down":
+		if err := c.parseFlags(flags, args[1:], 0); err != nil {
+			return err
+		}
+		run, verb := egorm.MigrateUp, "Applied"
+		if args[0] == "down" {
+			run, verb = egorm.MigrateDown, "Reverted"
+		}
+		if *dryRun {
+			verb = "Would run"
+		}
+		migrations, err := run(c.ctx, c.migrationsDir, egorm.MigrateOpts{Steps: *steps, DryRun: *dryRun})
+		for _, migration := range migrations {
+			fmt.Fprintf(c.stdout, "%s %s_%s\n", verb, migration.Version, migration.Name)
+			if *dryRun {
+				statements := migration.Up
+				if args[0] == "down" {
+					statements = migration.Down
+				}
+				fmt.Fprintf(c.stdout, "%s\n", strings.TrimSpace(statements))
+			}
+		}
+		if err == nil && len(migrations) == 0 {
+			fmt.Fprintln(c.stdout, "Nothing to do")
+		}
+		return err
+	case "status":
+		if err := c.parseFlags(flags, args[1:], 0); err != nil {
+			return err
+		}
+		migrations, err := egorm.MigrationStatus(c.ctx, c.migrationsDir)
+		if err != nil {
+			return err
+		}
+		writer := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED")
+		for _, migration := range migrations {
+			applied := "pending"
+			if migration.AppliedAt != nil {
+				applied = migration.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(writer, "%s\t%s\t%s\n", migration.Version, migration.Name, applied)
+		}
+		return writer.Flush()
+	case "create":
+		if err := c.parseFlags(flags, args[1:], 1); err != nil {
+			return err
+		}
+		if *dryRun {
+			fmt.Fprintf(c.stdout, "Would create %s/<version>_%s.up.sql and .down.sql\n", c.migrationsDir, flags.Arg(0))
+			return nil
+		}
+		up, down, err := egorm.CreateMigration(c.migrationsDir, flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "Created %s\nCreated %s\n", up, down)
+		return nil
+	}
+	return usageError("unknown migrate command %q", args[0])
+}
+
+// whereFlag collects repeated -where conditions.
+type whereFlag egorm.Where
+
+func (w whereFlag) String() string {
+	return fmt.Sprint(map[string]interface{}(w))
+}
+
+func (w whereFlag) Set(condition string) error {
+	match := wherePattern.FindStringSubmatch(condition)
+	if match == nil {
+		return fmt.Errorf("invalid condition %q, expected e.g. name=jane or \"age >= 18\"", condition)
+	}
+	operator := strings.ToLower(strings.Join(strings.Fields(match[2]), " "))
+	var value interface{} = match[3]
+	if operator == "in" || operator == "not in" {
+		items := make([]interface{}, 0)
+		for _, item := range strings.Split(match[3], ",") {
+			items = append(items, strings.TrimSpace(item))
+		}
+		value = items
+	}
+	if operator == "=" {
+		w[match[1]] = value
+	} else {
+		w[match[1]+" "+operator] = value
+	}
+	return nil
+}
+
+func parseFormat(format string) (egorm.Format, error) {
+	switch egorm.Format(format) {
+	case egorm.FormatCSV, egorm.FormatJSONLines, egorm.FormatSQL:
+		return egorm.Format(format), nil
+	}
+	return "", usageError("unknown format %q, expected csv, jsonl or sql", format)
+}
+
+func (c *cli) export(args []string) error {
+	flags := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := flags.String("format", "csv", "csv, jsonl or sql")
+	output := flags.String("o", "", "output file, defaults to stdout")
+	where := whereFlag{}
+	flags.Var(where, "where", "condition like name=jane or \"age >= 18\", may be repeated")
+	if err := c.parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	exportFormat, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	out := c.stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	return egorm.ExportTable(c.ctx, out, exportFormat, flags.Arg(0), egorm.Where(where))
+}
+
+func (c *cli) importRows(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ContinueOnError)
+	format := flags.String("format", "csv", "csv, jsonl or sql")
+	input := flags.String("i", "", "input file, defaults to stdin")
+	batchSize := flags.Int("batch", egorm.DefaultImportBatchSize, "rows per transaction")
+	upsert := flags.Bool("upsert", false, "update rows with the same primary key")
+	dryRun := flags.Bool("dry-run", false, "validate the rows and roll back")
+	if err := c.parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	importFormat, err := parseFormat(*format)
+	if err != nil {
+		return err
+	}
+
+	in := c.stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		in = file
+	}
+	report, err := egorm.ImportTable(c.ctx, in, importFormat, flags.Arg(0), egorm.ImportOpts{BatchSize: *batchSize, Upsert: *upsert, DryRun: *dryRun})
+	if err != nil {
+		return err
+	}
+	for _, rowErr := range report.Errors {
+		fmt.Fprintln(c.stderr, rowErr.Error())
+	}
+	verb := "Imported"
+	if *dryRun {
+		verb = "Would import"
+	}
+	fmt.Fprintf(c.stdout, "%s %d of %d rows\n", verb, report.Imported, report.Rows)
+	if len(report.Errors) > 0 {
+		return fmt.Errorf("%w: %d rows failed", errRejectedData, len(report.Errors))
+	}
+	return nil
+}
+
+func (c *cli) backup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ContinueOnError)
+	compress := flags.Bool("compress", false, "gzip the snapshot")
+	keep := flags.Int("keep", 0, "keep only the last n snapshots of a directory")
+	if err := c.parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	opts := []egorm.BackupOption{egorm.KeepLast(*keep)}
+	if *compress {
+		opts = append(opts, egorm.Compress())
+	}
+	path, err := egorm.Backup(c.ctx, flags.Arg(0), opts...)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Wrote %s\n", path)
+	return nil
+}
+
+func (c *cli) restore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only verify the snapshot")
+	if err := c.parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	if *dryRun {
+		if err := egorm.VerifySnapshot(flags.Arg(0)); err != nil {
+			return err
+		}
+		fmt.Fprintf(c.stdout, "%s is a valid snapshot\n", flags.Arg(0))
+		return nil
+	}
+	if err := egorm.Restore(flags.Arg(0)); err != nil {
+		return err
+	}
+	fmt.Fprintf(c.stdout, "Restored %s\n", flags.Arg(0))
+	return nil
+}
+
+func (c *cli) query(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ContinueOnError)
+	format := flags.String("format", "table", "table, json or csv")
+	dryRun := flags.Bool("dry-run", false, "run inside a transaction that is rolled back")
+	if err := c.parseFlags(flags, args, 1); err != nil {
+		return err
+	}
+	write, ok := map[string]func(io.Writer, []string, [][]interface{}) error{
+		"table": writeTable,
+		"json":  writeJSON,
+		"csv":   writeCSV,
+	}[*format]
+	if !ok {
+		return usageError("unknown format %q, expected table, json or csv", *format)
+	}
+	if err := egorm.InitDB(); err != nil {
+		return err
+	}
+
+	statement := flags.Arg(0)
+	err := egorm.Db.WithContext(c.ctx).Transaction(func(tx *gorm.DB) error {
+		if !returnsRows(statement) {
+			result := tx.Exec(statement)
+			if result.Error != nil {
+				return result.Error
+			}
+			fmt.Fprintf(c.stdout, "%d rows affected\n", result.RowsAffected)
+		} else {
+			rows, err := tx.Raw(statement).Rows()
+			if err != nil {
+				return err
+			}
+			columns, values, err := readRows(rows)
+			if err != nil {
+				return err
+			}
+			if err := write(c.stdout, columns, values); err != nil {
+				return err
+			}
+		}
+		if *dryRun {
+			return errDryRun
+		}
+		return nil
+	})
+	if errors.Is(err, errDryRun) {
+		fmt.Fprintln(c.stderr, "Dry run, rolled back")
+		return nil
+	}
+	return err
+}
+
+// returnsRows reports whether the statement is a query, other statements are executed.
+func returnsRows(statement string) bool {
+	fields := strings.Fields(statement)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "SELECT", "WITH", "PRAGMA", "SHOW", "EXPLAIN", "VALUES", "TABLE":
+		return true
+	}
+	return strings.Contains(strings.ToUpper(statement), " RETURNING ")
+}
+
+func readRows(rows *sql.Rows) ([]string, [][]interface{}, error) {
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	result := make([][]interface{}, 0)
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, nil, err
+		}
+		for i, value := range values {
+			switch v := value.(type) {
+			case []byte:
+				values[i] = string(v)
+			case time.Time:
+				values[i] = v.Format(time.RFC3339Nano)
+			}
+		}
+		result = append(result, values)
+	}
+	return columns, result, rows.Err()
+}
+
+func writeTable(w io.Writer, columns []string, values [][]interface{}) error {
+	writer := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, strings.ToUpper(strings.Join(columns, "\t")))
+	for _, row := range values {
+		cells := make([]string, len(row))
+		for i, value := range row {
+			cells[i] = "NULL"
+			if value != nil {
+				cells[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(fmt.Sprint(value))
+			}
+		}
+		fmt.Fprintln(writer, strings.Join(cells, "\t"))
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "(%d rows)\n", len(values))
+	return err
+}
+
+func writeJSON(w io.Writer, columns []string, values [][]interface{}) error {
+	rows := make([]map[string]interface{}, 0, len(values))
+	for _, row := range values {
+		object := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			object[column] = row[i]
+		}
+		rows = append(rows, object)
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(rows)
+}
+
+func writeCSV(w io.Writer, columns []string, values [][]interface{}) error {
+	writer := csv.NewWriter(w)
+	writer.Write(columns)
+	for _, row := range values {
+		record := make([]string, len(row))
+		for i, value := range row {
+			if value != nil {
+				record[i] = fmt.Sprint(value)
+			}
+		}
+		writer.Write(record)
+	}
+	writer.Flush()
+	return writer.Error()
+}
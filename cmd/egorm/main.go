@@ -0,0 +1,162 @@
+// Command egorm manages egorm databases: migrations, schema inspection, data export and
+// import, backups and ad-hoc queries. It reads the same EGORM_* environment variables as
+// the egorm package, flags override them.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/martenwallewein/easy-going/pkg/egorm"
+)
+
+// Exit codes of the command.
+const (
+	exitOK = 0
+	// exitError is returned for failed commands, e.g. a failing query or migration
+	exitError = 1
+	// exitUsage is returned for unknown commands and invalid flags
+	exitUsage = 2
+	// exitConnection is returned if the database is not reachable
+	exitConnection = 3
+	// exitData is returned if the command completed, but rejected data, e.g. rows of an
+	// import or a snapshot that failed the integrity check
+	exitData = 4
+)
+
+const usage = `Usage: egorm [flags] <command> [arguments]
+
+Commands:
+  ping                              check the database connection
+  tables                            list the tables
+  schema dump                       print the DDL of all tables and indexes
+  migrate up [-steps n] [-dry-run]  apply pending migrations
+  migrate down [-steps n] [-dry-run]
+                                    revert applied migrations, the last one by default
+  migrate status                    list migrations and when they were applied
+  migrate create [-dry-run] <name>  create empty up and down migration files
+  export [-format f] [-where c] [-o file] <table>
+                                    export rows as csv, jsonl or sql
+  import [-format f] [-batch n] [-upsert] [-dry-run] [-i file] <table>
+                                    import rows as csv, jsonl or sql
+  backup [-compress] [-keep n] <path>
+                                    write a sqlite snapshot to a file or directory
+  restore [-dry-run] <path>         replace the sqlite database with a snapshot
+  query [-format table|json|csv] [-dry-run] <sql>
+                                    run ad-hoc SQL, -dry-run rolls back writes
+
+Exit codes: 0 success, 1 failure, 2 usage error, 3 connection error, 4 rejected data.
+
+Flags:
+`
+
+// errUsage marks errors caused by the invocation.
+var errUsage = errors.New("usage")
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the command given by args and returns its exit code.
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	flags := flag.NewFlagSet("egorm", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	sqlitePath := flags.String("sqlite", "", "path of the sqlite database, defaults to $EGORM_DB_SQLITE_PATH")
+	postgresHost := flags.String("postgres-host", "", "postgres host, defaults to $EGORM_POSTGRES_DB_HOST and selects postgres")
+	postgresPort := flags.Int("postgres-port", 0, "postgres port, defaults to $EGORM_POSTGRES_DB_PORT")
+	postgresDatabase := flags.String("postgres-db", "", "postgres database, defaults to $EGORM_POSTGRES_DB_DATABASE")
+	postgresUser := flags.String("postgres-user", "", "postgres user, defaults to $EGORM_POSTGRES_DB_USER")
+	postgresPassword := flags.String("postgres-password", "", "postgres password, defaults to $EGORM_POSTGRES_DB_PASSWORD")
+	migrationsDir := flags.String("dir", envOr("EGORM_MIGRATIONS_DIR", "migrations"), "directory of the SQL migrations")
+	verbose := flags.Bool("verbose", false, "log all queries to stderr")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return exitUsage
+	}
+
+	// Queries are only logged on request, so they do not mix with the output
+	queryLog := &egorm.QueryLogOpts{Level: egorm.LevelOff}
+	if *verbose {
+		queryLog = &egorm.QueryLogOpts{
+			Logger: slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: slog.LevelDebug})),
+			Level:  slog.LevelDebug,
+		}
+	} else if os.Getenv("EGORM_LOG_LEVEL") != "" {
+		queryLog = nil
+	}
+	if *postgresHost != "" || os.Getenv("EGORM_DB") == "postgres" {
+		opts := &egorm.PostgresConnectOpts{
+			Host:     envOr("EGORM_POSTGRES_DB_HOST", ""),
+			Database: envOr("EGORM_POSTGRES_DB_DATABASE", ""),
+			User:     envOr("EGORM_POSTGRES_DB_USER", ""),
+			Password: envOr("EGORM_POSTGRES_DB_PASSWORD", ""),
+			QueryLog: queryLog,
+		}
+		opts.Port, _ = strconv.Atoi(os.Getenv("EGORM_POSTGRES_DB_PORT"))
+		overrideString(&opts.Host, *postgresHost)
+		overrideString(&opts.Database, *postgresDatabase)
+		overrideString(&opts.User, *postgresUser)
+		overrideString(&opts.Password, *postgresPassword)
+		if *postgresPort != 0 {
+			opts.Port = *postgresPort
+		}
+		egorm.SetPostgresConnectOpts(opts)
+	} else {
+		egorm.SetSQLiteConnectOpts(&egorm.SQLiteConnectOpts{Path: *sqlitePath, QueryLog: queryLog})
+	}
+	defer egorm.CloseDB()
+
+	c := &cli{ctx: context.Background(), stdin: stdin, stdout: stdout, stderr: stderr, migrationsDir: *migrationsDir}
+	err := c.dispatch(flags.Arg(0), flags.Args()[1:])
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitUsage
+	case errors.Is(err, errUsage):
+		fmt.Fprintf(stderr, "egorm: %s\n\n", strings.TrimPrefix(err.Error(), "usage: "))
+		flags.Usage()
+		return exitUsage
+	}
+
+	fmt.Fprintln(stderr, err)
+	switch {
+	case errors.Is(err, egorm.ErrConnection):
+		return exitConnection
+	case errors.Is(err, errRejectedData), errors.Is(err, egorm.ErrIntegrityCheck):
+		return exitData
+	}
+	return exitError
+}
+
+func envOr(name string, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return fallback
+}
+
+func overrideString(target *string, value string) {
+	if value != "" {
+		*target = value
+	}
+}
+
+// usageError returns an error that prints the usage.
+func usageError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{errUsage}, args...)...)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// runEgorm runs the command against the sqlite database and migrations of dir.
+func runEgorm(dir string, stdin string, args ...string) (int, string, string) {
+	var stdout, stderr bytes.Buffer
+	args = append([]string{"-sqlite", filepath.Join(dir, "test.sqlite"), "-dir", filepath.Join(dir, "migrations")}, args...)
+	code := run(args, strings.NewReader(stdin), &stdout, &stderr)
+	return code, stdout.String(), stderr.String()
+}
+
+func writeMigration(t *testing.T, dir string, name string, up string, down string) {
+	os.MkdirAll(filepath.Join(dir, "migrations"), 0755)
+	if err := os.WriteFile(filepath.Join(dir, "migrations", name+".up.sql"), []byte(up), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "migrations", name+".down.sql"), []byte(down), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir := t.TempDir()
+	writeMigration(t, dir, "001_create_users", "CREATE TABLE users (id INTEGER PRIMARY KEY, name TEXT NOT NULL, age INTEGER);", "DROP TABLE users;")
+	writeMigration(t, dir, "002_add_email", "ALTER TABLE users ADD COLUMN email TEXT;", "ALTER TABLE users DROP COLUMN email;")
+
+	t.Run("TestUsage", func(t *testing.T) {
+		if code, _, _ := runEgorm(dir, ""); code != exitUsage {
+			t.Errorf("egorm: Expected exit code %d, got %d", exitUsage, code)
+		}
+		code, _, stderr := runEgorm(dir, "", "frobnicate")
+		if code != exitUsage || !strings.Contains(stderr, `unknown command "frobnicate"`) {
+			t.Errorf("egorm: Expected usage error, got %d: %s", code, stderr)
+		}
+		if code, _, _ := runEgorm(dir, "", "export", "-format", "xml", "users"); code != exitUsage {
+			t.Errorf("egorm: Expected exit code %d, got %d", exitUsage, code)
+		}
+	})
+
+	t.Run("TestPing", func(t *testing.T) {
+		code, stdout, stderr := runEgorm(dir, "", "ping")
+		if code != exitOK || !strings.HasPrefix(stdout, "ok") {
+			t.Errorf("egorm: Expected ping to succeed, got %d: %s%s", code, stdout, stderr)
+		}
+	})
+
+	t.Run("TestMigrate", func(t *testing.T) {
+		code, stdout, _ := runEgorm(dir, "", "migrate", "up", "-dry-run")
+		if code != exitOK || !strings.Contains(stdout, "Would run 001_create_users") || !strings.Contains(stdout, "CREATE TABLE users") {
+			t.Errorf("egorm: Expected dry run output, got %d: %s", code, stdout)
+		}
+		if _, stdout, _ := runEgorm(dir, "", "tables"); strings.Contains(stdout, "users") {
+			t.Errorf("egorm: Expected dry run not to create users, got %s", stdout)
+		}
+
+		code, stdout, stderr := runEgorm(dir, "", "migrate", "up")
+		if code != exitOK || strings.Count(stdout, "Applied") != 2 {
+			t.Errorf("egorm: Expected 2 applied migrations, got %d: %s%s", code, stdout, stderr)
+		}
+		_, stdout, _ = runEgorm(dir, "", "migrate", "status")
+		if strings.Contains(stdout, "pending") || !strings.Contains(stdout, "002") {
+			t.Errorf("egorm: Expected all migrations applied, got %s", stdout)
+		}
+
+		code, stdout, _ = runEgorm(dir, "", "migrate", "down")
+		if code != exitOK || !strings.Contains(stdout, "Reverted 002_add_email") {
+			t.Errorf("egorm: Expected 002 reverted, got %d: %s", code, stdout)
+		}
+		if code, _, _ = runEgorm(dir, "", "migrate", "up"); code != exitOK {
+			t.Errorf("egorm: Expected exit code %d, got %d", exitOK, code)
+		}
+
+		if code, stdout, _ := runEgorm(dir, "", "migrate", "create", "-dry-run", "add index"); code != exitOK || !strings.Contains(stdout, "Would create") {
+			t.Errorf("egorm: Expected dry run output, got %d: %s", code, stdout)
+		}
+		if entries, _ := os.ReadDir(filepath.Join(dir, "migrations")); len(entries) != 4 {
+			t.Errorf("egorm: Expected 4 migration files, got %d", len(entries))
+		}
+
+		_, stdout, _ = runEgorm(dir, "", "schema", "dump")
+		if !strings.Contains(stdout, "CREATE TABLE users") || !strings.Contains(stdout, "email") {
+			t.Errorf("egorm: Expected users DDL, got %s", stdout)
+		}
+	})
+
+	t.Run("TestImportExport", func(t *testing.T) {
+		input := "id,name,age,email\n1,Ann,31,ann@example.com\n2,Bob,42,bob@example.com\n1,Cid,50,cid@example.com\n"
+		code, stdout, stderr := runEgorm(dir, input, "import", "users")
+		if code != exitData || !strings.Contains(stdout, "Imported 2 of 3 rows") || !strings.Contains(stderr, "Row 3") {
+			t.Errorf("egorm: Expected rejected row 3, got %d: %s%s", code, stdout, stderr)
+		}
+
+		code, stdout, _ = runEgorm(dir, "{\"id\": 4, \"name\": \"Dan\", \"age\": 20}\n", "import", "-dry-run", "-format", "jsonl", "users")
+		if code != exitOK || !strings.Contains(stdout, "Would import 1 of 1 rows") {
+			t.Errorf("egorm: Expected dry run import, got %d: %s", code, stdout)
+		}
+		if _, stdout, _ = runEgorm(dir, "", "query", "-format", "csv", "SELECT COUNT(*) AS n FROM users"); stdout != "n\n2\n" {
+			t.Errorf("egorm: Expected dry run to keep 2 users, got %q", stdout)
+		}
+
+		code, stdout, stderr = runEgorm(dir, "", "export", "-format", "jsonl", "-where", "age >= 40", "users")
+		if code != exitOK {
+			t.Errorf("egorm: Expected exit code %d, got %d: %s", exitOK, code, stderr)
+			return
+		}
+		lines := strings.Split(strings.TrimSpace(stdout), "\n")
+		if len(lines) != 1 || !strings.Contains(lines[0], "Bob") {
+			t.Errorf("egorm: Expected only Bob, got %s", stdout)
+		}
+	})
+
+	t.Run("TestQuery", func(t *testing.T) {
+		code, stdout, stderr := runEgorm(dir, "", "query", "-format", "json", "SELECT name, age FROM users ORDER BY id")
+		if code != exitOK {
+			t.Errorf("egorm: Expected exit code %d, got %d: %s", exitOK, code, stderr)
+			return
+		}
+		rows := make([]map[string]interface{}, 0)
+		if err := json.Unmarshal([]byte(stdout), &rows); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(rows) != 2 || rows[0]["name"] != "Ann" {
+			t.Errorf("egorm: Expected Ann and Bob, got %v", rows)
+		}
+
+		code, stdout, _ = runEgorm(dir, "", "query", "-dry-run", "DELETE FROM users")
+		if code != exitOK || !strings.Contains(stdout, "2 rows affected") {
+			t.Errorf("egorm: Expected 2 rows affected, got %d: %s", code, stdout)
+		}
+		_, stdout, _ = runEgorm(dir, "", "query", "-format", "csv", "SELECT COUNT(*) AS n FROM users")
+		if stdout != "n\n2\n" {
+			t.Errorf("egorm: Expected dry run to keep 2 users, got %q", stdout)
+		}
+
+		if code, _, _ := runEgorm(dir, "", "query", "SELECT * FROM missing"); code != exitError {
+			t.Errorf("egorm: Expected exit code %d, got %d", exitError, code)
+		}
+	})
+
+	t.Run("TestBackupRestore", func(t *testing.T) {
+		backups := filepath.Join(dir, "backups")
+		os.MkdirAll(backups, 0755)
+		code, stdout, stderr := runEgorm(dir, "", "backup", "-compress", backups)
+		if code != exitOK {
+			t.Errorf("egorm: Expected exit code %d, got %d: %s", exitOK, code, stderr)
+			return
+		}
+		snapshot := strings.TrimSpace(strings.TrimPrefix(stdout, "Wrote "))
+
+		runEgorm(dir, "", "query", "DELETE FROM users")
+		if code, _, _ := runEgorm(dir, "", "restore", "-dry-run", snapshot); code != exitOK {
+			t.Errorf("egorm: Expected exit code %d, got %d", exitOK, code)
+		}
+		if code, _, _ := runEgorm(dir, "", "restore", snapshot); code != exitOK {
+			t.Errorf("egorm: Expected exit code %d, got %d", exitOK, code)
+		}
+		_, stdout, _ = runEgorm(dir, "", "query", "-format", "csv", "SELECT COUNT(*) AS n FROM users")
+		if stdout != "n\n2\n" {
+			t.Errorf("egorm: Expected 2 restored users, got %q", stdout)
+		}
+
+		corrupt := filepath.Join(dir, "corrupt.db")
+		os.WriteFile(corrupt, []byte("not a database"), 0644)
+		if code, _, _ := runEgorm(dir, "", "restore", "-dry-run", corrupt); code != exitData {
+			t.Errorf("egorm: Expected exit code %d, got %d", exitData, code)
+		}
+	})
+}
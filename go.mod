@@ -1,9 +1,11 @@
 module github.com/martenwallewein/easy-going
 
-go 1.18
+go 1.21
 
 require (
+	github.com/glebarez/go-sqlite v1.20.3
 	github.com/glebarez/sqlite v1.7.0
+	github.com/jackc/pgx/v5 v5.3.0
 	github.com/kevinburke/ssh_config v1.2.0
 	golang.org/x/crypto v0.8.0
 	gorm.io/driver/postgres v1.5.0
@@ -12,11 +14,9 @@ require (
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
-	github.com/glebarez/go-sqlite v1.20.3 // indirect
 	github.com/google/uuid v1.3.0 // indirect
 	github.com/jackc/pgpassfile v1.0.0 // indirect
 	github.com/jackc/pgservicefile v0.0.0-20221227161230-091c0ba34f0a // indirect
-	github.com/jackc/pgx/v5 v5.3.0 // indirect
 	github.com/jinzhu/inflection v1.0.0 // indirect
 	github.com/jinzhu/now v1.1.5 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
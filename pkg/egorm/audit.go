@@ -0,0 +1,233 @@
+package egorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const auditTableName = "audit_log"
+const auditOldRowsKey = "egorm:audit_old_rows"
+
+const (
+	AuditOperationCreate = "create"
+	AuditOperationUpdate = "update"
+	AuditOperationDelete = "delete"
+)
+
+// AuditEntry is a single change of an entity recorded in the audit_log table.
+type AuditEntry struct {
+	ID         uint   `gorm:"primaryKey"`
+	EntityType string `gorm:"index:idx_audit_entity"`
+	EntityID   string `gorm:"index:idx_audit_entity"`
+	Operation  string
+	Actor      string
+	Timestamp  time.Time `gorm:"index"`
+	// Changes is a JSON object mapping each changed column to its old and new value
+	Changes string
+}
+
+func (AuditEntry) TableName() string {
+	return auditTableName
+}
+
+// AuditChange is the old and new value of a single column in AuditEntry.Changes.
+type AuditChange struct {
+	Old interface{} `json:"old,omitempty"`
+	New interface{} `json:"new,omitempty"`
+}
+
+var auditEnabled bool
+var auditedTables []string
+
+// EnableAudit records all creates, updates and deletes of the given models in the audit_log
+// table. If no models are passed, all models are audited. The actor of a change is taken from
+// the context passed to the Db*Context functions, see WithActor.
+func EnableAudit(models ...interface{}) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	if err := autoMigrate(&AuditEntry{}); err != nil {
+		return err
+	}
+
+	tables := make([]string, 0, len(models))
+	for _, model := range models {
+		s, err := parseSchema(model)
+		if err != nil {
+			return fmt.Errorf("egorm: Failed to enable audit for %T: %w", model, err)
+		}
+		tables = append(tables, s.Table)
+	}
+
+	auditedTables = tables
+	auditEnabled = true
+	return nil
+}
+
+// DisableAudit stops recording changes, existing entries are kept.
+func DisableAudit() {
+	auditEnabled = false
+	auditedTables = nil
+}
+
+// History returns all audit entries of the entity of type T with the given primary key,
+// ordered from oldest to newest.
+func History[T any](id interface{}) ([]AuditEntry, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	if err := autoMigrate(&AuditEntry{}); err != nil {
+		return nil, err
+	}
+
+	var tmp T
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]AuditEntry, 0)
+	err = withRetry(context.Background(), "history", func() error {
+		return Db.Where(map[string]interface{}{"entity_type": s.Name, "entity_id": fmt.Sprint(id)}).
+			Order("timestamp, id").
+			Find(&entries).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func isAudited(db *gorm.DB) bool {
+	if !auditEnabled || db.Statement.Schema == nil || db.DryRun || isInternalTable(db.Statement.Table) {
+		return false
+	}
+	if len(auditedTables) == 0 {
+		return true
+	}
+	for _, table := range auditedTables {
+		if table == db.Statement.Table {
+			return true
+		}
+	}
+	return false
+}
+
+// auditBeforeWrite loads the rows that are going to be changed, so their old values can be
+// compared once the statement was executed.
+func auditBeforeWrite(db *gorm.DB) {
+	if db.Error != nil || !isAudited(db) {
+		return
+	}
+	rows, err := loadAffectedRows(db)
+	if err != nil {
+		db.AddError(fmt.Errorf("egorm: Failed to load rows for audit: %w", err))
+		return
+	}
+	db.InstanceSet(auditOldRowsKey, rows)
+}
+
+func auditAfterCreate(db *gorm.DB) {
+	if db.Error != nil || !isAudited(db) {
+		return
+	}
+	rows, err := loadCreatedRows(db)
+	if err != nil {
+		db.AddError(fmt.Errorf("egorm: Failed to load rows for audit: %w", err))
+		return
+	}
+
+	entries := make([]AuditEntry, 0, len(rows))
+	for _, row := range rows {
+		entries = append(entries, newAuditEntry(db, AuditOperationCreate, row, diffRows(nil, row)))
+	}
+	writeAuditEntries(db, entries)
+}
+
+func auditAfterUpdate(db *gorm.DB) {
+	if db.Error != nil || !isAudited(db) {
+		return
+	}
+	oldRows, ok := db.InstanceGet(auditOldRowsKey)
+	if !ok {
+		return
+	}
+
+	entries := make([]AuditEntry, 0)
+	for _, oldRow := range oldRows.([]map[string]interface{}) {
+		newRow, err := loadRow(db, db.Statement.Schema, db.Statement.Table, oldRow)
+		if err != nil {
+			db.AddError(fmt.Errorf("egorm: Failed to load rows for audit: %w", err))
+			return
+		}
+		changes := diffRows(oldRow, newRow)
+		if len(changes) == 0 {
+			continue
+		}
+		entries = append(entries, newAuditEntry(db, AuditOperationUpdate, oldRow, changes))
+	}
+	writeAuditEntries(db, entries)
+}
+
+func auditAfterDelete(db *gorm.DB) {
+	if db.Error != nil || !isAudited(db) {
+		return
+	}
+	oldRows, ok := db.InstanceGet(auditOldRowsKey)
+	if !ok {
+		return
+	}
+
+	entries := make([]AuditEntry, 0)
+	for _, oldRow := range oldRows.([]map[string]interface{}) {
+		entries = append(entries, newAuditEntry(db, AuditOperationDelete, oldRow, diffRows(oldRow, nil)))
+	}
+	writeAuditEntries(db, entries)
+}
+
+func newAuditEntry(db *gorm.DB, operation string, row map[string]interface{}, changes map[string]AuditChange) AuditEntry {
+	encoded, err := json.Marshal(changes)
+	if err != nil {
+		db.AddError(fmt.Errorf("egorm: Failed to encode audit changes: %w", err))
+	}
+	return AuditEntry{
+		EntityType: db.Statement.Schema.Name,
+		EntityID:   rowID(db.Statement.Schema, row),
+		Operation:  operation,
+		Actor:      ActorFromContext(db.Statement.Context),
+		Timestamp:  time.Now(),
+		Changes:    string(encoded),
+	}
+}
+
+// writeAuditEntries stores the entries in the same transaction as the audited statement.
+func writeAuditEntries(db *gorm.DB, entries []AuditEntry) {
+	if db.Error != nil || len(entries) == 0 {
+		return
+	}
+	if err := db.Session(&gorm.Session{NewDB: true}).Create(&entries).Error; err != nil {
+		db.AddError(fmt.Errorf("egorm: Failed to write audit log: %w", err))
+	}
+}
+
+// diffRows returns the columns whose values differ between both rows, either may be nil.
+func diffRows(oldRow, newRow map[string]interface{}) map[string]AuditChange {
+	changes := make(map[string]AuditChange)
+	for column, oldValue := range oldRow {
+		newValue := newRow[column]
+		if newRow == nil || !reflect.DeepEqual(oldValue, newValue) {
+			changes[column] = AuditChange{Old: oldValue, New: newValue}
+		}
+	}
+	for column, newValue := range newRow {
+		if _, ok := oldRow[column]; !ok && newValue != nil {
+			changes[column] = AuditChange{New: newValue}
+		}
+	}
+	return changes
+}
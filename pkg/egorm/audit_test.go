@@ -0,0 +1,73 @@
+package egorm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type AuditSample struct {
+	gorm.Model
+	Name string
+}
+
+func TestAudit(t *testing.T) {
+	t.Run("TestAudit", func(t *testing.T) {
+		fakeSetup()
+		if err := EnableAudit(&AuditSample{}); err != nil {
+			t.Error(err)
+			return
+		}
+		defer DisableAudit()
+
+		ctx := WithActor(context.Background(), "alice")
+		sample := AuditSample{Name: "Before"}
+		if err := DbCreateContext(ctx, &sample); err != nil {
+			t.Error(err)
+			return
+		}
+
+		sample.Name = "After"
+		if err := DbSaveContext(ctx, &sample); err != nil {
+			t.Error(err)
+			return
+		}
+
+		if err := DbDeleteContext(WithActor(context.Background(), "bob"), &sample); err != nil {
+			t.Error(err)
+			return
+		}
+
+		entries, err := History[AuditSample](sample.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(entries) != 3 {
+			t.Errorf("egorm: Expected %d entries, got %d", 3, len(entries))
+			return
+		}
+
+		expected := []struct{ operation, actor string }{
+			{AuditOperationCreate, "alice"},
+			{AuditOperationUpdate, "alice"},
+			{AuditOperationDelete, "bob"},
+		}
+		for i, entry := range entries {
+			if entry.Operation != expected[i].operation || entry.Actor != expected[i].actor {
+				t.Errorf("egorm: Expected %s by %s, got %s by %s", expected[i].operation, expected[i].actor, entry.Operation, entry.Actor)
+			}
+		}
+
+		var changes map[string]AuditChange
+		if err := json.Unmarshal([]byte(entries[1].Changes), &changes); err != nil {
+			t.Error(err)
+			return
+		}
+		if changes["name"].Old != "Before" || changes["name"].New != "After" {
+			t.Errorf("egorm: Expected name to change from Before to After, got %v", changes["name"])
+		}
+	})
+}
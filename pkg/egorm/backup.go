@@ -0,0 +1,296 @@
+package egorm
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var ErrIntegrityCheck = errors.New("egorm: Integrity check failed")
+
+const snapshotPrefix = "egorm-"
+const snapshotTimeFormat = "20060102T150405.000000000Z"
+
+type backupOptions struct {
+	compress bool
+	keep     int
+}
+
+// BackupOption configures Backup and ScheduleBackups.
+type BackupOption func(*backupOptions)
+
+// Compress writes the snapshot gzip compressed.
+func Compress() BackupOption {
+	return func(o *backupOptions) {
+		o.compress = true
+	}
+}
+
+// KeepLast removes all but the last n snapshots from the backup directory after a backup.
+// Only snapshots named by Backup itself are considered.
+func KeepLast(n int) BackupOption {
+	return func(o *backupOptions) {
+		o.keep = n
+	}
+}
+
+// Backup writes a consistent snapshot of the live sqlite database with VACUUM INTO and verifies
+// it with an integrity check. If destPath is a directory, the snapshot is named after the current
+// time, e.g. egorm-20060102T150405.000000000Z.db, and KeepLast prunes older snapshots in it.
+// Backup returns the path of the snapshot.
+func Backup(ctx context.Context, destPath string, opts ...BackupOption) (string, error) {
+	if err := InitDB(); err != nil {
+		return "", err
+	}
+	if Db.Dialector.Name() != "sqlite" {
+		return "", fmt.Errorf("egorm: Backup is only supported for sqlite, not %s", Db.Dialector.Name())
+	}
+	options := backupOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	dir := ""
+	if info, err := os.Stat(destPath); err == nil && info.IsDir() {
+		dir = destPath
+		destPath = filepath.Join(dir, snapshotPrefix+time.Now().UTC().Format(snapshotTimeFormat)+".db")
+		if options.compress {
+			destPath += ".gz"
+		}
+	}
+
+	staged := destPath + ".tmp"
+	err := withRetry(ctx, "backup", func() error {
+		if err := os.Remove(staged); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return Db.WithContext(ctx).Exec("VACUUM INTO ?", staged).Error
+	})
+	if err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+	defer os.Remove(staged)
+
+	if err := checkIntegrity(staged); err != nil {
+		return "", err
+	}
+	if options.compress {
+		if err := compressFile(staged, destPath); err != nil {
+			return "", err
+		}
+	} else if err := os.Rename(staged, destPath); err != nil {
+		return "", fmt.Errorf("egorm: Failed to move snapshot to %s: %w", destPath, err)
+	}
+
+	if dir != "" && options.keep > 0 {
+		if err := pruneSnapshots(dir, options.keep); err != nil {
+			return destPath, err
+		}
+	}
+	return destPath, nil
+}
+
+// ScheduleBackups writes a snapshot into dir every interval until ctx is done. Failed backups are logged.
+// A running backup is not interrupted by ctx, the returned channel is closed once the scheduler stopped.
+func ScheduleBackups(ctx context.Context, dir string, interval time.Duration, opts ...BackupOption) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				// Interrupting VACUUM INTO leaves the sqlite connection in an open transaction
+				if _, err := Backup(context.WithoutCancel(ctx), dir, opts...); err != nil {
+					eventLog().Error("egorm: Scheduled backup failed", "dir", dir, "error", err)
+				}
+			}
+		}
+	}()
+	return done
+}
+
+// Restore replaces the live sqlite database with the snapshot at srcPath, which may be gzip
+// compressed. The snapshot is verified before the connections are closed and the file is swapped,
+// the next egorm call connects to the restored database. Queries running during the swap fail,
+// egorm calls started during the swap fail with ErrRestoring.
+func Restore(srcPath string) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	if Db.Dialector.Name() != "sqlite" {
+		return fmt.Errorf("egorm: Restore is only supported for sqlite, not %s", Db.Dialector.Name())
+	}
+	livePath, err := sqlitePath()
+	if err != nil {
+		return err
+	}
+
+	staged := livePath + ".restore"
+	defer os.Remove(staged)
+	if err := decompressFile(srcPath, staged); err != nil {
+		return err
+	}
+	if err := checkIntegrity(staged); err != nil {
+		return err
+	}
+
+	// Calls during the swap fail with ErrRestoring instead of connecting to the old file
+	restoring.Store(true)
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	defer restoring.Store(false)
+
+	if err := closeDB(); err != nil {
+		return err
+	}
+	if err := os.Rename(staged, livePath); err != nil {
+		return fmt.Errorf("egorm: Failed to swap %s: %w", livePath, err)
+	}
+	// Left over journals of the old database must not be applied to the restored one
+	for _, suffix := range []string{"-wal", "-shm", "-journal"} {
+		if err := os.Remove(livePath + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	initOnce.Do(initializeDatabaseLayer)
+	return initOnceErr
+}
+
+// VerifySnapshot checks that the snapshot at path, which may be gzip compressed, is an intact
+// sqlite database, without touching the live database.
+func VerifySnapshot(path string) error {
+	staged, err := os.CreateTemp("", "egorm-verify-*.db")
+	if err != nil {
+		return err
+	}
+	staged.Close()
+	defer os.Remove(staged.Name())
+	if err := decompressFile(path, staged.Name()); err != nil {
+		return err
+	}
+	return checkIntegrity(staged.Name())
+}
+
+// checkIntegrity runs PRAGMA integrity_check on the sqlite file at path.
+func checkIntegrity(path string) error {
+	db, err := gorm.Open(sqlite.Open(path), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to open %s: %w", path, err)
+	}
+	if sqlDB, err := db.DB(); err == nil {
+		defer sqlDB.Close()
+	}
+
+	var results []string
+	if err := db.Raw("PRAGMA integrity_check").Scan(&results).Error; err != nil {
+		return fmt.Errorf("%w for %s: %v", ErrIntegrityCheck, path, err)
+	}
+	if len(results) != 1 || results[0] != "ok" {
+		return fmt.Errorf("%w for %s: %s", ErrIntegrityCheck, path, strings.Join(results, "; "))
+	}
+	return nil
+}
+
+func compressFile(srcPath string, destPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	return writeFileAtomic(destPath, func(w io.Writer) error {
+		gz := gzip.NewWriter(w)
+		if _, err := io.Copy(gz, src); err != nil {
+			return err
+		}
+		return gz.Close()
+	})
+}
+
+// decompressFile copies srcPath to destPath and decompresses it if it is gzip compressed.
+func decompressFile(srcPath string, destPath string) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to open snapshot %s: %w", srcPath, err)
+	}
+	defer src.Close()
+
+	reader := bufio.NewReader(src)
+	var content io.Reader = reader
+	if magic, err := reader.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(reader)
+		if err != nil {
+			return fmt.Errorf("egorm: Failed to decompress snapshot %s: %w", srcPath, err)
+		}
+		defer gz.Close()
+		content = gz
+	}
+
+	return writeFileAtomic(destPath, func(w io.Writer) error {
+		_, err := io.Copy(w, content)
+		return err
+	})
+}
+
+// writeFileAtomic writes path through a temporary file, so it never contains partial content.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// pruneSnapshots removes all but the newest keep snapshots in dir.
+func pruneSnapshots(dir string, keep int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	snapshots := make([]string, 0)
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.IsDir() && strings.HasPrefix(name, snapshotPrefix) && (strings.HasSuffix(name, ".db") || strings.HasSuffix(name, ".db.gz")) {
+			snapshots = append(snapshots, name)
+		}
+	}
+	// The names start with a sortable timestamp
+	sort.Strings(snapshots)
+	for len(snapshots) > keep {
+		if err := os.Remove(filepath.Join(dir, snapshots[0])); err != nil {
+			return err
+		}
+		snapshots = snapshots[1:]
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type BackupSample struct {
+	gorm.Model
+	Name string
+}
+
+func TestBackupRestore(t *testing.T) {
+	t.Run("TestBackupRestore", func(t *testing.T) {
+		dir, err := os.MkdirTemp("", "egorm_backup")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			if err := CloseDB(); err != nil {
+				t.Error(err)
+			}
+			os.RemoveAll(dir)
+			fakeSetup()
+		}()
+
+		if err := CloseDB(); err != nil {
+			t.Error(err)
+			return
+		}
+		SetSQLiteConnectOpts(&SQLiteConnectOpts{Path: path.Join(dir, "live.sqlite")})
+
+		if err := DbCreate(&BackupSample{Name: "Before"}); err != nil {
+			t.Error(err)
+			return
+		}
+		snapshots := path.Join(dir, "snapshots")
+		if err := os.Mkdir(snapshots, 0755); err != nil {
+			t.Error(err)
+			return
+		}
+		var snapshot string
+		for i := 0; i < 3; i++ {
+			if snapshot, err = Backup(context.Background(), snapshots, Compress(), KeepLast(2)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		entries, _ := os.ReadDir(snapshots)
+		if len(entries) != 2 {
+			t.Errorf("egorm: Expected %d snapshots, got %d", 2, len(entries))
+		}
+
+		if err := EnableCache[BackupSample](time.Minute); err != nil {
+			t.Error(err)
+			return
+		}
+		defer DisableCache[BackupSample]()
+		if err := DbCreate(&BackupSample{Name: "After"}); err != nil {
+			t.Error(err)
+			return
+		}
+		cached := make([]BackupSample, 0)
+		if err := DbGet(&cached, Where{"name": "After"}); err != nil || len(cached) != 1 {
+			t.Errorf("egorm: Expected the sample after the backup, got %v, %v", cached, err)
+			return
+		}
+		if err := Restore(snapshot); err != nil {
+			t.Error(err)
+			return
+		}
+		// The cached read of the replaced database is dropped
+		cached = make([]BackupSample, 0)
+		if err := DbGet(&cached, Where{"name": "After"}); err != nil || len(cached) != 0 {
+			t.Errorf("egorm: Expected no sample after the restore, got %v, %v", cached, err)
+		}
+		var samples []BackupSample
+		if err := DbGetAll(&samples); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(samples) != 1 || samples[0].Name != "Before" {
+			t.Errorf("egorm: Expected only the sample from before the backup, got %v", samples)
+		}
+
+		// Calls during a restore fail cleanly and never reach the old database
+		stop := make(chan struct{})
+		failures := make(chan error, 100)
+		var readers sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			readers.Add(1)
+			go func() {
+				defer readers.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					var found []BackupSample
+					if err := DbGetAll(&found); err != nil && !errors.Is(err, ErrRestoring) && !strings.Contains(err.Error(), "closed") {
+						select {
+						case failures <- err:
+						default:
+						}
+					}
+				}
+			}()
+		}
+		restoreErr := Restore(snapshot)
+		close(stop)
+		readers.Wait()
+		close(failures)
+		if restoreErr != nil {
+			t.Error(restoreErr)
+			return
+		}
+		for err := range failures {
+			t.Errorf("egorm: Expected reads during the restore to fail cleanly, got %v", err)
+		}
+
+		corrupt := path.Join(dir, "corrupt.db")
+		os.WriteFile(corrupt, []byte("definitely not a database"), 0644)
+		if err := Restore(corrupt); err == nil {
+			t.Error("egorm: Expected a corrupt snapshot to be rejected")
+		}
+		if err := DbGetAll(&samples); err != nil || len(samples) != 1 {
+			t.Errorf("egorm: Expected the live database to be untouched, got %v, %v", samples, err)
+		}
+	})
+
+	t.Run("TestScheduleBackups", func(t *testing.T) {
+		fakeSetup()
+		dir, err := os.MkdirTemp("", "egorm_backup")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := ScheduleBackups(ctx, dir, 10*time.Millisecond, KeepLast(1))
+		time.Sleep(100 * time.Millisecond)
+		cancel()
+		<-done
+
+		entries, _ := os.ReadDir(dir)
+		if len(entries) < 1 {
+			t.Errorf("egorm: Expected a scheduled snapshot, got %d", len(entries))
+		}
+	})
+
+	t.Run("TestIntegrityCheck", func(t *testing.T) {
+		file, err := os.CreateTemp("", "egorm_corrupt")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer os.Remove(file.Name())
+		file.WriteString("definitely not a database")
+		file.Close()
+
+		if err := checkIntegrity(file.Name()); !errors.Is(err, ErrIntegrityCheck) {
+			t.Errorf("egorm: Expected ErrIntegrityCheck, got %v", err)
+		}
+	})
+}
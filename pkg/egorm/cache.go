@@ -0,0 +1,393 @@
+package egorm
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Cache stores the results of reads, see EnableCache. Values are JSON encoded models.
+type Cache interface {
+	Get(key string) ([]byte, bool)
+	Set(key string, value []byte, ttl time.Duration)
+	// DeletePrefix removes all entries whose key starts with prefix
+	DeletePrefix(prefix string)
+}
+
+// CacheStats counts the cache lookups since the start of the process.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+const DefaultCacheSize = 10000
+
+var cache Cache = NewLRUCache(DefaultCacheSize)
+var cachedTables = make(map[string]time.Duration)
+var cachedTablesLock sync.RWMutex
+var cacheFlights = &flightGroup{}
+var cacheGenerations = make(map[string]uint64)
+var cacheGenerationsLock sync.Mutex
+var cacheHits uint64
+var cacheMisses uint64
+
+// SetCache replaces the cache used for all types, the default is an LRUCache of DefaultCacheSize entries.
+func SetCache(c Cache) {
+	cache = c
+}
+
+// EnableCache caches the results of DbFind, DbFirst and DbGet for the type T for the given
+// duration. Entries of T are invalidated whenever T is created, saved, updated or deleted
+// in this process. Writes issued with raw SQL or by other processes are only picked up
+// once the entries expired. Reads without a tenant scope, see WithoutTenant, are not cached.
+// Since entries are JSON encoded, T must not have unexported fields, fields hidden from JSON
+// or Encrypted fields, whose plaintext would be kept in memory.
+func EnableCache[T any](ttl time.Duration) error {
+	table, err := tableOf[T]()
+	if err != nil {
+		return err
+	}
+	modelType := reflect.TypeOf((*T)(nil)).Elem()
+	if err := checkCacheable(modelType, make(map[reflect.Type]bool)); err != nil {
+		return fmt.Errorf("egorm: Cannot cache %s: %w", modelType.Name(), err)
+	}
+	cachedTablesLock.Lock()
+	defer cachedTablesLock.Unlock()
+	cachedTables[table] = ttl
+	return nil
+}
+
+// DisableCache stops caching reads of the type T and drops its entries.
+func DisableCache[T any]() error {
+	table, err := tableOf[T]()
+	if err != nil {
+		return err
+	}
+	cachedTablesLock.Lock()
+	defer cachedTablesLock.Unlock()
+	delete(cachedTables, table)
+	invalidateCache(table)
+	return nil
+}
+
+// checkCacheable fails if values of type t change when they are encoded to JSON and back.
+// Types with their own JSON encoding are trusted, except for Encrypted types.
+func checkCacheable(t reflect.Type, seen map[reflect.Type]bool) error {
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Slice, reflect.Array, reflect.Map:
+		return checkCacheable(t.Elem(), seen)
+	case reflect.Struct:
+	default:
+		return nil
+	}
+	if seen[t] {
+		return nil
+	}
+	seen[t] = true
+	if t.Implements(encryptedValueType) || reflect.PointerTo(t).Implements(encryptedValueType) {
+		return fmt.Errorf("%s is encrypted", t)
+	}
+	if t.Implements(jsonMarshalerType) || reflect.PointerTo(t).Implements(jsonMarshalerType) {
+		return nil
+	}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() && !field.Anonymous {
+			return fmt.Errorf("field %s of %s is unexported", field.Name, t)
+		}
+		if field.Tag.Get("json") == "-" {
+			return fmt.Errorf("field %s of %s is hidden from JSON", field.Name, t)
+		}
+		if err := checkCacheable(field.Type, seen); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var jsonMarshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// GetCacheStats returns the cache counters.
+func GetCacheStats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&cacheHits),
+		Misses: atomic.LoadUint64(&cacheMisses),
+	}
+}
+
+func tableOf[T any]() (string, error) {
+	if err := InitDB(); err != nil {
+		return "", err
+	}
+	var tmp T
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return "", err
+	}
+	return s.Table, nil
+}
+
+func cacheTTL(table string) (time.Duration, bool) {
+	cachedTablesLock.RLock()
+	defer cachedTablesLock.RUnlock()
+	ttl, ok := cachedTables[table]
+	return ttl, ok
+}
+
+// cacheKey builds the key of a read from the table, the kind of read, its condition and
+// the tenant of the context, so tenants never see each others entries. The parts are JSON
+// encoded together with the types of the tenant and the condition, so values containing
+// separators or values of different types never share a key. Conditions which cannot be
+// encoded return false.
+func cacheKey(ctx context.Context, table string, operation string, condition interface{}) (string, bool) {
+	var where map[string]interface{}
+	switch typed := condition.(type) {
+	case Where:
+		where = typed
+	case map[string]interface{}:
+		where = typed
+	}
+	if where != nil {
+		lowered := make(map[string]interface{}, len(where))
+		for column, value := range where {
+			lowered[strings.ToLower(column)] = value
+		}
+		condition = Where(lowered)
+	}
+	tenant := TenantFromContext(ctx)
+	encoded, err := json.Marshal([]interface{}{operation, fmt.Sprintf("%T", tenant), tenant, fmt.Sprintf("%T", condition), condition})
+	if err != nil {
+		return "", false
+	}
+	return table + "|" + string(encoded), true
+}
+
+// cachedRead serves dest from the cache if caching is enabled for its type, and calls load
+// otherwise. Concurrent misses of the same key are collapsed into a single load.
+func cachedRead[V any](ctx context.Context, dest *V, operation string, condition interface{}, load func(dest *V) error) error {
+	s, err := parseSchema(dest)
+	if err != nil {
+		return err
+	}
+	ttl, ok := cacheTTL(s.Table)
+	if !ok {
+		return load(dest)
+	}
+	if tenancyEnabled && lookupTenantField(s) != nil {
+		// Bypasses are logged and audited by the query callbacks, so they always go to the database
+		if _, bypass := ctx.Value(tenantBypassContextKey).(tenantBypass); bypass {
+			return load(dest)
+		}
+		if TenantFromContext(ctx) == nil {
+			return fmt.Errorf("%w for %s", ErrMissingTenant, s.Name)
+		}
+	}
+	key, ok := cacheKey(ctx, s.Table, operation, condition)
+	if !ok {
+		return load(dest)
+	}
+
+	if encoded, ok := cache.Get(key); ok {
+		atomic.AddUint64(&cacheHits, 1)
+		return json.Unmarshal(encoded, dest)
+	}
+	atomic.AddUint64(&cacheMisses, 1)
+
+	// Loads which started before a write never join or store over loads after it
+	generation := cacheGeneration(s.Table)
+	encoded, err := cacheFlights.do(fmt.Sprintf("%d|%s", generation, key), func() ([]byte, error) {
+		var loaded V
+		if err := load(&loaded); err != nil {
+			return nil, err
+		}
+		// A missing row is not cached and leaves dest unchanged, like an uncached read
+		if value := reflect.ValueOf(loaded); value.Kind() == reflect.Struct && value.IsZero() {
+			return nil, nil
+		}
+		encoded, err := json.Marshal(loaded)
+		if err != nil {
+			return nil, err
+		}
+		storeCached(s.Table, generation, key, encoded, ttl)
+		return encoded, nil
+	})
+	if err != nil || encoded == nil {
+		return err
+	}
+	return json.Unmarshal(encoded, dest)
+}
+
+func cacheGeneration(table string) uint64 {
+	cacheGenerationsLock.Lock()
+	defer cacheGenerationsLock.Unlock()
+	return cacheGenerations[table]
+}
+
+// storeCached stores the entry unless the table was invalidated since the generation was read.
+func storeCached(table string, generation uint64, key string, value []byte, ttl time.Duration) {
+	cacheGenerationsLock.Lock()
+	defer cacheGenerationsLock.Unlock()
+	if cacheGenerations[table] == generation {
+		cache.Set(key, value, ttl)
+	}
+}
+
+func invalidateCache(table string) {
+	cacheGenerationsLock.Lock()
+	defer cacheGenerationsLock.Unlock()
+	cacheGenerations[table]++
+	cache.DeletePrefix(table + "|")
+}
+
+// invalidateAllCaches drops the entries of all cached tables, e.g. once the database was replaced.
+func invalidateAllCaches() {
+	cachedTablesLock.RLock()
+	tables := make([]string, 0, len(cachedTables))
+	for table := range cachedTables {
+		tables = append(tables, table)
+	}
+	cachedTablesLock.RUnlock()
+	for _, table := range tables {
+		invalidateCache(table)
+	}
+}
+
+func cacheAfterWrite(db *gorm.DB) {
+	if db.Statement.Schema == nil {
+		return
+	}
+	table := db.Statement.Table
+	if _, ok := cacheTTL(table); !ok {
+		return
+	}
+	invalidateCache(table)
+	// Reads until the commit still load the old rows, so they are invalidated again afterwards
+	events, _ := db.Statement.Context.Value(pendingEventsContextKey).(*pendingEvents)
+	if _, inTransaction := db.Statement.ConnPool.(gorm.TxCommitter); inTransaction && events != nil {
+		events.add(func() {
+			invalidateCache(table)
+		})
+	}
+}
+
+// flightGroup collapses concurrent calls with the same key into one.
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done  sync.WaitGroup
+	value []byte
+	err   error
+}
+
+func (g *flightGroup) do(key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.lock.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	if running, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		running.done.Wait()
+		return running.value, running.err
+	}
+	f := &flight{}
+	f.done.Add(1)
+	g.calls[key] = f
+	g.lock.Unlock()
+
+	f.value, f.err = fn()
+	f.done.Done()
+
+	g.lock.Lock()
+	delete(g.calls, key)
+	g.lock.Unlock()
+	return f.value, f.err
+}
+
+// LRUCache is an in-memory Cache that evicts the least recently used entries once it is full.
+type LRUCache struct {
+	lock     sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List
+}
+
+type lruEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func NewLRUCache(capacity int) *LRUCache {
+	return &LRUCache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+func (c *LRUCache) Get(key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return entry.value, true
+}
+
+func (c *LRUCache) Set(key string, value []byte, ttl time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	if element, ok := c.entries[key]; ok {
+		element.Value = &lruEntry{key: key, value: value, expires: expires}
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&lruEntry{key: key, value: value, expires: expires})
+	for c.capacity > 0 && c.order.Len() > c.capacity {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *LRUCache) DeletePrefix(prefix string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for key, element := range c.entries {
+		if strings.HasPrefix(key, prefix) {
+			c.remove(element)
+		}
+	}
+}
+
+// Len returns the number of entries, including expired ones that were not evicted yet.
+func (c *LRUCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+func (c *LRUCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*lruEntry).key)
+}
@@ -0,0 +1,217 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type CacheSample struct {
+	gorm.Model
+	Name string
+}
+
+func TestCache(t *testing.T) {
+	t.Run("TestCache", func(t *testing.T) {
+		fakeSetup()
+		if err := EnableCache[CacheSample](time.Minute); err != nil {
+			t.Error(err)
+			return
+		}
+		defer DisableCache[CacheSample]()
+
+		sample := CacheSample{Name: "Cached"}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var found CacheSample
+		if err := DbFind(&found, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+
+		// Raw statements bypass the invalidation, so the cached entry is still served
+		if err := Db.Exec("UPDATE cache_samples SET name = ? WHERE id = ?", "Raw", sample.ID).Error; err != nil {
+			t.Error(err)
+			return
+		}
+		before := GetCacheStats()
+		if err := DbFind(&found, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Name != "Cached" {
+			t.Errorf("egorm: Expected cached name %s, got %s", "Cached", found.Name)
+		}
+		if hits := GetCacheStats().Hits - before.Hits; hits != 1 {
+			t.Errorf("egorm: Expected %d cache hit, got %d", 1, hits)
+		}
+
+		sample.Name = "Saved"
+		if err := DbSave(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbFind(&found, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Name != "Saved" {
+			t.Errorf("egorm: Expected name %s after invalidation, got %s", "Saved", found.Name)
+		}
+
+		// Missing rows leave the input unchanged, also when read a second time
+		for i := 0; i < 2; i++ {
+			missing := CacheSample{Name: "Unchanged"}
+			if err := DbFind(&missing, 4242); err != nil {
+				t.Error(err)
+				return
+			}
+			if missing.Name != "Unchanged" {
+				t.Errorf("egorm: Expected a missing row to leave the input unchanged, got %+v", missing)
+			}
+			missing = CacheSample{Name: "Unchanged"}
+			if err := DbFirst(&missing, Where{"name": "missing"}); err != nil {
+				t.Error(err)
+				return
+			}
+			if missing.Name != "Unchanged" {
+				t.Errorf("egorm: Expected a missing first row to leave the input unchanged, got %+v", missing)
+			}
+		}
+	})
+}
+
+type CachedTenantSample struct {
+	gorm.Model
+	TenantID string
+	Name     string
+}
+
+type UncacheableSample struct {
+	gorm.Model
+	Secret string `json:"-"`
+}
+
+func TestCacheTenants(t *testing.T) {
+	t.Run("TestCacheTenants", func(t *testing.T) {
+		fakeSetup()
+		EnableTenancy()
+		defer DisableTenancy()
+		if err := EnableCache[CachedTenantSample](time.Minute); err != nil {
+			t.Error(err)
+			return
+		}
+		defer DisableCache[CachedTenantSample]()
+
+		if err := DbCreateContext(WithTenant(context.Background(), "a"), &CachedTenantSample{Name: "secret-of-a"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var rows []CachedTenantSample
+		if err := DbGetContext(WithoutTenant(context.Background(), "maintenance"), &rows, Where{"name": "secret-of-a"}); err != nil || len(rows) != 1 {
+			t.Errorf("egorm: Expected %d row without tenant scope, got %d, %v", 1, len(rows), err)
+		}
+		rows = nil
+		if err := DbGetContext(context.Background(), &rows, Where{"name": "secret-of-a"}); !errors.Is(err, ErrMissingTenant) || len(rows) != 0 {
+			t.Errorf("egorm: Expected ErrMissingTenant, got %d rows, %v", len(rows), err)
+		}
+		if err := DbGetContext(WithTenant(context.Background(), "b"), &rows, Where{"name": "secret-of-a"}); err != nil || len(rows) != 0 {
+			t.Errorf("egorm: Expected no rows of tenant b, got %d, %v", len(rows), err)
+		}
+	})
+
+	t.Run("TestCacheKeys", func(t *testing.T) {
+		ctx := context.Background()
+		distinct := []struct {
+			ctx       context.Context
+			condition interface{}
+		}{
+			{ctx, Where{"a": "1&b=2"}},
+			{ctx, Where{"a": 1, "b": 2}},
+			{ctx, Where{"a": "1"}},
+			{WithTenant(ctx, 1), Where{"a": "1"}},
+			{WithTenant(ctx, "1"), Where{"a": "1"}},
+		}
+		keys := make(map[string]bool)
+		for _, tc := range distinct {
+			key, ok := cacheKey(tc.ctx, "samples", "get", tc.condition)
+			if !ok || keys[key] {
+				t.Errorf("egorm: Expected a distinct key for %v, got %s", tc.condition, key)
+			}
+			keys[key] = true
+		}
+		lower, _ := cacheKey(ctx, "samples", "get", Where{"Name": "x"})
+		if key, _ := cacheKey(ctx, "samples", "get", Where{"name": "x"}); key != lower {
+			t.Errorf("egorm: Expected the same key regardless of the column case, got %s and %s", key, lower)
+		}
+	})
+
+	t.Run("TestUncacheable", func(t *testing.T) {
+		if err := EnableCache[UncacheableSample](time.Minute); err == nil {
+			t.Error("egorm: Expected an error for a model with a field hidden from JSON")
+		}
+	})
+}
+
+func TestLRUCache(t *testing.T) {
+	t.Run("TestLRUCache", func(t *testing.T) {
+		c := NewLRUCache(2)
+		c.Set("a|1", []byte("1"), 0)
+		c.Set("a|2", []byte("2"), 0)
+		c.Get("a|1")
+		c.Set("b|3", []byte("3"), 0)
+		if _, ok := c.Get("a|2"); ok {
+			t.Error("egorm: Expected least recently used entry to be evicted")
+		}
+		if _, ok := c.Get("a|1"); !ok {
+			t.Error("egorm: Expected recently used entry to be kept")
+		}
+
+		c.DeletePrefix("a|")
+		if c.Len() != 1 {
+			t.Errorf("egorm: Expected %d entry, got %d", 1, c.Len())
+		}
+
+		c.Set("c|4", []byte("4"), time.Nanosecond)
+		time.Sleep(time.Millisecond)
+		if _, ok := c.Get("c|4"); ok {
+			t.Error("egorm: Expected expired entry to be dropped")
+		}
+	})
+}
+
+func TestFlightGroup(t *testing.T) {
+	t.Run("TestFlightGroup", func(t *testing.T) {
+		group := &flightGroup{}
+		var calls int32
+		release := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				group.do("key", func() ([]byte, error) {
+					atomic.AddInt32(&calls, 1)
+					<-release
+					return []byte("value"), nil
+				})
+			}()
+		}
+		// Give all goroutines the chance to join the running call
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		if calls := atomic.LoadInt32(&calls); calls != 1 {
+			t.Errorf("egorm: Expected %d call, got %d", 1, calls)
+		}
+	})
+}
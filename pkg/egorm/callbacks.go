@@ -0,0 +1,58 @@
+package egorm
+
+import (
+	"gorm.io/gorm"
+)
+
+// internalTables are managed by egorm itself and skipped by the egorm callbacks.
+var internalTables = []string{auditTableName, migrationTableName, outboxTableName, jobsTableName, locksTableName, kvTableName}
+
+// registerCallbacks installs the egorm callbacks into gorm, so features like the audit log
+// also apply to writes that are issued through gorm directly, e.g. inside DbTransaction.
+// Callbacks at the same position run in the order of their chain.
+func registerCallbacks(db *gorm.DB) error {
+	callbacks := db.Callback()
+	for _, err := range []error{
+		// The query log capture has to exist before any SQL is built
+		callbacks.Create().Before("*").Register("egorm:query_log_create", queryLogBeforeStatement),
+		callbacks.Query().Before("*").Register("egorm:query_log_query", queryLogBeforeStatement),
+		callbacks.Row().Before("*").Register("egorm:query_log_row", queryLogBeforeStatement),
+		callbacks.Raw().Before("*").Register("egorm:query_log_raw", queryLogBeforeStatement),
+		callbacks.Update().Before("*").Register("egorm:query_log_update", queryLogBeforeStatement),
+		callbacks.Delete().Before("*").Register("egorm:query_log_delete", queryLogBeforeStatement),
+
+		// gorm's sorting of callbacks breaks down with many callbacks at the same position, so the
+		// egorm callbacks of a position are chained in one callback with an explicit order.
+		// Tenant scoping has to run first, so all following callbacks only see the rows of the tenant.
+		// Validation runs after the model hooks, the typed hooks and tenant scoping, which may fill fields.
+		// The typed after-hooks run last, once the write succeeded.
+		callbacks.Create().Before("gorm:create").Register("egorm:before_create",
+			chainCallbacks(tenantBeforeCreate, hooksBeforeCreate, validateBeforeCreate, blindIndexBeforeWrite)),
+		callbacks.Create().After("gorm:create").Register("egorm:after_create",
+			chainCallbacks(auditAfterCreate, cacheAfterWrite, hooksAfterCreate)),
+		callbacks.Query().Before("gorm:query").Register("egorm:before_query", tenantBeforeQuery),
+		callbacks.Row().Before("gorm:row").Register("egorm:before_row", tenantBeforeQuery),
+		callbacks.Update().Before("gorm:update").Register("egorm:before_update",
+			chainCallbacks(tenantBeforeWrite, hooksBeforeUpdate, validateBeforeUpdate, blindIndexBeforeWrite, auditBeforeWrite, historyBeforeWrite)),
+		callbacks.Update().After("gorm:update").Register("egorm:after_update",
+			chainCallbacks(auditAfterUpdate, cacheAfterWrite, hooksAfterUpdate)),
+		callbacks.Delete().Before("gorm:delete").Register("egorm:before_delete",
+			chainCallbacks(tenantBeforeWrite, hooksBeforeDelete, auditBeforeWrite, historyBeforeWrite)),
+		callbacks.Delete().After("gorm:delete").Register("egorm:after_delete",
+			chainCallbacks(auditAfterDelete, cacheAfterWrite, hooksAfterDelete)),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chainCallbacks runs the callbacks in order.
+func chainCallbacks(fns ...func(*gorm.DB)) func(*gorm.DB) {
+	return func(db *gorm.DB) {
+		for _, fn := range fns {
+			fn(db)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package egorm
+
+import "context"
+
+type contextKey int
+
+const (
+	actorContextKey contextKey = iota
+	tenantContextKey
+	tenantBypassContextKey
+	usePrimaryContextKey
+	queryCaptureContextKey
+	pendingEventsContextKey
+)
+
+// WithActor returns a context that records the given actor, e.g. a user name, as the
+// author of all writes issued with it.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorContextKey, actor)
+}
+
+// ActorFromContext returns the actor of the context or an empty string.
+func ActorFromContext(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	actor, _ := ctx.Value(actorContextKey).(string)
+	return actor
+}
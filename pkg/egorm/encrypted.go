@@ -0,0 +1,399 @@
+package egorm
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrNoKeyring is returned when encrypted fields are used without a keyring, see SetKeyring.
+var ErrNoKeyring = errors.New("egorm: no keyring configured")
+
+// Keyring holds the AES keys used for Encrypted fields. Values are always sealed with the
+// current key, older keys are kept to open values that were not re-encrypted yet.
+type Keyring struct {
+	// Current is the ID of the key used to seal new values
+	Current string
+	// Keys maps key IDs to AES keys of 16, 24 or 32 bytes
+	Keys map[string][]byte
+	// IndexKey is the HMAC key for blind indexes, it must not change when rotating Keys
+	IndexKey []byte
+}
+
+var keyring *Keyring
+
+// SetKeyring sets the keys used for Encrypted fields.
+func SetKeyring(k *Keyring) {
+	keyring = k
+}
+
+// KeyringFromEnv reads a keyring from the env. EGORM_ENCRYPTION_KEYS is a comma separated list
+// of id:base64key pairs, EGORM_ENCRYPTION_KEY_ID the ID of the current key and
+// EGORM_BLIND_INDEX_KEY the base64 encoded key for blind indexes.
+func KeyringFromEnv() (*Keyring, error) {
+	k := &Keyring{
+		Current: os.Getenv("EGORM_ENCRYPTION_KEY_ID"),
+		Keys:    make(map[string][]byte),
+	}
+	for _, entry := range splitList(os.Getenv("EGORM_ENCRYPTION_KEYS")) {
+		id, encoded, found := strings.Cut(entry, ":")
+		if !found {
+			return nil, fmt.Errorf("egorm: Invalid encryption key %q, expected id:base64key", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("egorm: Invalid encryption key %s: %w", id, err)
+		}
+		k.Keys[id] = key
+	}
+	if _, ok := k.Keys[k.Current]; !ok {
+		return nil, fmt.Errorf("egorm: Current encryption key %q not found in EGORM_ENCRYPTION_KEYS", k.Current)
+	}
+
+	indexKey, err := base64.StdEncoding.DecodeString(os.Getenv("EGORM_BLIND_INDEX_KEY"))
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Invalid blind index key: %w", err)
+	}
+	k.IndexKey = indexKey
+	return k, nil
+}
+
+// Encrypted stores a value of type T encrypted with AES-GCM. The column contains the ID of the
+// key followed by the base64 encoded nonce and ciphertext, so keys can be rotated.
+// To look up rows by the value, add a string field tagged `egorm:"blindindex:<Field>"` and
+// query it with Where{"<column>": BlindIndex(value)}.
+type Encrypted[T any] struct {
+	Data T
+	// keyID is the key the value was sealed with when it was loaded
+	keyID string
+}
+
+// NewEncrypted wraps data into an encrypted column value.
+func NewEncrypted[T any](data T) Encrypted[T] {
+	return Encrypted[T]{Data: data}
+}
+
+func (e Encrypted[T]) Value() (driver.Value, error) {
+	if keyring == nil {
+		return nil, ErrNoKeyring
+	}
+	plaintext, err := json.Marshal(e.Data)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := seal(keyring.Keys[keyring.Current], plaintext)
+	if err != nil {
+		return nil, err
+	}
+	return keyring.Current + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (e *Encrypted[T]) Scan(value interface{}) error {
+	var stored string
+	switch v := value.(type) {
+	case nil:
+		var zero T
+		e.Data = zero
+		return nil
+	case []byte:
+		stored = string(v)
+	case string:
+		stored = v
+	default:
+		return fmt.Errorf("egorm: Failed to scan %T into an encrypted column", value)
+	}
+
+	if keyring == nil {
+		return ErrNoKeyring
+	}
+	keyID, encoded, found := strings.Cut(stored, ":")
+	if !found {
+		return fmt.Errorf("egorm: Encrypted value has no key id")
+	}
+	key, ok := keyring.Keys[keyID]
+	if !ok {
+		return fmt.Errorf("egorm: Encryption key %q not found in keyring", keyID)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to decode encrypted value: %w", err)
+	}
+	plaintext, err := open(key, sealed)
+	if err != nil {
+		return err
+	}
+	e.keyID = keyID
+	return json.Unmarshal(plaintext, &e.Data)
+}
+
+// KeyID returns the ID of the key the value was sealed with when it was loaded.
+func (e Encrypted[T]) KeyID() string {
+	return e.keyID
+}
+
+func (e Encrypted[T]) plain() interface{} {
+	return e.Data
+}
+
+// encryptedValue is implemented by all Encrypted types.
+type encryptedValue interface {
+	KeyID() string
+	plain() interface{}
+}
+
+var encryptedValueType = reflect.TypeOf((*encryptedValue)(nil)).Elem()
+
+func (e Encrypted[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Data)
+}
+
+func (e *Encrypted[T]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &e.Data)
+}
+
+func (Encrypted[T]) GormDataType() string {
+	return "string"
+}
+
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Error creating AES cipher: %w", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Error creating GCM: %w", err)
+	}
+	nonce := make([]byte, aesgcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, fmt.Errorf("egorm: Error generating nonce: %w", err)
+	}
+	return append(nonce, aesgcm.Seal(nil, nonce, plaintext, nil)...), nil
+}
+
+func open(key []byte, sealed []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Error creating AES cipher for decryption: %w", err)
+	}
+	aesgcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Error creating GCM for decryption: %w", err)
+	}
+	if len(sealed) < aesgcm.NonceSize() {
+		return nil, fmt.Errorf("egorm: Encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:aesgcm.NonceSize()], sealed[aesgcm.NonceSize():]
+	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Error decrypting data: %w", err)
+	}
+	return plaintext, nil
+}
+
+// BlindIndex returns the HMAC of the value, which is stored in blind index fields and allows to
+// look up encrypted values by equality.
+func BlindIndex(value interface{}) (string, error) {
+	if keyring == nil {
+		return "", ErrNoKeyring
+	}
+	if len(keyring.IndexKey) == 0 {
+		return "", fmt.Errorf("egorm: Keyring has no blind index key")
+	}
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, keyring.IndexKey)
+	mac.Write(plaintext)
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// blindIndexFields maps the blind index fields of the schema to the fields they index.
+func blindIndexFields(s *schema.Schema) map[*schema.Field]*schema.Field {
+	fields := make(map[*schema.Field]*schema.Field)
+	for _, field := range s.Fields {
+		if source := egormTagValue(field, "blindindex"); source != "" {
+			if sourceField := s.LookUpField(source); sourceField != nil {
+				fields[field] = sourceField
+			}
+		}
+	}
+	return fields
+}
+
+// plainValue returns the Data of Encrypted values and the value itself otherwise.
+func plainValue(value interface{}) interface{} {
+	if encrypted, ok := value.(encryptedValue); ok {
+		return encrypted.plain()
+	}
+	return value
+}
+
+// blindIndexBeforeWrite keeps the blind index fields in sync with the fields they index.
+func blindIndexBeforeWrite(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil {
+		return
+	}
+	indexes := blindIndexFields(stmt.Schema)
+	if len(indexes) == 0 {
+		return
+	}
+
+	if values, ok := stmt.Dest.(map[string]interface{}); ok {
+		for indexField, sourceField := range indexes {
+			value, ok := values[sourceField.Name]
+			if !ok {
+				value, ok = values[sourceField.DBName]
+			}
+			if !ok {
+				continue
+			}
+			index, err := BlindIndex(plainValue(value))
+			if err != nil {
+				db.AddError(err)
+				return
+			}
+			values[indexField.DBName] = index
+		}
+		return
+	}
+
+	setIndexes := func(model reflect.Value) {
+		for indexField, sourceField := range indexes {
+			value, _ := sourceField.ValueOf(stmt.Context, model)
+			index, err := BlindIndex(plainValue(value))
+			if err != nil {
+				db.AddError(err)
+				return
+			}
+			db.AddError(indexField.Set(stmt.Context, model, index))
+		}
+	}
+
+	dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	switch dest.Kind() {
+	case reflect.Struct:
+		if dest.Type() == stmt.Schema.ModelType && dest.CanAddr() {
+			setIndexes(dest)
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < dest.Len(); i++ {
+			setIndexes(reflect.Indirect(dest.Index(i)))
+		}
+	}
+}
+
+// encryptedFields returns the fields of the schema with an Encrypted type.
+func encryptedFields(s *schema.Schema) []*schema.Field {
+	fields := make([]*schema.Field, 0)
+	for _, field := range s.Fields {
+		if field.DBName != "" && field.FieldType.Implements(encryptedValueType) {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// ReEncryptAll seals all encrypted fields of T that were sealed with another key than the
+// current key of the keyring again, e.g. after a key rotation. Rows are processed in batches
+// of batchSize, each batch in its own transaction. Returns the number of updated rows.
+func ReEncryptAll[T any](ctx context.Context, batchSize int) (int, error) {
+	if err := InitDB(); err != nil {
+		return 0, err
+	}
+	if keyring == nil {
+		return 0, ErrNoKeyring
+	}
+	var tmp T
+	if err := autoMigrate(&tmp); err != nil {
+		return 0, err
+	}
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return 0, err
+	}
+	fields := encryptedFields(s)
+	if len(fields) == 0 || len(s.PrimaryFields) != 1 {
+		return 0, fmt.Errorf("egorm: ReEncryptAll requires encrypted fields and a single primary key in %s", s.Name)
+	}
+	if batchSize <= 0 {
+		batchSize = 100
+	}
+
+	selects := make([]string, 0, len(fields))
+	for _, field := range fields {
+		selects = append(selects, field.Name)
+	}
+
+	primaryKey := s.PrimaryFields[0]
+	updated := 0
+	var lastID interface{}
+	for {
+		batch := make([]T, 0, batchSize)
+		err := withRetry(ctx, "reencrypt", func() error {
+			// Built per attempt, since a statement collects clauses while it runs
+			batch = batch[:0]
+			query := Db.WithContext(ctx).Order(clause.OrderByColumn{Column: clause.Column{Name: primaryKey.DBName}}).Limit(batchSize)
+			if lastID != nil {
+				query = query.Where(clause.Gt{Column: clause.Column{Name: primaryKey.DBName}, Value: lastID})
+			}
+			return query.Find(&batch).Error
+		})
+		if err != nil {
+			return updated, err
+		}
+		if len(batch) == 0 {
+			return updated, nil
+		}
+
+		batchUpdated := 0
+		err = DbTransactionContext(ctx, func(tx *gorm.DB) error {
+			batchUpdated = 0
+			for i := range batch {
+				row := reflect.ValueOf(&batch[i]).Elem()
+				if !needsReEncryption(ctx, fields, row) {
+					continue
+				}
+				if err := tx.Model(&batch[i]).Select(selects).Updates(&batch[i]).Error; err != nil {
+					return err
+				}
+				batchUpdated++
+			}
+			return nil
+		})
+		if err != nil {
+			return updated, err
+		}
+		updated += batchUpdated
+		lastID, _ = primaryKey.ValueOf(ctx, reflect.ValueOf(&batch[len(batch)-1]).Elem())
+	}
+}
+
+func needsReEncryption(ctx context.Context, fields []*schema.Field, row reflect.Value) bool {
+	for _, field := range fields {
+		value, _ := field.ValueOf(ctx, row)
+		if encrypted, ok := value.(encryptedValue); ok && encrypted.KeyID() != "" && encrypted.KeyID() != keyring.Current {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,90 @@
+package egorm
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type EncryptedSample struct {
+	gorm.Model
+	Token      Encrypted[string]
+	TokenIndex string `gorm:"index" egorm:"blindindex:Token"`
+}
+
+func TestEncryptedField(t *testing.T) {
+	t.Run("TestEncryptedField", func(t *testing.T) {
+		fakeSetup()
+		SetKeyring(&Keyring{
+			Current:  "k1",
+			Keys:     map[string][]byte{"k1": bytes.Repeat([]byte{1}, 32)},
+			IndexKey: bytes.Repeat([]byte{9}, 32),
+		})
+		defer SetKeyring(nil)
+
+		sample := EncryptedSample{Token: NewEncrypted("secret-token")}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbCreate(&EncryptedSample{Token: NewEncrypted("other-token")}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var stored string
+		if err := Db.Raw("SELECT token FROM encrypted_samples WHERE id = ?", sample.ID).Scan(&stored).Error; err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.HasPrefix(stored, "k1:") || strings.Contains(stored, "secret-token") {
+			t.Errorf("egorm: Expected the token to be encrypted with k1, got %s", stored)
+		}
+
+		index, err := BlindIndex("secret-token")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		var found EncryptedSample
+		if err := DbFirst(&found, Where{"token_index": index}); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.ID != sample.ID || found.Token.Data != "secret-token" {
+			t.Errorf("egorm: Expected to find the sample by its blind index, got %+v", found)
+		}
+
+		// Rotate the key, the old one is still needed to open existing values
+		SetKeyring(&Keyring{
+			Current: "k2",
+			Keys: map[string][]byte{
+				"k1": bytes.Repeat([]byte{1}, 32),
+				"k2": bytes.Repeat([]byte{2}, 32),
+			},
+			IndexKey: bytes.Repeat([]byte{9}, 32),
+		})
+		updated, err := ReEncryptAll[EncryptedSample](context.Background(), 1)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if updated != 2 {
+			t.Errorf("egorm: Expected %d re-encrypted rows, got %d", 2, updated)
+		}
+
+		if err := Db.Raw("SELECT token FROM encrypted_samples WHERE id = ?", sample.ID).Scan(&stored).Error; err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.HasPrefix(stored, "k2:") {
+			t.Errorf("egorm: Expected the token to be encrypted with k2, got %s", stored)
+		}
+		if err := DbFirst(&found, Where{"token_index": index}); err != nil || found.Token.Data != "secret-token" {
+			t.Errorf("egorm: Expected the blind index to survive the rotation, got %+v, %v", found, err)
+		}
+	})
+}
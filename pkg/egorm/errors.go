@@ -0,0 +1,221 @@
+package egorm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/glebarez/go-sqlite"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Error classes returned by egorm. Use errors.Is to check the class of an error and
+// errors.As with *DbError to get details like the violated constraint or column.
+var (
+	ErrUniqueViolation     = errors.New("egorm: unique constraint violation")
+	ErrForeignKeyViolation = errors.New("egorm: foreign key constraint violation")
+	ErrNotNullViolation    = errors.New("egorm: not null constraint violation")
+	ErrCheckViolation      = errors.New("egorm: check constraint violation")
+	ErrSerialization       = errors.New("egorm: serialization failure")
+	ErrBusy                = errors.New("egorm: database is busy")
+	ErrConnection          = errors.New("egorm: database connection failed")
+)
+
+// DbError is a database error that was mapped to one of the egorm error classes.
+type DbError struct {
+	// Kind is one of the Err* error classes, e.g. ErrUniqueViolation
+	Kind error
+	// Code is the driver specific error code, e.g. 2067 for sqlite or 23505 for postgres
+	Code       string
+	Table      string
+	Constraint string
+	Column     string
+	// Err is the original driver error
+	Err error
+}
+
+func (e *DbError) Error() string {
+	msg := e.Kind.Error()
+	if e.Constraint != "" {
+		msg += fmt.Sprintf(" on %s", e.Constraint)
+	} else if e.Column != "" {
+		msg += fmt.Sprintf(" on %s", e.Column)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+func (e *DbError) Is(target error) bool {
+	return target == e.Kind
+}
+
+func (e *DbError) Unwrap() error {
+	return e.Err
+}
+
+// sqlite result codes, see https://www.sqlite.org/rescode.html
+const (
+	sqliteBusy                  = 5
+	sqliteLocked                = 6
+	sqliteIOErr                 = 10
+	sqliteCantOpen              = 14
+	sqliteNotADB                = 26
+	sqliteConstraintCheck       = 275
+	sqliteConstraintForeignKey  = 787
+	sqliteConstraintNotNull     = 1299
+	sqliteConstraintPrimaryKey  = 1555
+	sqliteConstraintUnique      = 2067
+	sqliteConstraintRowID       = 2579
+	sqlitePrimaryResultCodeMask = 0xff
+)
+
+// Matches e.g. "UNIQUE constraint failed: users.email, users.name"
+var sqliteConstraintMsg = regexp.MustCompile(`(?:UNIQUE|NOT NULL|CHECK) constraint failed: ([^\s(]+(?:, [^\s(]+)*)`)
+
+// Matches e.g. "Key (email)=(foo@bar.com) already exists."
+var postgresKeyDetail = regexp.MustCompile(`Key \(([^)]+)\)=`)
+
+// translateError maps driver errors of sqlite and postgres to the egorm error classes.
+// Errors that can not be mapped are returned unchanged.
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var dbErr *DbError
+	if errors.As(err, &dbErr) {
+		return err
+	}
+
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		if mapped := translateSQLiteError(sqliteErr); mapped != nil {
+			return mapped
+		}
+		return err
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		if mapped := translatePostgresError(pgErr); mapped != nil {
+			return mapped
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return &DbError{Kind: ErrConnection, Err: err}
+	}
+
+	return err
+}
+
+func translateSQLiteError(sqliteErr *sqlite.Error) error {
+	code := sqliteErr.Code()
+	dbErr := &DbError{
+		Code: fmt.Sprintf("%d", code),
+		Err:  sqliteErr,
+	}
+
+	switch code {
+	case sqliteConstraintUnique, sqliteConstraintPrimaryKey, sqliteConstraintRowID:
+		dbErr.Kind = ErrUniqueViolation
+	case sqliteConstraintForeignKey:
+		dbErr.Kind = ErrForeignKeyViolation
+	case sqliteConstraintNotNull:
+		dbErr.Kind = ErrNotNullViolation
+	case sqliteConstraintCheck:
+		dbErr.Kind = ErrCheckViolation
+	default:
+		switch code & sqlitePrimaryResultCodeMask {
+		case sqliteBusy, sqliteLocked:
+			dbErr.Kind = ErrBusy
+		case sqliteCantOpen, sqliteIOErr, sqliteNotADB:
+			dbErr.Kind = ErrConnection
+		default:
+			return nil
+		}
+		return dbErr
+	}
+
+	// sqlite reports the affected columns as table.column in the message, check
+	// constraints are reported by their name
+	if match := sqliteConstraintMsg.FindStringSubmatch(sqliteErr.Error()); match != nil {
+		if dbErr.Kind == ErrCheckViolation {
+			dbErr.Constraint = match[1]
+			return dbErr
+		}
+
+		columns := make([]string, 0)
+		for _, qualified := range strings.Split(match[1], ", ") {
+			if table, column, found := strings.Cut(qualified, "."); found {
+				dbErr.Table = table
+				columns = append(columns, column)
+			} else {
+				columns = append(columns, qualified)
+			}
+		}
+		dbErr.Column = strings.Join(columns, ",")
+	}
+
+	return dbErr
+}
+
+func translatePostgresError(pgErr *pgconn.PgError) error {
+	dbErr := &DbError{
+		Code:       pgErr.Code,
+		Table:      pgErr.TableName,
+		Constraint: pgErr.ConstraintName,
+		Column:     pgErr.ColumnName,
+		Err:        pgErr,
+	}
+
+	// See https://www.postgresql.org/docs/current/errcodes-appendix.html
+	switch pgErr.Code {
+	case "23505":
+		dbErr.Kind = ErrUniqueViolation
+	case "23503":
+		dbErr.Kind = ErrForeignKeyViolation
+	case "23502":
+		dbErr.Kind = ErrNotNullViolation
+	case "23514":
+		dbErr.Kind = ErrCheckViolation
+	case "40001", "40P01":
+		dbErr.Kind = ErrSerialization
+	case "55P03":
+		dbErr.Kind = ErrBusy
+	case "53300", "57P01", "57P02", "57P03":
+		dbErr.Kind = ErrConnection
+	default:
+		if strings.HasPrefix(pgErr.Code, "08") {
+			dbErr.Kind = ErrConnection
+		} else {
+			return nil
+		}
+	}
+
+	if dbErr.Column == "" {
+		if match := postgresKeyDetail.FindStringSubmatch(pgErr.Detail); match != nil {
+			dbErr.Column = strings.ReplaceAll(match[1], ", ", ",")
+		}
+	}
+
+	return dbErr
+}
+
+// connectionError marks errors that occur while opening the database as ErrConnection,
+// unless they were already mapped to a more specific class.
+func connectionError(err error) error {
+	translated := translateError(err)
+	var dbErr *DbError
+	if errors.As(translated, &dbErr) {
+		return translated
+	}
+	return &DbError{Kind: ErrConnection, Err: err}
+}
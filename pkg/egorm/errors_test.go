@@ -0,0 +1,78 @@
+package egorm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+)
+
+type UniqueSample struct {
+	gorm.Model
+	Email string `gorm:"uniqueIndex"`
+}
+
+func TestUniqueViolation(t *testing.T) {
+	t.Run("TestUniqueViolation", func(t *testing.T) {
+		fakeSetup()
+		err := DbCreate(&UniqueSample{Email: "unique@example.com"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = DbCreate(&UniqueSample{Email: "unique@example.com"})
+		if !errors.Is(err, ErrUniqueViolation) {
+			t.Errorf("egorm: Expected ErrUniqueViolation, got %v", err)
+			return
+		}
+
+		var dbErr *DbError
+		if !errors.As(err, &dbErr) {
+			t.Errorf("egorm: Expected *DbError, got %T", err)
+			return
+		}
+		if dbErr.Column != "email" {
+			t.Errorf("egorm: Expected column %s, got %s", "email", dbErr.Column)
+		}
+	})
+}
+
+func TestTranslatePostgresError(t *testing.T) {
+	t.Run("TestTranslatePostgresError", func(t *testing.T) {
+		cases := []struct {
+			pgErr *pgconn.PgError
+			kind  error
+		}{
+			{&pgconn.PgError{Code: "23505", ConstraintName: "idx_users_email", Detail: "Key (email)=(a@b.c) already exists."}, ErrUniqueViolation},
+			{&pgconn.PgError{Code: "23503"}, ErrForeignKeyViolation},
+			{&pgconn.PgError{Code: "23502", ColumnName: "name"}, ErrNotNullViolation},
+			{&pgconn.PgError{Code: "23514"}, ErrCheckViolation},
+			{&pgconn.PgError{Code: "40001"}, ErrSerialization},
+			{&pgconn.PgError{Code: "55P03"}, ErrBusy},
+			{&pgconn.PgError{Code: "08006"}, ErrConnection},
+		}
+
+		for _, c := range cases {
+			err := translateError(c.pgErr)
+			if !errors.Is(err, c.kind) {
+				t.Errorf("egorm: Expected %v for code %s, got %v", c.kind, c.pgErr.Code, err)
+			}
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) {
+				t.Errorf("egorm: Expected the original error to be kept for code %s", c.pgErr.Code)
+			}
+		}
+
+		var dbErr *DbError
+		if errors.As(translateError(cases[0].pgErr), &dbErr) && dbErr.Column != "email" {
+			t.Errorf("egorm: Expected column %s, got %s", "email", dbErr.Column)
+		}
+
+		unknown := &pgconn.PgError{Code: "42P01"}
+		if translateError(unknown) != unknown {
+			t.Error("egorm: Expected unknown errors to be returned unchanged")
+		}
+	})
+}
@@ -0,0 +1,867 @@
+package egorm
+
+import (
+	"bufio"
+	"context"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// Format is the file format used by Export and Import.
+type Format string
+
+const (
+	// FormatCSV writes a header with the column names followed by one record per row.
+	FormatCSV Format = "csv"
+	// FormatJSONLines writes one JSON object per row, keyed by column name.
+	FormatJSONLines Format = "jsonl"
+	// FormatSQL writes one portable INSERT statement per row.
+	FormatSQL Format = "sql"
+)
+
+// DefaultImportBatchSize is the number of rows Import writes per transaction if
+// ImportOpts.BatchSize is not set.
+const DefaultImportBatchSize = 500
+
+var errImportDryRun = errors.New("egorm: Import dry run")
+
+// ImportOpts configures Import.
+type ImportOpts struct {
+	// BatchSize is the number of rows written per transaction.
+	BatchSize int
+	// Upsert updates existing rows with the same primary key instead of failing.
+	Upsert bool
+	// DryRun validates all rows against the database, but rolls back every write.
+	DryRun bool
+}
+
+// ImportRowError is the error of a single row, Row counts the data rows of the input starting at 1.
+type ImportRowError struct {
+	Row int
+	Err error
+}
+
+func (e ImportRowError) Error() string {
+	return fmt.Sprintf("Row %d: %v", e.Row, e.Err)
+}
+
+func (e ImportRowError) Unwrap() error {
+	return e.Err
+}
+
+// ImportReport summarizes an Import. Rows that fail are reported in Errors and
+// do not stop the import.
+type ImportReport struct {
+	Rows     int
+	Imported int
+	Errors   []ImportRowError
+}
+
+// Export writes all rows of T matching where to w in the given format. Values are written
+// as stored in the database, so Encrypted fields stay encrypted.
+func Export[T any](w io.Writer, format Format, where Where) error {
+	return ExportContext[T](context.Background(), w, format, where)
+}
+
+func ExportContext[T any](ctx context.Context, w io.Writer, format Format, where Where) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	var tmp T
+	if err := autoMigrate(&tmp); err != nil {
+		return err
+	}
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return err
+	}
+
+	columns := s.DBNames
+	writeRow, flush, err := newRowWriter(w, format, s.Table, columns)
+	if err != nil {
+		return err
+	}
+
+	batch := make([]T, 0)
+	err = readDB(ctx).Model(&tmp).Scopes(whereScope(where)).FindInBatches(&batch, DefaultImportBatchSize, func(tx *gorm.DB, _ int) error {
+		for i := range batch {
+			row := reflect.ValueOf(&batch[i]).Elem()
+			values := make([]interface{}, len(columns))
+			for j, column := range columns {
+				value, _ := s.FieldsByDBName[column].ValueOf(ctx, row)
+				exported, err := exportValue(value)
+				if err != nil {
+					return err
+				}
+				values[j] = exported
+			}
+			if err := writeRow(values); err != nil {
+				return err
+			}
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return translateError(err)
+	}
+	return flush()
+}
+
+// ExportTable writes all rows of the table matching where to w without a Go model, e.g. for
+// command line tools. Columns are written in the order of the table.
+func ExportTable(ctx context.Context, w io.Writer, format Format, table string, where Where) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	if _, err := tableColumns(ctx, table); err != nil {
+		return err
+	}
+	rows, err := readDB(ctx).Table(table).Scopes(whereScope(where)).Rows()
+	if err != nil {
+		return translateError(err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	writeRow, flush, err := newRowWriter(w, format, table, columns)
+	if err != nil {
+		return err
+	}
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return translateError(err)
+		}
+		exported := make([]interface{}, len(values))
+		for i, value := range values {
+			if exported[i], err = exportValue(value); err != nil {
+				return err
+			}
+		}
+		if err := writeRow(exported); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return translateError(err)
+	}
+	return flush()
+}
+
+// exportValue converts a field value into the value stored in the database.
+func exportValue(value interface{}) (interface{}, error) {
+	if valuer, ok := value.(driver.Valuer); ok {
+		if rv := reflect.ValueOf(valuer); rv.Kind() == reflect.Pointer && rv.IsNil() {
+			return nil, nil
+		}
+		var err error
+		if value, err = valuer.Value(); err != nil {
+			return nil, err
+		}
+	}
+	rv := reflect.ValueOf(value)
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	if rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return exportValue(rv.Elem().Interface())
+	}
+	switch v := value.(type) {
+	case time.Time:
+		return v.Format(time.RFC3339Nano), nil
+	case []byte:
+		return string(v), nil
+	}
+	return value, nil
+}
+
+func newRowWriter(w io.Writer, format Format, table string, columns []string) (func([]interface{}) error, func() error, error) {
+	switch format {
+	case FormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(columns); err != nil {
+			return nil, nil, err
+		}
+		writeRow := func(values []interface{}) error {
+			record := make([]string, len(values))
+			for i, value := range values {
+				if value != nil {
+					record[i] = fmt.Sprint(value)
+				}
+			}
+			return writer.Write(record)
+		}
+		flush := func() error {
+			writer.Flush()
+			return writer.Error()
+		}
+		return writeRow, flush, nil
+	case FormatJSONLines:
+		encoder := json.NewEncoder(w)
+		writeRow := func(values []interface{}) error {
+			row := make(map[string]interface{}, len(values))
+			for i, value := range values {
+				row[columns[i]] = value
+			}
+			return encoder.Encode(row)
+		}
+		return writeRow, func() error { return nil }, nil
+	case FormatSQL:
+		quoted := make([]string, len(columns))
+		for i, column := range columns {
+			quoted[i] = quoteIdentifier(column)
+		}
+		prefix := fmt.Sprintf("INSERT INTO %s (%s) VALUES (", quoteIdentifier(table), strings.Join(quoted, ", "))
+		writeRow := func(values []interface{}) error {
+			literals := make([]string, len(values))
+			for i, value := range values {
+				literals[i] = sqlLiteral(value)
+			}
+			_, err := io.WriteString(w, prefix+strings.Join(literals, ", ")+");\n")
+			return err
+		}
+		return writeRow, func() error { return nil }, nil
+	}
+	return nil, nil, fmt.Errorf("egorm: Unsupported format %q", format)
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// sqlLiteral returns value as SQL literal that is understood by sqlite and postgres.
+func sqlLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		if v {
+			return "TRUE"
+		}
+		return "FALSE"
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(v)
+	case float32:
+		return strconv.FormatFloat(float64(v), 'g', -1, 32)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	return sqlLiteral(fmt.Sprint(value))
+}
+
+// Import reads rows of T from r in the given format and creates them in batches. CSV headers
+// and JSON keys may be column or field names. Rows that cannot be decoded or written are
+// reported in the ImportReport, errors reading r abort the import.
+func Import[T any](r io.Reader, format Format, opts ImportOpts) (*ImportReport, error) {
+	return ImportContext[T](context.Background(), r, format, opts)
+}
+
+func ImportContext[T any](ctx context.Context, r io.Reader, format Format, opts ImportOpts) (*ImportReport, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	var tmp T
+	if err := autoMigrate(&tmp); err != nil {
+		return nil, err
+	}
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultImportBatchSize
+	}
+
+	readRow, err := newRowReader(r, format, s.Table, func(name string) bool {
+		field := s.LookUpField(name)
+		return field != nil && field.DBName != ""
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	decode := func(row map[string]interface{}) (T, error) {
+		var entity T
+		return entity, decodeRow(ctx, s, reflect.ValueOf(&entity).Elem(), row)
+	}
+	prepare := func(tx *gorm.DB, batch []T) *gorm.DB {
+		if opts.Upsert {
+			return tx.Clauses(clause.OnConflict{UpdateAll: true})
+		}
+		return tx
+	}
+	report, err := importRows(ctx, readRow, decode, prepare, opts)
+	if err != nil {
+		return report, err
+	}
+
+	if !opts.DryRun && report.Imported > 0 && s.PrioritizedPrimaryField != nil && s.PrioritizedPrimaryField.AutoIncrement {
+		if err := resetSequence(ctx, s.Table, s.PrioritizedPrimaryField.DBName); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// ImportTable imports rows into the table without a Go model, e.g. for command line tools.
+// Values are passed to the database as read, empty CSV values of non text columns are NULL.
+// Upserts replace the imported columns of rows with the same primary key.
+func ImportTable(ctx context.Context, r io.Reader, format Format, table string, opts ImportOpts) (*ImportReport, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = DefaultImportBatchSize
+	}
+	columns, err := tableColumns(ctx, table)
+	if err != nil {
+		return nil, err
+	}
+	primaryKeys := make([]clause.Column, 0)
+	autoIncrement := ""
+	for _, column := range columns {
+		if primaryKey, ok := column.PrimaryKey(); ok && primaryKey {
+			primaryKeys = append(primaryKeys, clause.Column{Name: column.Name()})
+			// Integer keys without sequence are fine, setval ignores the NULL of pg_get_serial_sequence
+			if isIntegerColumn(column) {
+				autoIncrement = column.Name()
+			}
+		}
+	}
+	if len(primaryKeys) != 1 {
+		autoIncrement = ""
+	}
+
+	readRow, err := newRowReader(r, format, table, func(name string) bool {
+		_, ok := columns[name]
+		return ok
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	decode := func(row map[string]interface{}) (map[string]interface{}, error) {
+		values := make(map[string]interface{}, len(row))
+		for name, value := range row {
+			column, ok := columns[name]
+			if !ok {
+				return nil, importRowError{fmt.Errorf("egorm: Unknown column %q in %s", name, table)}
+			}
+			switch v := value.(type) {
+			case json.Number:
+				value = v.String()
+			case map[string]interface{}, []interface{}:
+				encoded, err := json.Marshal(v)
+				if err != nil {
+					return nil, importRowError{err}
+				}
+				value = string(encoded)
+			case string:
+				if v == "" && !isTextColumn(column) {
+					value = nil
+				}
+			}
+			values[name] = value
+		}
+		return values, nil
+	}
+	prepare := func(tx *gorm.DB, batch []map[string]interface{}) *gorm.DB {
+		tx = tx.Table(table)
+		if !opts.Upsert {
+			return tx
+		}
+		updated := make([]string, 0)
+		for name := range columns {
+			if primaryKey, _ := columns[name].PrimaryKey(); primaryKey {
+				continue
+			}
+			for _, row := range batch {
+				if _, ok := row[name]; ok {
+					updated = append(updated, name)
+					break
+				}
+			}
+		}
+		sort.Strings(updated)
+		return tx.Clauses(clause.OnConflict{Columns: primaryKeys, DoUpdates: clause.AssignmentColumns(updated), DoNothing: len(updated) == 0})
+	}
+	report, err := importRows(ctx, readRow, decode, prepare, opts)
+	if err != nil {
+		return report, err
+	}
+
+	if !opts.DryRun && report.Imported > 0 && autoIncrement != "" {
+		if err := resetSequence(ctx, table, autoIncrement); err != nil {
+			return report, err
+		}
+	}
+	return report, nil
+}
+
+// tableColumns returns the columns of an existing table by name.
+func tableColumns(ctx context.Context, table string) (map[string]gorm.ColumnType, error) {
+	if !Db.WithContext(ctx).Migrator().HasTable(table) {
+		return nil, fmt.Errorf("egorm: Table %s does not exist", table)
+	}
+	columnTypes, err := Db.WithContext(ctx).Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	columns := make(map[string]gorm.ColumnType, len(columnTypes))
+	for _, column := range columnTypes {
+		columns[column.Name()] = column
+	}
+	return columns, nil
+}
+
+func isTextColumn(column gorm.ColumnType) bool {
+	name := strings.ToLower(column.DatabaseTypeName())
+	for _, text := range []string{"text", "char", "clob", "string", "json"} {
+		if strings.Contains(name, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func isIntegerColumn(column gorm.ColumnType) bool {
+	return strings.Contains(strings.ToLower(column.DatabaseTypeName()), "int")
+}
+
+// importRows reads, decodes and writes the rows of an import in batches.
+func importRows[T any](ctx context.Context, readRow func() (map[string]interface{}, error), decode func(map[string]interface{}) (T, error),
+	prepare func(*gorm.DB, []T) *gorm.DB, opts ImportOpts) (*ImportReport, error) {
+	report := &ImportReport{}
+	batch := make([]T, 0, opts.BatchSize)
+	rowNumbers := make([]int, 0, opts.BatchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := importBatch(ctx, batch, rowNumbers, prepare, opts, report)
+		batch = batch[:0]
+		rowNumbers = rowNumbers[:0]
+		return err
+	}
+
+	for {
+		row, err := readRow()
+		if err == io.EOF {
+			break
+		}
+		var rowErr importRowError
+		if err != nil && !errors.As(err, &rowErr) {
+			return report, err
+		}
+		report.Rows++
+		if err == nil {
+			var entity T
+			if entity, err = decode(row); err == nil {
+				batch = append(batch, entity)
+				rowNumbers = append(rowNumbers, report.Rows)
+			}
+		}
+		if err != nil {
+			report.Errors = append(report.Errors, ImportRowError{Row: report.Rows, Err: err})
+			continue
+		}
+		if len(batch) >= opts.BatchSize {
+			if err := flush(); err != nil {
+				return report, err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return report, err
+	}
+	sort.Slice(report.Errors, func(i, j int) bool {
+		return report.Errors[i].Row < report.Errors[j].Row
+	})
+	return report, nil
+}
+
+// importRowError marks errors that only affect a single row of the input.
+type importRowError struct {
+	err error
+}
+
+func (e importRowError) Error() string {
+	return e.err.Error()
+}
+
+func (e importRowError) Unwrap() error {
+	return e.err
+}
+
+// importBatch writes a batch in one transaction. If the batch fails, the rows are written
+// one by one inside savepoints to report the failing rows.
+func importBatch[T any](ctx context.Context, batch []T, rowNumbers []int, prepare func(*gorm.DB, []T) *gorm.DB, opts ImportOpts, report *ImportReport) error {
+	imported := 0
+	rowErrors := make([]ImportRowError, 0)
+	err := DbTransactionContext(ctx, func(tx *gorm.DB) error {
+		imported = 0
+		rowErrors = rowErrors[:0]
+
+		if err := tx.SavePoint("egorm_import").Error; err != nil {
+			return err
+		}
+		if err := prepare(tx, batch).Create(&batch).Error; err == nil {
+			imported = len(batch)
+		} else {
+			if err := tx.RollbackTo("egorm_import").Error; err != nil {
+				return err
+			}
+			for i := range batch {
+				if err := tx.SavePoint("egorm_import_row").Error; err != nil {
+					return err
+				}
+				if err := prepare(tx, batch[i:i+1]).Create(&batch[i]).Error; err != nil {
+					if IsRetryable(translateError(err)) {
+						return err
+					}
+					rowErrors = append(rowErrors, ImportRowError{Row: rowNumbers[i], Err: translateError(err)})
+					if err := tx.RollbackTo("egorm_import_row").Error; err != nil {
+						return err
+					}
+					continue
+				}
+				imported++
+			}
+		}
+
+		if opts.DryRun {
+			return errImportDryRun
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errImportDryRun) {
+		return err
+	}
+	report.Imported += imported
+	report.Errors = append(report.Errors, rowErrors...)
+	return nil
+}
+
+// resetSequence moves the postgres sequence of an auto increment primary key behind the
+// imported ids, so later inserts do not collide with them.
+func resetSequence(ctx context.Context, table string, column string) error {
+	if Db.Dialector.Name() != "postgres" {
+		return nil
+	}
+	return withRetry(ctx, "import", func() error {
+		return Db.WithContext(ctx).Exec(
+			fmt.Sprintf("SELECT setval(pg_get_serial_sequence(?, ?), COALESCE(MAX(%s), 1)) FROM %s", quoteIdentifier(column), quoteIdentifier(table)),
+			table, column,
+		).Error
+	})
+}
+
+// decodeRow sets the fields of entity from a row keyed by column or field name.
+func decodeRow(ctx context.Context, s *schema.Schema, entity reflect.Value, row map[string]interface{}) error {
+	for name, value := range row {
+		field := s.LookUpField(name)
+		if field == nil || field.DBName == "" {
+			return importRowError{fmt.Errorf("egorm: Unknown column %q in %s", name, s.Table)}
+		}
+		value, err := importValue(field, value)
+		if err == nil {
+			err = field.Set(ctx, entity, value)
+		}
+		if err != nil {
+			return importRowError{fmt.Errorf("egorm: Invalid value for column %s: %w", field.DBName, err)}
+		}
+	}
+	return nil
+}
+
+var importTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+// importValue converts a decoded value into a value that can be set on the field.
+func importValue(field *schema.Field, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case json.Number:
+		switch field.DataType {
+		case schema.Int, schema.Uint:
+			return v.Int64()
+		case schema.Float:
+			return v.Float64()
+		}
+		return v.String(), nil
+	case map[string]interface{}, []interface{}:
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	case string:
+		if v == "" && field.FieldType.Kind() != reflect.String {
+			return nil, nil
+		}
+		if field.DataType == schema.Time {
+			for _, layout := range importTimeLayouts {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t, nil
+				}
+			}
+			return nil, fmt.Errorf("egorm: Invalid time %q", v)
+		}
+	}
+	return value, nil
+}
+
+// newRowReader returns a function returning the next row of r, or io.EOF at the end.
+func newRowReader(r io.Reader, format Format, table string, known func(name string) bool) (func() (map[string]interface{}, error), error) {
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.FieldsPerRecord = -1
+		header, err := reader.Read()
+		if err != nil {
+			return nil, fmt.Errorf("egorm: Failed to read CSV header: %w", err)
+		}
+		for _, name := range header {
+			if !known(name) {
+				return nil, fmt.Errorf("egorm: Unknown column %q in %s", name, table)
+			}
+		}
+		return func() (map[string]interface{}, error) {
+			record, err := reader.Read()
+			if err != nil {
+				var parseErr *csv.ParseError
+				if errors.As(err, &parseErr) {
+					return nil, importRowError{err}
+				}
+				return nil, err
+			}
+			if len(record) != len(header) {
+				return nil, importRowError{fmt.Errorf("egorm: Expected %d values, got %d", len(header), len(record))}
+			}
+			row := make(map[string]interface{}, len(header))
+			for i, name := range header {
+				row[name] = record[i]
+			}
+			return row, nil
+		}, nil
+	case FormatJSONLines:
+		reader := bufio.NewReader(r)
+		return func() (map[string]interface{}, error) {
+			for {
+				line, err := reader.ReadString('\n')
+				if strings.TrimSpace(line) == "" {
+					if err != nil {
+						return nil, err
+					}
+					continue
+				}
+				if err != nil && err != io.EOF {
+					return nil, err
+				}
+				decoder := json.NewDecoder(strings.NewReader(line))
+				decoder.UseNumber()
+				row := make(map[string]interface{})
+				if err := decoder.Decode(&row); err != nil {
+					return nil, importRowError{err}
+				}
+				return row, nil
+			}
+		}, nil
+	case FormatSQL:
+		reader := bufio.NewReader(r)
+		return func() (map[string]interface{}, error) {
+			statement, err := readStatement(reader)
+			if err != nil {
+				return nil, err
+			}
+			insertTable, row, err := parseInsert(statement)
+			if err != nil {
+				return nil, importRowError{err}
+			}
+			if insertTable != table {
+				return nil, importRowError{fmt.Errorf("egorm: Expected an INSERT into %s, got %s", table, insertTable)}
+			}
+			return row, nil
+		}, nil
+	}
+	return nil, fmt.Errorf("egorm: Unsupported format %q", format)
+}
+
+// readStatement reads the next statement terminated by a semicolon outside of quotes.
+func readStatement(reader *bufio.Reader) (string, error) {
+	var statement strings.Builder
+	var quote rune
+	for {
+		char, _, err := reader.ReadRune()
+		if err != nil {
+			if err == io.EOF && strings.TrimSpace(statement.String()) != "" {
+				return "", importRowError{fmt.Errorf("egorm: Unterminated statement %q", statement.String())}
+			}
+			return "", err
+		}
+		switch {
+		case quote != 0 && char == quote:
+			quote = 0
+		case quote == 0 && (char == '\'' || char == '"'):
+			quote = char
+		case quote == 0 && char == ';':
+			return statement.String(), nil
+		}
+		if statement.Len() == 0 && quote == 0 && (char == ' ' || char == '\n' || char == '\r' || char == '\t') {
+			continue
+		}
+		statement.WriteRune(char)
+	}
+}
+
+type sqlToken struct {
+	text   string
+	quoted rune
+}
+
+func tokenizeSQL(statement string) ([]sqlToken, error) {
+	tokens := make([]sqlToken, 0)
+	runes := []rune(statement)
+	for i := 0; i < len(runes); i++ {
+		char := runes[i]
+		switch {
+		case char == ' ' || char == '\n' || char == '\r' || char == '\t':
+		case char == '(' || char == ')' || char == ',':
+			tokens = append(tokens, sqlToken{text: string(char)})
+		case char == '\'' || char == '"':
+			var text strings.Builder
+			closed := false
+			for i++; i < len(runes); i++ {
+				if runes[i] == char {
+					// A doubled quote is an escaped quote
+					if i+1 < len(runes) && runes[i+1] == char {
+						text.WriteRune(char)
+						i++
+						continue
+					}
+					closed = true
+					break
+				}
+				text.WriteRune(runes[i])
+			}
+			if !closed {
+				return nil, fmt.Errorf("egorm: Unterminated quote in %q", statement)
+			}
+			tokens = append(tokens, sqlToken{text: text.String(), quoted: char})
+		default:
+			start := i
+			for i+1 < len(runes) && !strings.ContainsRune(" \n\r\t(),'\"", runes[i+1]) {
+				i++
+			}
+			tokens = append(tokens, sqlToken{text: string(runes[start : i+1])})
+		}
+	}
+	return tokens, nil
+}
+
+// parseInsert parses an INSERT statement as written by Export with FormatSQL.
+func parseInsert(statement string) (string, map[string]interface{}, error) {
+	tokens, err := tokenizeSQL(statement)
+	if err != nil {
+		return "", nil, err
+	}
+	invalid := fmt.Errorf("egorm: Unsupported statement %q, expected INSERT INTO table (columns) VALUES (values)", statement)
+
+	position := 0
+	next := func() (sqlToken, bool) {
+		if position >= len(tokens) {
+			return sqlToken{}, false
+		}
+		position++
+		return tokens[position-1], true
+	}
+	expect := func(text string) bool {
+		token, ok := next()
+		return ok && token.quoted == 0 && strings.EqualFold(token.text, text)
+	}
+	list := func() ([]sqlToken, bool) {
+		if !expect("(") {
+			return nil, false
+		}
+		items := make([]sqlToken, 0)
+		for {
+			item, ok := next()
+			if !ok || (item.quoted == 0 && (item.text == "(" || item.text == ")" || item.text == ",")) {
+				return nil, false
+			}
+			items = append(items, item)
+			separator, ok := next()
+			if !ok || separator.quoted != 0 {
+				return nil, false
+			}
+			if separator.text == ")" {
+				return items, true
+			}
+			if separator.text != "," {
+				return nil, false
+			}
+		}
+	}
+
+	if !expect("INSERT") || !expect("INTO") {
+		return "", nil, invalid
+	}
+	table, ok := next()
+	if !ok || table.quoted == '\'' {
+		return "", nil, invalid
+	}
+	columns, ok := list()
+	if !ok || !expect("VALUES") {
+		return "", nil, invalid
+	}
+	values, ok := list()
+	if !ok || position != len(tokens) || len(values) != len(columns) {
+		return "", nil, invalid
+	}
+
+	row := make(map[string]interface{}, len(columns))
+	for i, column := range columns {
+		if column.quoted == '\'' {
+			return "", nil, invalid
+		}
+		value := values[i]
+		switch {
+		case value.quoted == '\'':
+			row[column.text] = value.text
+		case value.quoted != 0:
+			return "", nil, invalid
+		case strings.EqualFold(value.text, "NULL"):
+			row[column.text] = nil
+		case strings.EqualFold(value.text, "TRUE"):
+			row[column.text] = true
+		case strings.EqualFold(value.text, "FALSE"):
+			row[column.text] = false
+		default:
+			if _, err := strconv.ParseFloat(value.text, 64); err != nil {
+				return "", nil, invalid
+			}
+			row[column.text] = json.Number(value.text)
+		}
+	}
+	return table.text, row, nil
+}
@@ -0,0 +1,127 @@
+package egorm
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type ExportSample struct {
+	gorm.Model
+	Name     string
+	Score    int
+	Active   bool
+	Settings JSON[JSONSettings]
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []Format{FormatCSV, FormatJSONLines, FormatSQL} {
+		t.Run("TestExportImport_"+string(format), func(t *testing.T) {
+			fakeSetup()
+			if err := InitDB(); err != nil {
+				t.Error(err)
+				return
+			}
+			if err := Db.AutoMigrate(&ExportSample{}); err != nil {
+				t.Error(err)
+				return
+			}
+			Db.Unscoped().Where("1 = 1").Delete(&ExportSample{})
+			samples := []ExportSample{
+				{Name: "O'Brien", Score: 3, Active: true, Settings: NewJSON(JSONSettings{Theme: "dark"})},
+				{Name: "Line\nBreak, \"quoted\"", Score: -1},
+				{Name: "Other", Score: 7},
+			}
+			for i := range samples {
+				if err := DbCreate(&samples[i]); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+
+			var out bytes.Buffer
+			if err := Export[ExportSample](&out, format, Where{"score <": 5}); err != nil {
+				t.Error(err)
+				return
+			}
+			exported := out.String()
+
+			// Importing the rows again conflicts with the existing rows
+			report, err := Import[ExportSample](strings.NewReader(exported), format, ImportOpts{})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if report.Rows != 2 || report.Imported != 0 || len(report.Errors) != 2 || !errors.Is(report.Errors[0].Err, ErrUniqueViolation) {
+				t.Errorf("egorm: Expected 2 conflicting rows, got %+v", report)
+				return
+			}
+
+			report, err = Import[ExportSample](strings.NewReader(exported), format, ImportOpts{Upsert: true})
+			if err != nil || report.Imported != 2 || len(report.Errors) != 0 {
+				t.Errorf("egorm: Expected 2 upserted rows, got %+v, %v", report, err)
+				return
+			}
+
+			Db.Unscoped().Where("1 = 1").Delete(&ExportSample{})
+			report, err = Import[ExportSample](strings.NewReader(exported), format, ImportOpts{BatchSize: 1})
+			if err != nil || report.Imported != 2 {
+				t.Errorf("egorm: Expected 2 imported rows, got %+v, %v", report, err)
+				return
+			}
+
+			var imported []ExportSample
+			if err := DbGetAll(&imported); err != nil {
+				t.Error(err)
+				return
+			}
+			if len(imported) != 2 {
+				t.Errorf("egorm: Expected %d items, got %d", 2, len(imported))
+				return
+			}
+			first := imported[0]
+			if first.ID != samples[0].ID || first.Name != samples[0].Name || !first.Active || first.Settings.Data.Theme != "dark" ||
+				!first.CreatedAt.Equal(samples[0].CreatedAt) || first.DeletedAt.Valid {
+				t.Errorf("egorm: Expected %+v, got %+v", samples[0], first)
+			}
+			if imported[1].Name != samples[1].Name || imported[1].Score != -1 {
+				t.Errorf("egorm: Expected %+v, got %+v", samples[1], imported[1])
+			}
+		})
+	}
+
+	t.Run("TestImportDryRun", func(t *testing.T) {
+		fakeSetup()
+		if err := InitDB(); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Db.AutoMigrate(&ExportSample{}); err != nil {
+			t.Error(err)
+			return
+		}
+		Db.Unscoped().Where("1 = 1").Delete(&ExportSample{})
+		input := "Name,score,active\nFirst,1,true\nSecond,many,false\nThird,3\nFourth,4,false\n"
+		report, err := Import[ExportSample](strings.NewReader(input), FormatCSV, ImportOpts{DryRun: true})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if report.Rows != 4 || report.Imported != 2 || len(report.Errors) != 2 || report.Errors[0].Row != 2 || report.Errors[1].Row != 3 {
+			t.Errorf("egorm: Expected 2 valid and 2 invalid rows, got %+v", report)
+		}
+
+		var count int64
+		Db.Model(&ExportSample{}).Count(&count)
+		if count != 0 {
+			t.Errorf("egorm: Expected %d items after a dry run, got %d", 0, count)
+		}
+
+		if _, err := Import[ExportSample](strings.NewReader("unknown\n1\n"), FormatCSV, ImportOpts{}); err == nil {
+			t.Error("egorm: Expected unknown CSV columns to be rejected")
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package egorm
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type hookKind int
+
+const (
+	hookBeforeCreate hookKind = iota
+	hookAfterCreate
+	hookBeforeUpdate
+	hookAfterUpdate
+	hookBeforeSave
+	hookAfterSave
+	hookBeforeDelete
+	hookAfterDelete
+)
+
+type hook struct {
+	id int
+	fn func(ctx context.Context, entity interface{}) error
+}
+
+var (
+	hooksLock  sync.RWMutex
+	hooks      = make(map[reflect.Type]map[hookKind][]hook)
+	nextHookID int
+)
+
+const hookEntitiesKey = "egorm:hook_entities"
+
+// OnBeforeCreate subscribes fn to the creation of entities of type T. It runs inside the
+// transaction of the write and may modify the entity, returning an error vetoes the write.
+// Before-hooks run for all writes of T, including writes through gorm in DbTransaction.
+// The returned function unsubscribes fn.
+func OnBeforeCreate[T any](fn func(ctx context.Context, entity *T) error) func() {
+	return subscribe(hookBeforeCreate, fn)
+}
+
+// OnAfterCreate subscribes fn to created entities of type T. After-hooks run once the write
+// committed, writes inside DbTransaction emit their events after the whole transaction
+// committed, so rolled back writes never emit events. Writes inside transactions that were
+// started through gorm directly emit their events right away. Errors of after-hooks are logged.
+func OnAfterCreate[T any](fn func(ctx context.Context, entity *T) error) func() {
+	return subscribe(hookAfterCreate, fn)
+}
+
+// OnBeforeUpdate subscribes fn to updates of entities of type T, see OnBeforeCreate. For
+// partial updates, e.g. of DbUpdate, fn receives each matched row with the changes applied,
+// modifying it has no effect on the write.
+func OnBeforeUpdate[T any](fn func(ctx context.Context, entity *T) error) func() {
+	return subscribe(hookBeforeUpdate, fn)
+}
+
+// OnAfterUpdate subscribes fn to updated entities of type T, see OnAfterCreate. Updates that
+// matched no rows emit no events.
+func OnAfterUpdate[T any](fn func(ctx context.Context, entity *T) error) func() {
+	return subscribe(hookAfterUpdate, fn)
+}
+
+// OnBeforeSave subscribes fn to creates and updates of entities of type T, it runs before the
+// create or update hooks. See OnBeforeCreate.
+func OnBeforeSave[T any](fn func(ctx context.Context, entity *T) error) func() {
+	return subscribe(hookBeforeSave, fn)
+}
+
+// OnAfterSave subscribes fn to created and updated entities of type T, it runs after the
+// create or update hooks. See OnAfterCreate.
+func OnAfterSave[T any](fn func(ctx context.Context, entity *T) error) func() {
+	return subscribe(hookAfterSave, fn)
+}
+
+// OnBeforeDelete subscribes fn to deletes of entities of type T, see OnBeforeCreate. For deletes
+// by condition, fn receives each matched row.
+func OnBeforeDelete[T any](fn func(ctx context.Context, entity *T) error) func() {
+	return subscribe(hookBeforeDelete, fn)
+}
+
+// OnAfterDelete subscribes fn to deleted entities of type T, see OnAfterCreate.
+func OnAfterDelete[T any](fn func(ctx context.Context, entity *T) error) func() {
+	return subscribe(hookAfterDelete, fn)
+}
+
+func subscribe[T any](kind hookKind, fn func(ctx context.Context, entity *T) error) func() {
+	modelType := reflect.TypeOf((*T)(nil)).Elem()
+
+	hooksLock.Lock()
+	defer hooksLock.Unlock()
+	nextHookID++
+	id := nextHookID
+	if hooks[modelType] == nil {
+		hooks[modelType] = make(map[hookKind][]hook)
+	}
+	hooks[modelType][kind] = append(hooks[modelType][kind], hook{id: id, fn: func(ctx context.Context, entity interface{}) error {
+		return fn(ctx, entity.(*T))
+	}})
+
+	return func() {
+		hooksLock.Lock()
+		defer hooksLock.Unlock()
+		subscribed := hooks[modelType][kind]
+		for i, candidate := range subscribed {
+			if candidate.id == id {
+				hooks[modelType][kind] = append(subscribed[:i:i], subscribed[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// subscribedHooks returns the hooks of the kinds for the model type, in the order of the kinds.
+func subscribedHooks(modelType reflect.Type, kinds ...hookKind) []hook {
+	hooksLock.RLock()
+	defer hooksLock.RUnlock()
+	subscribed := make([]hook, 0)
+	for _, kind := range kinds {
+		subscribed = append(subscribed, hooks[modelType][kind]...)
+	}
+	return subscribed
+}
+
+// pendingEvents collects the after-hooks of the writes in a transaction until it committed.
+type pendingEvents struct {
+	lock   sync.Mutex
+	events []func()
+}
+
+func (p *pendingEvents) add(event func()) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.events = append(p.events, event)
+}
+
+func (p *pendingEvents) deliver() {
+	p.lock.Lock()
+	events := p.events
+	p.events = nil
+	p.lock.Unlock()
+	for _, event := range events {
+		event()
+	}
+}
+
+func withPendingEvents(ctx context.Context, events *pendingEvents) context.Context {
+	return context.WithValue(ctx, pendingEventsContextKey, events)
+}
+
+// withRetryAfterCommit runs the write fn like withRetry, with a ctx that collects the after-hooks
+// of its statements. They are delivered once fn succeeded, i.e. its transaction or gorm's
+// transaction of its statement committed, so failed attempts and rolled back writes emit nothing.
+func withRetryAfterCommit(ctx context.Context, operation string, fn func(ctx context.Context) error) error {
+	return withRetry(ctx, operation, func() error {
+		events := &pendingEvents{}
+		if err := fn(withPendingEvents(ctx, events)); err != nil {
+			return err
+		}
+		events.deliver()
+		return nil
+	})
+}
+
+func hooksBeforeCreate(db *gorm.DB) {
+	runBeforeHooks(db, false, []hookKind{hookBeforeSave, hookBeforeCreate}, []hookKind{hookAfterCreate, hookAfterSave})
+}
+
+func hooksBeforeUpdate(db *gorm.DB) {
+	runBeforeHooks(db, false, []hookKind{hookBeforeSave, hookBeforeUpdate}, []hookKind{hookAfterUpdate, hookAfterSave})
+}
+
+func hooksBeforeDelete(db *gorm.DB) {
+	runBeforeHooks(db, true, []hookKind{hookBeforeDelete}, []hookKind{hookAfterDelete})
+}
+
+func hooksAfterCreate(db *gorm.DB) {
+	runAfterHooks(db, hookAfterCreate, hookAfterSave)
+}
+
+func hooksAfterUpdate(db *gorm.DB) {
+	runAfterHooks(db, hookAfterUpdate, hookAfterSave)
+}
+
+func hooksAfterDelete(db *gorm.DB) {
+	runAfterHooks(db, hookAfterDelete)
+}
+
+// hasHooks reports whether hooks of the kinds are subscribed for the model of the statement.
+func hasHooks(db *gorm.DB, kinds ...hookKind) bool {
+	stmt := db.Statement
+	if stmt.Schema == nil || stmt.SkipHooks || db.DryRun || isInternalTable(stmt.Schema.Table) {
+		return false
+	}
+	return len(subscribedHooks(stmt.Schema.ModelType, kinds...)) > 0
+}
+
+// runBeforeHooks runs the before-hooks of the statement's entities and remembers the entities
+// for the after-hooks.
+func runBeforeHooks(db *gorm.DB, deleting bool, kinds []hookKind, afterKinds []hookKind) {
+	if db.Error != nil || !hasHooks(db, append(kinds, afterKinds...)...) {
+		return
+	}
+
+	entities, err := hookEntities(db, deleting)
+	if err != nil {
+		db.AddError(err)
+		return
+	}
+	db.InstanceSet(hookEntitiesKey, entities)
+	for _, entity := range entities {
+		for _, subscribed := range subscribedHooks(db.Statement.Schema.ModelType, kinds...) {
+			if err := subscribed.fn(db.Statement.Context, entity.Interface()); err != nil {
+				db.AddError(err)
+				return
+			}
+		}
+	}
+}
+
+func runAfterHooks(db *gorm.DB, kinds ...hookKind) {
+	if db.Error != nil || !hasHooks(db, kinds...) {
+		return
+	}
+	if db.Statement.RowsAffected == 0 {
+		return
+	}
+	value, ok := db.InstanceGet(hookEntitiesKey)
+	if !ok {
+		return
+	}
+
+	ctx := db.Statement.Context
+	subscribed := subscribedHooks(db.Statement.Schema.ModelType, kinds...)
+	for _, entity := range value.([]reflect.Value) {
+		// The entity may change until the event is delivered
+		copied := reflect.New(entity.Type().Elem())
+		copied.Elem().Set(entity.Elem())
+		event := func() {
+			for _, after := range subscribed {
+				if err := after.fn(ctx, copied.Interface()); err != nil {
+					eventLog().Error("egorm: After hook failed", "model", db.Statement.Schema.Name, "error", err)
+				}
+			}
+		}
+
+		events, _ := ctx.Value(pendingEventsContextKey).(*pendingEvents)
+		if _, inTransaction := db.Statement.ConnPool.(gorm.TxCommitter); inTransaction && events != nil {
+			events.add(event)
+		} else {
+			event()
+		}
+	}
+}
+
+// hookEntities returns pointers to the entities written by the statement. For partial
+// updates and deletes by condition, the matched rows are loaded and the changes applied.
+func hookEntities(db *gorm.DB, deleting bool) ([]reflect.Value, error) {
+	stmt := db.Statement
+	modelType := stmt.Schema.ModelType
+	entities := make([]reflect.Value, 0)
+
+	dest := reflect.ValueOf(stmt.Dest)
+	for dest.Kind() == reflect.Pointer && dest.Elem().Kind() == reflect.Pointer {
+		dest = dest.Elem()
+	}
+	switch {
+	case dest.Kind() == reflect.Pointer && dest.Elem().Type() == modelType && !(deleting && hasZeroPrimaryKey(stmt, dest.Elem())):
+		return append(entities, dest), nil
+	case reflect.Indirect(dest).Kind() == reflect.Slice || reflect.Indirect(dest).Kind() == reflect.Array:
+		elems := reflect.Indirect(dest)
+		for i := 0; i < elems.Len(); i++ {
+			elem := elems.Index(i)
+			if elem.Kind() != reflect.Pointer {
+				elem = elem.Addr()
+			}
+			if elem.Type().Elem() == modelType {
+				entities = append(entities, elem)
+			}
+		}
+		return entities, nil
+	}
+
+	query, ok := affectedRowsQuery(db)
+	if !ok {
+		return entities, nil
+	}
+	loaded := reflect.New(reflect.SliceOf(modelType))
+	if err := query.Find(loaded.Interface()).Error; err != nil {
+		return nil, err
+	}
+	values, _ := stmt.Dest.(map[string]interface{})
+	for i := 0; i < loaded.Elem().Len(); i++ {
+		entity := loaded.Elem().Index(i)
+		for name, value := range values {
+			field := stmt.Schema.LookUpField(name)
+			if _, isExpression := value.(clause.Expression); field == nil || isExpression {
+				continue
+			}
+			if err := field.Set(stmt.Context, entity, value); err != nil {
+				return nil, err
+			}
+		}
+		entities = append(entities, entity.Addr())
+	}
+	return entities, nil
+}
+
+// hasZeroPrimaryKey reports whether the model has no primary key, e.g. the model of
+// Db.Where("age > ?", 30).Delete(&User{}).
+func hasZeroPrimaryKey(stmt *gorm.Statement, model reflect.Value) bool {
+	for _, primaryField := range stmt.Schema.PrimaryFields {
+		if _, isZero := primaryField.ValueOf(stmt.Context, model); isZero {
+			return true
+		}
+	}
+	return len(stmt.Schema.PrimaryFields) == 0
+}
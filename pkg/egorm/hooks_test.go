@@ -0,0 +1,223 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type HookSample struct {
+	gorm.Model
+	Name  string
+	Email string
+}
+
+// UncommittedSample fails its gorm AfterCreate hook, which runs after the egorm callbacks, so
+// gorm rolls back the transaction of the create instead of committing it.
+type UncommittedSample struct {
+	gorm.Model
+	Name string
+}
+
+var errUncommitted = errors.New("uncommitted")
+
+func (s *UncommittedSample) AfterCreate(tx *gorm.DB) error {
+	if s.Name == "uncommitted" {
+		return errUncommitted
+	}
+	return nil
+}
+
+func TestHooks(t *testing.T) {
+	t.Run("TestBeforeCreate", func(t *testing.T) {
+		fakeSetup()
+		errBlocked := errors.New("blocked")
+		unsubscribe := OnBeforeCreate(func(ctx context.Context, sample *HookSample) error {
+			if sample.Name == "blocked" {
+				return errBlocked
+			}
+			sample.Email = sample.Name + "@example.com"
+			return nil
+		})
+		defer unsubscribe()
+
+		blocked := HookSample{Name: "blocked"}
+		if err := DbCreate(&blocked); !errors.Is(err, errBlocked) {
+			t.Errorf("egorm: Expected the create to be vetoed, got %v", err)
+		}
+		if blocked.ID != 0 {
+			t.Error("egorm: Expected the vetoed sample not to be created")
+		}
+
+		sample := HookSample{Name: "jane"}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		var found HookSample
+		if err := DbFind(&found, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Email != "jane@example.com" {
+			t.Errorf("egorm: Expected the hook to set the email, got %q", found.Email)
+		}
+	})
+
+	t.Run("TestAfterHooks", func(t *testing.T) {
+		fakeSetup()
+		events := make([]string, 0)
+		record := func(operation string) func(context.Context, *HookSample) error {
+			return func(ctx context.Context, sample *HookSample) error {
+				events = append(events, operation+":"+sample.Name)
+				return nil
+			}
+		}
+		for _, unsubscribe := range []func(){
+			OnAfterCreate(record("create")),
+			OnAfterUpdate(record("update")),
+			OnAfterSave(record("save")),
+			OnAfterDelete(record("delete")),
+		} {
+			defer unsubscribe()
+		}
+
+		sample := HookSample{Name: "a"}
+		if err := DbSave(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		sample.Name = "b"
+		if err := DbSave(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := DbUpdate[HookSample](sample.ID, map[string]interface{}{"name": "c"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := DbUpdate[HookSample](sample.ID+100, map[string]interface{}{"name": "missing"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbDelete(&HookSample{Model: gorm.Model{ID: sample.ID}}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		other := HookSample{Name: "d"}
+		if err := DbCreate(&other); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Db.Where("name = ?", "d").Delete(&HookSample{}).Error; err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := []string{"create:a", "save:a", "update:b", "save:b", "update:c", "save:c", "delete:", "create:d", "save:d", "delete:d"}
+		if len(events) != len(expected) {
+			t.Errorf("egorm: Expected events %v, got %v", expected, events)
+			return
+		}
+		for i := range expected {
+			if events[i] != expected[i] {
+				t.Errorf("egorm: Expected events %v, got %v", expected, events)
+				return
+			}
+		}
+	})
+
+	t.Run("TestTransaction", func(t *testing.T) {
+		fakeSetup()
+		created := 0
+		defer OnAfterCreate(func(ctx context.Context, sample *HookSample) error {
+			created++
+			return nil
+		})()
+
+		errRollback := errors.New("rollback")
+		err := DbTransaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&HookSample{Name: "rolled back"}).Error; err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) || created != 0 {
+			t.Errorf("egorm: Expected no events of a rolled back transaction, got %d, %v", created, err)
+		}
+
+		err = DbTransaction(func(tx *gorm.DB) error {
+			for _, name := range []string{"first", "second"} {
+				if err := tx.Create(&HookSample{Name: name}).Error; err != nil {
+					return err
+				}
+			}
+			if created != 0 {
+				t.Errorf("egorm: Expected no events before the commit, got %d", created)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if created != 2 {
+			t.Errorf("egorm: Expected %d events after the commit, got %d", 2, created)
+		}
+	})
+
+	t.Run("TestFailedCommit", func(t *testing.T) {
+		fakeSetup()
+		created := 0
+		defer OnAfterCreate(func(ctx context.Context, sample *UncommittedSample) error {
+			created++
+			return nil
+		})()
+
+		if err := DbCreate(&UncommittedSample{Name: "uncommitted"}); !errors.Is(err, errUncommitted) {
+			t.Errorf("egorm: Expected the commit to fail, got %v", err)
+		}
+		if created != 0 {
+			t.Errorf("egorm: Expected no events of a failed commit, got %d", created)
+		}
+		var count int64
+		if err := Db.Model(&UncommittedSample{}).Count(&count).Error; err != nil || count != 0 {
+			t.Errorf("egorm: Expected the create to be rolled back, got %d, %v", count, err)
+		}
+
+		if err := DbCreate(&UncommittedSample{Name: "committed"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if created != 1 {
+			t.Errorf("egorm: Expected %d event after the commit, got %d", 1, created)
+		}
+	})
+
+	t.Run("TestBeforeUpdate", func(t *testing.T) {
+		fakeSetup()
+		sample := HookSample{Name: "locked"}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		errLocked := errors.New("locked")
+		unsubscribe := OnBeforeUpdate(func(ctx context.Context, sample *HookSample) error {
+			if sample.Name == "renamed" {
+				return errLocked
+			}
+			return nil
+		})
+
+		if _, err := DbUpdate[HookSample](sample.ID, map[string]interface{}{"name": "renamed"}); !errors.Is(err, errLocked) {
+			t.Errorf("egorm: Expected the update to be vetoed, got %v", err)
+		}
+		unsubscribe()
+		if _, err := DbUpdate[HookSample](sample.ID, map[string]interface{}{"name": "renamed"}); err != nil {
+			t.Errorf("egorm: Expected the update to pass after unsubscribing, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,257 @@
+package egorm
+
+import (
+	"crypto/rand"
+	"database/sql/driver"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// UUIDModel is a base model like gorm.Model with a random UUID as primary key, which is
+// generated on create if it is zero. Models which define their own BeforeCreate have to
+// call the one of UUIDModel.
+type UUIDModel struct {
+	ID        UUID `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (m *UUIDModel) BeforeCreate(tx *gorm.DB) error {
+	if m.ID.IsZero() {
+		m.ID = NewUUID()
+	}
+	return nil
+}
+
+// ULIDModel is a base model like gorm.Model with a ULID as primary key, which is generated on
+// create if it is zero. ULIDs sort by their creation time, see UUIDModel for BeforeCreate.
+type ULIDModel struct {
+	ID        ULID `gorm:"primaryKey"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	DeletedAt gorm.DeletedAt `gorm:"index"`
+}
+
+func (m *ULIDModel) BeforeCreate(tx *gorm.DB) error {
+	if m.ID.IsZero() {
+		m.ID = NewULID()
+	}
+	return nil
+}
+
+// UUID is a RFC 4122 UUID, stored as its string form, or as uuid on postgres.
+type UUID [16]byte
+
+// NewUUID returns a random version 4 UUID.
+func NewUUID() UUID {
+	var u UUID
+	if _, err := rand.Read(u[:]); err != nil {
+		panic(fmt.Sprintf("egorm: Failed to read random bytes for a UUID: %s", err))
+	}
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return u
+}
+
+// ParseUUID parses a UUID in the form xxxxxxxx-xxxx-xxxx-xxxx-xxxxxxxxxxxx, with or without hyphens.
+func ParseUUID(s string) (UUID, error) {
+	var u UUID
+	if len(s) == 36 && s[8] == '-' && s[13] == '-' && s[18] == '-' && s[23] == '-' {
+		s = strings.ReplaceAll(s, "-", "")
+	}
+	if len(s) != 32 {
+		return u, fmt.Errorf("egorm: Invalid UUID %q", s)
+	}
+	if _, err := hex.Decode(u[:], []byte(s)); err != nil {
+		return u, fmt.Errorf("egorm: Invalid UUID %q: %w", s, err)
+	}
+	return u, nil
+}
+
+func (u UUID) IsZero() bool {
+	return u == UUID{}
+}
+
+func (u UUID) String() string {
+	encoded := hex.EncodeToString(u[:])
+	return encoded[:8] + "-" + encoded[8:12] + "-" + encoded[12:16] + "-" + encoded[16:20] + "-" + encoded[20:]
+}
+
+func (u UUID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *UUID) UnmarshalText(text []byte) error {
+	parsed, err := ParseUUID(string(text))
+	if err != nil {
+		return err
+	}
+	*u = parsed
+	return nil
+}
+
+func (u UUID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+func (u *UUID) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		return u.UnmarshalText([]byte(value))
+	case []byte:
+		if len(value) == len(u) {
+			copy(u[:], value)
+			return nil
+		}
+		return u.UnmarshalText(value)
+	case [16]byte:
+		*u = value
+		return nil
+	}
+	return fmt.Errorf("egorm: Cannot scan %T into a UUID", src)
+}
+
+func (UUID) GormDataType() string {
+	return string(schema.String)
+}
+
+func (UUID) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	if db.Dialector.Name() == "postgres" {
+		return "uuid"
+	}
+	return "varchar(36)"
+}
+
+// ULID is a universally unique lexicographically sortable identifier: a millisecond timestamp
+// followed by 80 random bits, stored as its 26 characters long Crockford base32 form.
+type ULID [16]byte
+
+const ulidAlphabet = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+var (
+	ulidLock sync.Mutex
+	lastULID ULID
+)
+
+// NewULID returns a ULID of the current time. ULIDs of the same millisecond are increasing
+// within the process, so they sort in the order they were created.
+func NewULID() ULID {
+	ulidLock.Lock()
+	defer ulidLock.Unlock()
+
+	var u ULID
+	ms := uint64(time.Now().UnixMilli())
+	if ms <= lastULID.timestamp() {
+		// Incrementing the whole number carries an overflow of the random part into the timestamp
+		u = lastULID
+		for i := len(u) - 1; i >= 0; i-- {
+			u[i]++
+			if u[i] != 0 {
+				break
+			}
+		}
+	} else {
+		binary.BigEndian.PutUint16(u[0:2], uint16(ms>>32))
+		binary.BigEndian.PutUint32(u[2:6], uint32(ms))
+		if _, err := rand.Read(u[6:]); err != nil {
+			panic(fmt.Sprintf("egorm: Failed to read random bytes for a ULID: %s", err))
+		}
+	}
+	lastULID = u
+	return u
+}
+
+// ParseULID parses the 26 characters long form of a ULID, ignoring the case.
+func ParseULID(s string) (ULID, error) {
+	var u ULID
+	if len(s) != 26 {
+		return u, fmt.Errorf("egorm: Invalid ULID %q", s)
+	}
+	var hi, lo uint64
+	for i, char := range strings.ToUpper(s) {
+		switch char {
+		case 'I', 'L':
+			char = '1'
+		case 'O':
+			char = '0'
+		}
+		index := strings.IndexRune(ulidAlphabet, char)
+		// The first character holds only the 3 highest of the 128 bits
+		if index < 0 || (i == 0 && index > 7) {
+			return u, fmt.Errorf("egorm: Invalid ULID %q", s)
+		}
+		hi = hi<<5 | lo>>59
+		lo = lo<<5 | uint64(index)
+	}
+	binary.BigEndian.PutUint64(u[:8], hi)
+	binary.BigEndian.PutUint64(u[8:], lo)
+	return u, nil
+}
+
+func (u ULID) timestamp() uint64 {
+	return uint64(binary.BigEndian.Uint16(u[0:2]))<<32 | uint64(binary.BigEndian.Uint32(u[2:6]))
+}
+
+// Time returns the time the ULID was created at, in milliseconds.
+func (u ULID) Time() time.Time {
+	return time.UnixMilli(int64(u.timestamp()))
+}
+
+func (u ULID) IsZero() bool {
+	return u == ULID{}
+}
+
+func (u ULID) String() string {
+	hi := binary.BigEndian.Uint64(u[:8])
+	lo := binary.BigEndian.Uint64(u[8:])
+	encoded := make([]byte, 26)
+	for i := len(encoded) - 1; i >= 0; i-- {
+		encoded[i] = ulidAlphabet[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(encoded)
+}
+
+func (u ULID) MarshalText() ([]byte, error) {
+	return []byte(u.String()), nil
+}
+
+func (u *ULID) UnmarshalText(text []byte) error {
+	parsed, err := ParseULID(string(text))
+	if err != nil {
+		return err
+	}
+	*u = parsed
+	return nil
+}
+
+func (u ULID) Value() (driver.Value, error) {
+	return u.String(), nil
+}
+
+func (u *ULID) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case string:
+		return u.UnmarshalText([]byte(value))
+	case []byte:
+		return u.UnmarshalText(value)
+	}
+	return fmt.Errorf("egorm: Cannot scan %T into a ULID", src)
+}
+
+func (ULID) GormDataType() string {
+	return string(schema.String)
+}
+
+func (ULID) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	return "varchar(26)"
+}
@@ -0,0 +1,173 @@
+package egorm
+
+import (
+	"encoding/json"
+	"sort"
+	"testing"
+	"time"
+)
+
+type UUIDSample struct {
+	UUIDModel
+	Name string
+}
+
+type ULIDSample struct {
+	ULIDModel
+	Name string
+}
+
+type CompositeKeySample struct {
+	Region string `gorm:"primaryKey"`
+	Code   string `gorm:"primaryKey"`
+	Name   string
+}
+
+func TestIDs(t *testing.T) {
+	t.Run("TestUUID", func(t *testing.T) {
+		u := NewUUID()
+		if u.IsZero() || u == NewUUID() {
+			t.Errorf("egorm: Expected distinct random UUIDs, got %s", u)
+		}
+		if version := u[6] >> 4; version != 4 {
+			t.Errorf("egorm: Expected version 4, got %d", version)
+		}
+		parsed, err := ParseUUID(u.String())
+		if err != nil || parsed != u {
+			t.Errorf("egorm: Expected %s to round trip, got %s, %v", u, parsed, err)
+		}
+		if _, err := ParseUUID("not-a-uuid"); err == nil {
+			t.Error("egorm: Expected an error for an invalid UUID")
+		}
+	})
+
+	t.Run("TestULID", func(t *testing.T) {
+		ids := make([]string, 0, 1000)
+		for i := 0; i < 1000; i++ {
+			ids = append(ids, NewULID().String())
+		}
+		if !sort.StringsAreSorted(ids) {
+			t.Error("egorm: Expected the ULIDs to sort in the order of their creation")
+		}
+
+		u := NewULID()
+		parsed, err := ParseULID(u.String())
+		if err != nil || parsed != u {
+			t.Errorf("egorm: Expected %s to round trip, got %s, %v", u, parsed, err)
+		}
+		if age := time.Since(u.Time()); age < 0 || age > time.Second {
+			t.Errorf("egorm: Expected the ULID to be created now, got %s", u.Time())
+		}
+		known, err := ParseULID("01ARZ3NDEKTSV4RRFFQ69G5FAV")
+		if err != nil || known.Time().UnixMilli() != 1469922850259 {
+			t.Errorf("egorm: Expected the time of the ULID to be decoded, got %d, %v", known.Time().UnixMilli(), err)
+		}
+		if _, err := ParseULID("81ARZ3NDEKTSV4RRFFQ69G5FAV"); err == nil {
+			t.Error("egorm: Expected an error for a ULID exceeding 128 bits")
+		}
+
+		encoded, err := json.Marshal(map[string]ULID{"id": known})
+		if err != nil || string(encoded) != `{"id":"01ARZ3NDEKTSV4RRFFQ69G5FAV"}` {
+			t.Errorf("egorm: Expected the ULID to be encoded as string, got %s, %v", encoded, err)
+		}
+	})
+
+	t.Run("TestUUIDModel", func(t *testing.T) {
+		fakeSetup()
+		sample := UUIDSample{Name: "jane"}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		if sample.ID.IsZero() {
+			t.Error("egorm: Expected the UUID to be generated")
+			return
+		}
+		var found UUIDSample
+		if err := DbFind(&found, sample.ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.ID != sample.ID || found.Name != "jane" {
+			t.Errorf("egorm: Expected the sample, got %+v", found)
+		}
+
+		if _, err := DbUpdate[UUIDSample](sample.ID, map[string]interface{}{"name": "john"}); err != nil {
+			t.Error(err)
+			return
+		}
+		found = UUIDSample{}
+		if err := DbFind(&found, sample.ID.String()); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Name != "john" {
+			t.Errorf("egorm: Expected the sample to be found by the string key, got %+v", found)
+		}
+	})
+
+	t.Run("TestULIDModel", func(t *testing.T) {
+		fakeSetup()
+		samples := []ULIDSample{{Name: "a"}, {Name: "b"}, {Name: "c"}}
+		for i := range samples {
+			if err := DbCreate(&samples[i]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		loaded := make([]ULIDSample, 0)
+		if err := Db.Order("id").Find(&loaded).Error; err != nil {
+			t.Error(err)
+			return
+		}
+		if len(loaded) != 3 || loaded[0].Name != "a" || loaded[2].Name != "c" {
+			t.Errorf("egorm: Expected the samples in the order of their creation, got %+v", loaded)
+		}
+		var found ULIDSample
+		if err := DbFind(&found, samples[1].ID); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Name != "b" {
+			t.Errorf("egorm: Expected sample b, got %+v", found)
+		}
+	})
+
+	t.Run("TestCompositeKey", func(t *testing.T) {
+		fakeSetup()
+		for _, sample := range []CompositeKeySample{{Region: "eu", Code: "a", Name: "eu-a"}, {Region: "us", Code: "a", Name: "us-a"}} {
+			if err := DbCreate(&sample); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		var found CompositeKeySample
+		if err := DbFind(&found, Where{"region": "us", "Code": "a"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Name != "us-a" {
+			t.Errorf("egorm: Expected us-a, got %+v", found)
+		}
+		found = CompositeKeySample{}
+		if err := DbFind(&found, []interface{}{"eu", "a"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Name != "eu-a" {
+			t.Errorf("egorm: Expected eu-a, got %+v", found)
+		}
+
+		if _, err := DbUpdate[CompositeKeySample]([]interface{}{"eu", "a"}, map[string]interface{}{"name": "renamed"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbFind(&found, "a"); err == nil {
+			t.Error("egorm: Expected an error for a single value of a composite key")
+		}
+		if err := DbFind(&found, Where{"region": "eu"}); err == nil {
+			t.Error("egorm: Expected an error for an incomplete composite key")
+		}
+	})
+}
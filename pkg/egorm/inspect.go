@@ -0,0 +1,113 @@
+package egorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Ping checks that the database is reachable.
+func Ping(ctx context.Context) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	sqlDB, err := Db.DB()
+	if err != nil {
+		return err
+	}
+	return translateError(sqlDB.PingContext(ctx))
+}
+
+// Tables returns the names of the tables in the database, sorted by name.
+func Tables(ctx context.Context) ([]string, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	tables, err := Db.WithContext(ctx).Migrator().GetTables()
+	if err != nil {
+		return nil, translateError(err)
+	}
+	sort.Strings(tables)
+	return tables, nil
+}
+
+// SchemaDump returns the DDL statements of all tables and indexes. sqlite returns the stored
+// statements, for postgres they are reconstructed from the catalog.
+func SchemaDump(ctx context.Context) (string, error) {
+	if err := InitDB(); err != nil {
+		return "", err
+	}
+	statements := make([]string, 0)
+	var err error
+	switch Db.Dialector.Name() {
+	case "sqlite":
+		err = Db.WithContext(ctx).Raw("SELECT sql FROM sqlite_master WHERE sql IS NOT NULL AND name NOT LIKE 'sqlite_%' ORDER BY type DESC, name").
+			Scan(&statements).Error
+	case "postgres":
+		statements, err = postgresSchemaDump(ctx)
+	default:
+		return "", fmt.Errorf("egorm: SchemaDump is not supported for %s", Db.Dialector.Name())
+	}
+	if err != nil {
+		return "", translateError(err)
+	}
+
+	var dump strings.Builder
+	for _, statement := range statements {
+		dump.WriteString(strings.TrimSuffix(strings.TrimSpace(statement), ";") + ";\n")
+	}
+	return dump.String(), nil
+}
+
+func postgresSchemaDump(ctx context.Context) ([]string, error) {
+	tables, err := Tables(ctx)
+	if err != nil {
+		return nil, err
+	}
+	statements := make([]string, 0)
+	for _, table := range tables {
+		var columns []struct {
+			ColumnName    string
+			DataType      string
+			IsNullable    string
+			ColumnDefault *string
+		}
+		err := Db.WithContext(ctx).Raw(`SELECT column_name, format_type(a.atttypid, a.atttypmod) AS data_type, is_nullable, column_default
+			FROM information_schema.columns c
+			JOIN pg_attribute a ON a.attrelid = (quote_ident(c.table_schema) || '.' || quote_ident(c.table_name))::regclass AND a.attname = c.column_name
+			WHERE c.table_schema = CURRENT_SCHEMA() AND c.table_name = ? ORDER BY c.ordinal_position`, table).Scan(&columns).Error
+		if err != nil {
+			return nil, err
+		}
+		definitions := make([]string, 0, len(columns))
+		for _, column := range columns {
+			definition := quoteIdentifier(column.ColumnName) + " " + column.DataType
+			if column.IsNullable == "NO" {
+				definition += " NOT NULL"
+			}
+			if column.ColumnDefault != nil {
+				definition += " DEFAULT " + *column.ColumnDefault
+			}
+			definitions = append(definitions, definition)
+		}
+
+		var constraints []string
+		err = Db.WithContext(ctx).Raw(`SELECT 'CONSTRAINT ' || quote_ident(conname) || ' ' || pg_get_constraintdef(oid)
+			FROM pg_constraint WHERE conrelid = ?::regclass ORDER BY contype, conname`, quoteIdentifier(table)).Scan(&constraints).Error
+		if err != nil {
+			return nil, err
+		}
+		definitions = append(definitions, constraints...)
+		statements = append(statements, fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", quoteIdentifier(table), strings.Join(definitions, ",\n  ")))
+
+		var indexes []string
+		err = Db.WithContext(ctx).Raw(`SELECT indexdef FROM pg_indexes i WHERE schemaname = CURRENT_SCHEMA() AND tablename = ?
+			AND NOT EXISTS (SELECT 1 FROM pg_constraint c WHERE c.conname = i.indexname) ORDER BY indexname`, table).Scan(&indexes).Error
+		if err != nil {
+			return nil, err
+		}
+		statements = append(statements, indexes...)
+	}
+	return statements, nil
+}
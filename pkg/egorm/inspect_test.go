@@ -0,0 +1,82 @@
+package egorm
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type InspectSample struct {
+	gorm.Model
+	Name string `gorm:"index"`
+}
+
+func TestInspect(t *testing.T) {
+	t.Run("TestInspect", func(t *testing.T) {
+		fakeSetup()
+		ctx := context.Background()
+		if err := Ping(ctx); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbCreate(&InspectSample{Name: "first"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		tables, err := Tables(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.Contains(strings.Join(tables, ","), "inspect_samples") {
+			t.Errorf("egorm: Expected inspect_samples in %v", tables)
+		}
+
+		dump, err := SchemaDump(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !strings.Contains(dump, "CREATE TABLE `inspect_samples`") || !strings.Contains(dump, "CREATE INDEX `idx_inspect_samples_name`") {
+			t.Errorf("egorm: Unexpected schema dump %s", dump)
+		}
+	})
+
+	t.Run("TestExportImportTable", func(t *testing.T) {
+		fakeSetup()
+		ctx := context.Background()
+		var out bytes.Buffer
+		if err := ExportTable(ctx, &out, FormatCSV, "inspect_samples", Where{}); err != nil {
+			t.Error(err)
+			return
+		}
+		exported := out.String()
+		if !strings.HasPrefix(exported, "id,created_at,updated_at,deleted_at,name\n1,") {
+			t.Errorf("egorm: Unexpected export %s", exported)
+		}
+
+		// The row exists, so only an upsert succeeds
+		report, err := ImportTable(ctx, strings.NewReader(strings.Replace(exported, "first", "renamed", 1)), FormatCSV, "inspect_samples", ImportOpts{})
+		if err != nil || report.Imported != 0 || len(report.Errors) != 1 {
+			t.Errorf("egorm: Expected a conflict, got %+v, %v", report, err)
+			return
+		}
+		report, err = ImportTable(ctx, strings.NewReader(strings.Replace(exported, "first", "renamed", 1)), FormatCSV, "inspect_samples", ImportOpts{Upsert: true})
+		if err != nil || report.Imported != 1 {
+			t.Errorf("egorm: Expected an upsert, got %+v, %v", report, err)
+			return
+		}
+		var sample InspectSample
+		if err := DbFind(&sample, 1); err != nil || sample.Name != "renamed" || sample.DeletedAt.Valid {
+			t.Errorf("egorm: Expected the renamed sample, got %+v, %v", sample, err)
+		}
+
+		if _, err := ImportTable(ctx, strings.NewReader("{}\n"), FormatJSONLines, "missing_table", ImportOpts{}); err == nil {
+			t.Error("egorm: Expected missing tables to be rejected")
+		}
+	})
+}
@@ -0,0 +1,522 @@
+package egorm
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const jobsTableName = "egorm_jobs"
+
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+)
+
+// ErrJobExists is returned by JobType.Enqueue together with the existing job, if a queued or
+// running job has the same unique key.
+var ErrJobExists = errors.New("egorm: job with the unique key exists")
+
+var errInvalidJobPayload = errors.New("egorm: invalid job payload")
+
+// Job is a job in the egorm_jobs table.
+type Job struct {
+	ID      uint   `gorm:"primaryKey"`
+	Kind    string `gorm:"index:idx_jobs_due"`
+	Payload string
+	// Status is one of JobStatusQueued, JobStatusRunning, JobStatusSucceeded and JobStatusFailed
+	Status   string    `gorm:"index:idx_jobs_due"`
+	Priority int       `gorm:"index:idx_jobs_due"`
+	RunAt    time.Time `gorm:"index:idx_jobs_due"`
+	// UniqueKey is released once the job finished, so the key can be enqueued again
+	UniqueKey   *string `gorm:"uniqueIndex"`
+	Attempts    int
+	MaxAttempts int
+	// LeaseID identifies the current attempt, LeasedUntil is extended by the heartbeats of the worker
+	LeaseID     string
+	LeasedUntil *time.Time
+	// Result is the JSON encoded result of a succeeded job
+	Result     string
+	LastError  string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	FinishedAt *time.Time
+}
+
+func (Job) TableName() string {
+	return jobsTableName
+}
+
+// DecodeResult unmarshals the JSON result of the job into v.
+func (j Job) DecodeResult(v interface{}) error {
+	if err := json.Unmarshal([]byte(j.Result), v); err != nil {
+		return fmt.Errorf("egorm: Failed to decode the result of job %d: %w", j.ID, err)
+	}
+	return nil
+}
+
+// jobHandler runs a job with the JSON encoded payload.
+type jobHandler func(ctx context.Context, payload string) (interface{}, error)
+
+var (
+	jobHandlersLock sync.RWMutex
+	jobHandlers     = make(map[string]jobHandler)
+	// jobsLock serializes the statements of the queue on sqlite, see withJobsLock
+	jobsLock sync.Mutex
+)
+
+// JobType is a kind of job with a payload of type T, see DefineJob.
+type JobType[T any] struct {
+	Kind string
+}
+
+// DefineJob registers handler for the jobs of kind, replacing a previous handler of the kind.
+// The result of handler is stored JSON encoded in Job.Result. Returning an error retries the
+// job, see WorkerBackoff. Jobs may run more than once, e.g. after their worker crashed, so
+// handlers have to be idempotent.
+func DefineJob[T any](kind string, handler func(ctx context.Context, payload T) (interface{}, error)) JobType[T] {
+	jobHandlersLock.Lock()
+	defer jobHandlersLock.Unlock()
+	jobHandlers[kind] = func(ctx context.Context, payload string) (interface{}, error) {
+		var decoded T
+		if err := json.Unmarshal([]byte(payload), &decoded); err != nil {
+			return nil, fmt.Errorf("%w: %s", errInvalidJobPayload, err)
+		}
+		return handler(ctx, decoded)
+	}
+	return JobType[T]{Kind: kind}
+}
+
+func jobKinds() []string {
+	jobHandlersLock.RLock()
+	defer jobHandlersLock.RUnlock()
+	kinds := make([]string, 0, len(jobHandlers))
+	for kind := range jobHandlers {
+		kinds = append(kinds, kind)
+	}
+	return kinds
+}
+
+type jobOptions struct {
+	priority    int
+	runAt       time.Time
+	uniqueKey   *string
+	maxAttempts int
+}
+
+// JobOption configures JobType.Enqueue.
+type JobOption func(*jobOptions)
+
+// JobPriority sets the priority of the job, jobs with a higher priority run first. Defaults to 0.
+func JobPriority(priority int) JobOption {
+	return func(o *jobOptions) {
+		o.priority = priority
+	}
+}
+
+// JobRunAt delays the job until runAt.
+func JobRunAt(runAt time.Time) JobOption {
+	return func(o *jobOptions) {
+		o.runAt = runAt
+	}
+}
+
+// JobUniqueKey enqueues the job only if no queued or running job has the same key.
+func JobUniqueKey(key string) JobOption {
+	return func(o *jobOptions) {
+		o.uniqueKey = &key
+	}
+}
+
+// JobMaxAttempts sets how often the job runs before it failed, defaults to 10.
+func JobMaxAttempts(attempts int) JobOption {
+	return func(o *jobOptions) {
+		o.maxAttempts = attempts
+	}
+}
+
+// Enqueue adds a job with the payload to the queue.
+func (j JobType[T]) Enqueue(ctx context.Context, payload T, opts ...JobOption) (*Job, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	if err := autoMigrate(&Job{}); err != nil {
+		return nil, err
+	}
+	var job *Job
+	err := withRetry(ctx, "create", func() error {
+		var err error
+		return withJobsLock(func() error {
+			job, err = j.EnqueueTx(Db.WithContext(ctx), payload, opts...)
+			return err
+		})
+	})
+	return job, err
+}
+
+// EnqueueTx adds a job with the payload to the queue using tx, so the job is only enqueued
+// if the transaction commits.
+func (j JobType[T]) EnqueueTx(tx *gorm.DB, payload T, opts ...JobOption) (*Job, error) {
+	options := jobOptions{runAt: time.Now(), maxAttempts: 10}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to encode the payload of job %s: %w", j.Kind, err)
+	}
+	if err := autoMigrateTx(tx, &Job{}); err != nil {
+		return nil, err
+	}
+
+	job := Job{Kind: j.Kind, Payload: string(encoded), Status: JobStatusQueued, Priority: options.priority,
+		RunAt: options.runAt, UniqueKey: options.uniqueKey, MaxAttempts: options.maxAttempts}
+	result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&job)
+	if result.Error != nil {
+		return nil, fmt.Errorf("egorm: Failed to enqueue job %s: %w", j.Kind, translateError(result.Error))
+	}
+	if result.RowsAffected == 0 && options.uniqueKey != nil {
+		existing := Job{}
+		if err := tx.Where("unique_key = ?", *options.uniqueKey).First(&existing).Error; err != nil {
+			return nil, fmt.Errorf("egorm: Failed to load job with unique key %s: %w", *options.uniqueKey, translateError(err))
+		}
+		return &existing, ErrJobExists
+	}
+	return &job, nil
+}
+
+type workerOptions struct {
+	concurrency       int
+	interval          time.Duration
+	visibilityTimeout time.Duration
+	backoff           RetryPolicy
+}
+
+// WorkerOption configures StartWorkers and RunDueJobs.
+type WorkerOption func(*workerOptions)
+
+// WorkerConcurrency sets the number of jobs StartWorkers runs at the same time, defaults to 4.
+// Values below 1 use the default.
+func WorkerConcurrency(concurrency int) WorkerOption {
+	return func(o *workerOptions) {
+		o.concurrency = concurrency
+	}
+}
+
+// WorkerPollInterval sets the time between two polls of an idle worker, defaults to one second.
+// Intervals which are not positive use the default.
+func WorkerPollInterval(interval time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.interval = interval
+	}
+}
+
+// WorkerVisibilityTimeout sets how long a job is leased to a worker, defaults to 30 seconds.
+// The worker renews the lease while the job runs, if it stops doing so, e.g. because the
+// process crashed, other workers run the job again once the lease expired. A timeout shorter
+// than MinLeaseTTL uses the default.
+func WorkerVisibilityTimeout(timeout time.Duration) WorkerOption {
+	return func(o *workerOptions) {
+		o.visibilityTimeout = timeout
+	}
+}
+
+// WorkerBackoff sets the backoff between the attempts of a failing job, defaults to
+// DefaultOutboxRetryPolicy. The attempts are limited by JobMaxAttempts instead of the MaxAttempts
+// of the policy, errors rejected by the Retryable function of the policy fail the job right away.
+func WorkerBackoff(policy RetryPolicy) WorkerOption {
+	return func(o *workerOptions) {
+		o.backoff = policy
+	}
+}
+
+func newWorkerOptions(opts []WorkerOption) workerOptions {
+	defaults := workerOptions{concurrency: 4, interval: 1 * time.Second, visibilityTimeout: 30 * time.Second, backoff: DefaultOutboxRetryPolicy}
+	options := defaults
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.concurrency < 1 {
+		options.concurrency = defaults.concurrency
+	}
+	if options.interval <= 0 {
+		options.interval = defaults.interval
+	}
+	if options.visibilityTimeout < MinLeaseTTL {
+		options.visibilityTimeout = defaults.visibilityTimeout
+	}
+	return options
+}
+
+// StartWorkers runs jobs of the defined kinds until ctx is done. Running jobs are finished
+// after ctx is done, the returned channel is closed once all of them finished. Several processes
+// may run workers on the same database, on sqlite the workers of a process take turns to lease jobs.
+func StartWorkers(ctx context.Context, opts ...WorkerOption) <-chan struct{} {
+	options := newWorkerOptions(opts)
+	done := make(chan struct{})
+	var running sync.WaitGroup
+	for i := 0; i < options.concurrency; i++ {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			for ctx.Err() == nil {
+				ran, err := runNextJob(ctx, options)
+				if err != nil {
+					eventLog().Error("egorm: Failed to run the next job", "error", err)
+				}
+				if ran {
+					continue
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(options.interval):
+				}
+			}
+		}()
+	}
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// RunDueJobs runs the due jobs of the defined kinds one after another until none is left and
+// returns the number of jobs it ran.
+func RunDueJobs(ctx context.Context, opts ...WorkerOption) (int, error) {
+	options := newWorkerOptions(opts)
+	count := 0
+	for ctx.Err() == nil {
+		ran, err := runNextJob(ctx, options)
+		if err != nil || !ran {
+			return count, err
+		}
+		count++
+	}
+	return count, ctx.Err()
+}
+
+// runNextJob leases the next due job and runs it, it reports whether there was a job.
+func runNextJob(ctx context.Context, options workerOptions) (bool, error) {
+	if err := InitDB(); err != nil {
+		return false, err
+	}
+	if err := autoMigrate(&Job{}); err != nil {
+		return false, err
+	}
+	kinds := jobKinds()
+	if len(kinds) == 0 {
+		return false, nil
+	}
+	if err := failExpiredJobs(ctx); err != nil {
+		return false, err
+	}
+	job, err := leaseJob(ctx, kinds, options.visibilityTimeout)
+	if err != nil || job == nil {
+		return false, err
+	}
+	runJob(ctx, job, options)
+	return true, nil
+}
+
+// failExpiredJobs fails the jobs whose worker stopped during their last attempt.
+func failExpiredJobs(ctx context.Context) error {
+	now := time.Now()
+	err := withRetry(ctx, "job_expire", func() error {
+		return withJobsLock(func() error {
+			return Db.WithContext(ctx).Model(&Job{}).
+				Where("status = ? AND leased_until < ? AND attempts >= max_attempts", JobStatusRunning, now).
+				Updates(map[string]interface{}{"status": JobStatusFailed, "unique_key": nil, "finished_at": now,
+					"last_error": "egorm: Lease expired"}).Error
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to fail expired jobs: %w", err)
+	}
+	return nil
+}
+
+// leaseJob leases the due job with the highest priority, it returns nil if no job is due. Jobs
+// whose lease expired are due again.
+func leaseJob(ctx context.Context, kinds []string, timeout time.Duration) (*Job, error) {
+	var leased *Job
+	err := withRetry(ctx, "job_lease", func() error {
+		leased = nil
+		lease := func(query *gorm.DB, tx *gorm.DB) error {
+			now := time.Now()
+			due := func(db *gorm.DB) *gorm.DB {
+				return db.Where("kind IN ? AND ((status = ? AND run_at <= ?) OR (status = ? AND leased_until < ? AND attempts < max_attempts))",
+					kinds, JobStatusQueued, now, JobStatusRunning, now)
+			}
+			job := Job{}
+			result := due(query).Order("priority DESC, run_at, id").Limit(1).Find(&job)
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+
+			leaseID, err := newLeaseID()
+			if err != nil {
+				return err
+			}
+			leasedUntil := now.Add(timeout)
+			// The condition fails if another worker leased the job since it was loaded
+			result = due(tx.Model(&Job{}).Where("id = ?", job.ID)).Updates(map[string]interface{}{
+				"status": JobStatusRunning, "attempts": gorm.Expr("attempts + 1"), "lease_id": leaseID, "leased_until": leasedUntil,
+			})
+			if result.Error != nil || result.RowsAffected == 0 {
+				return result.Error
+			}
+			job.Status, job.Attempts, job.LeaseID, job.LeasedUntil = JobStatusRunning, job.Attempts+1, leaseID, &leasedUntil
+			leased = &job
+			return nil
+		}
+
+		if Db.Dialector.Name() == "postgres" {
+			return Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				return lease(tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}), tx)
+			})
+		}
+		return withJobsLock(func() error {
+			return lease(Db.WithContext(ctx), Db.WithContext(ctx))
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to lease a job: %w", err)
+	}
+	return leased, nil
+}
+
+// withJobsLock runs fn while holding jobsLock unless the database is postgres. sqlite has no row
+// locks and fails concurrent writers with SQLITE_BUSY, so the workers of a process take turns.
+func withJobsLock(fn func() error) error {
+	if Db.Dialector.Name() != "postgres" {
+		jobsLock.Lock()
+		defer jobsLock.Unlock()
+	}
+	return fn()
+}
+
+func newLeaseID() (string, error) {
+	random := make([]byte, 8)
+	if _, err := rand.Read(random); err != nil {
+		return "", fmt.Errorf("egorm: Failed to create a lease id: %w", err)
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d/%s", hostname, os.Getpid(), hex.EncodeToString(random)), nil
+}
+
+// runJob runs the leased job, renews its lease until the handler returned and records the outcome.
+// The handler is not interrupted when ctx is done, but once the lease is lost.
+func runJob(ctx context.Context, job *Job, options workerOptions) {
+	jobHandlersLock.RLock()
+	handler := jobHandlers[job.Kind]
+	jobHandlersLock.RUnlock()
+
+	jobCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	stopped := make(chan struct{})
+	heartbeats := make(chan struct{})
+	go func() {
+		defer close(heartbeats)
+		ticker := time.NewTicker(options.visibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-ticker.C:
+				if lost, err := renewJobLease(jobCtx, job, options.visibilityTimeout); lost {
+					eventLog().Warn("egorm: Lost the lease of a job, cancelling it", "job", job.ID, "kind", job.Kind)
+					cancel()
+					return
+				} else if err != nil {
+					eventLog().Warn("egorm: Failed to renew the lease of a job", "job", job.ID, "kind", job.Kind, "error", err)
+				}
+			}
+		}
+	}()
+
+	result, err := callJobHandler(jobCtx, handler, job)
+	close(stopped)
+	<-heartbeats
+	if err := finishJob(context.WithoutCancel(ctx), job, result, err, options); err != nil {
+		eventLog().Error("egorm: Failed to record the outcome of a job", "job", job.ID, "kind", job.Kind, "error", err)
+	}
+}
+
+// callJobHandler turns a panic of the handler into a failed attempt.
+func callJobHandler(ctx context.Context, handler jobHandler, job *Job) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("egorm: Job %s panicked: %v", job.Kind, recovered)
+		}
+	}()
+	if handler == nil {
+		return nil, fmt.Errorf("egorm: Job %s is not defined", job.Kind)
+	}
+	return handler(ctx, job.Payload)
+}
+
+// renewJobLease extends the lease of the job, it reports whether the lease was lost.
+func renewJobLease(ctx context.Context, job *Job, timeout time.Duration) (bool, error) {
+	var affected int64
+	err := withRetry(ctx, "job_heartbeat", func() error {
+		return withJobsLock(func() error {
+			result := Db.WithContext(ctx).Model(&Job{}).Where("id = ? AND lease_id = ? AND status = ?", job.ID, job.LeaseID, JobStatusRunning).
+				Update("leased_until", time.Now().Add(timeout))
+			affected = result.RowsAffected
+			return result.Error
+		})
+	})
+	return err == nil && affected == 0, err
+}
+
+// finishJob records the result or error of the attempt, failed attempts are retried after the backoff.
+func finishJob(ctx context.Context, job *Job, result interface{}, jobErr error, options workerOptions) error {
+	now := time.Now()
+	updates := map[string]interface{}{"lease_id": "", "leased_until": nil}
+	switch {
+	case jobErr == nil:
+		encoded, err := json.Marshal(result)
+		if err != nil {
+			return fmt.Errorf("egorm: Failed to encode the result of job %d: %w", job.ID, err)
+		}
+		updates["status"], updates["result"], updates["last_error"] = JobStatusSucceeded, string(encoded), ""
+		updates["unique_key"], updates["finished_at"] = nil, now
+	case job.Attempts >= job.MaxAttempts || errors.Is(jobErr, errInvalidJobPayload) ||
+		(options.backoff.Retryable != nil && !options.backoff.Retryable(jobErr)):
+		updates["status"], updates["last_error"] = JobStatusFailed, jobErr.Error()
+		updates["unique_key"], updates["finished_at"] = nil, now
+		eventLog().Error("egorm: Job failed after all attempts", "job", job.ID, "kind", job.Kind, "attempts", job.Attempts, "error", jobErr)
+	default:
+		updates["status"], updates["last_error"] = JobStatusQueued, jobErr.Error()
+		updates["run_at"] = now.Add(options.backoff.backoff(job.Attempts))
+	}
+
+	var affected int64
+	err := withRetry(ctx, "job_finish", func() error {
+		return withJobsLock(func() error {
+			result := Db.WithContext(ctx).Model(&Job{}).Where("id = ? AND lease_id = ?", job.ID, job.LeaseID).Updates(updates)
+			affected = result.RowsAffected
+			return result.Error
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return fmt.Errorf("egorm: Lease of job %d expired before it finished", job.ID)
+	}
+	return nil
+}
@@ -0,0 +1,243 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type resizeImage struct {
+	Name  string
+	Width int
+}
+
+func TestJobs(t *testing.T) {
+	t.Run("TestEnqueue", func(t *testing.T) {
+		fakeSetup()
+		order := make([]string, 0)
+		resize := DefineJob("jobs.resize", func(ctx context.Context, payload resizeImage) (interface{}, error) {
+			order = append(order, payload.Name)
+			return payload.Width / 2, nil
+		})
+
+		for _, image := range []struct {
+			name     string
+			priority int
+		}{{"low", 0}, {"high", 10}, {"medium", 5}} {
+			if _, err := resize.Enqueue(context.Background(), resizeImage{Name: image.name, Width: 100}, JobPriority(image.priority)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if _, err := resize.Enqueue(context.Background(), resizeImage{Name: "later"}, JobRunAt(time.Now().Add(time.Hour))); err != nil {
+			t.Error(err)
+			return
+		}
+		first, err := resize.Enqueue(context.Background(), resizeImage{Name: "unique", Width: 100}, JobUniqueKey("resize:unique"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		duplicate, err := resize.Enqueue(context.Background(), resizeImage{Name: "duplicate"}, JobUniqueKey("resize:unique"))
+		if !errors.Is(err, ErrJobExists) || duplicate.ID != first.ID {
+			t.Errorf("egorm: Expected the existing job %d, got %v, %v", first.ID, duplicate, err)
+		}
+
+		ran, err := RunDueJobs(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected := []string{"high", "medium", "low", "unique"}
+		if ran != len(expected) || len(order) != len(expected) {
+			t.Errorf("egorm: Expected %d jobs, got %d: %v", len(expected), ran, order)
+			return
+		}
+		for i := range expected {
+			if order[i] != expected[i] {
+				t.Errorf("egorm: Expected the jobs in order %v, got %v", expected, order)
+				return
+			}
+		}
+
+		var job Job
+		if err := DbFind(&job, int(first.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		var result int
+		if err := job.DecodeResult(&result); err != nil {
+			t.Error(err)
+			return
+		}
+		if job.Status != JobStatusSucceeded || job.Attempts != 1 || job.UniqueKey != nil || result != 50 {
+			t.Errorf("egorm: Expected the job to succeed with the result, got %+v", job)
+		}
+		// The unique key is released once the job finished
+		if _, err := resize.Enqueue(context.Background(), resizeImage{Name: "unique"}, JobUniqueKey("resize:unique")); err != nil {
+			t.Error(err)
+		}
+		if _, err := RunDueJobs(context.Background()); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("TestRetry", func(t *testing.T) {
+		fakeSetup()
+		attempts := 0
+		flaky := DefineJob("jobs.flaky", func(ctx context.Context, payload resizeImage) (interface{}, error) {
+			attempts++
+			if attempts < 2 || payload.Name == "broken" {
+				return nil, errors.New("unavailable")
+			}
+			return nil, nil
+		})
+		noBackoff := WorkerBackoff(RetryPolicy{})
+
+		recovered, err := flaky.Enqueue(context.Background(), resizeImage{Name: "flaky"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		broken, err := flaky.Enqueue(context.Background(), resizeImage{Name: "broken"}, JobMaxAttempts(2))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := RunDueJobs(context.Background(), noBackoff); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var job Job
+		if err := DbFind(&job, int(recovered.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if job.Status != JobStatusSucceeded || job.Attempts != 2 {
+			t.Errorf("egorm: Expected the job to succeed on the second attempt, got %+v", job)
+		}
+		job = Job{}
+		if err := DbFind(&job, int(broken.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if job.Status != JobStatusFailed || job.Attempts != 2 || job.LastError != "unavailable" || job.FinishedAt == nil {
+			t.Errorf("egorm: Expected the job to fail after 2 attempts, got %+v", job)
+		}
+	})
+
+	t.Run("TestExpiredLease", func(t *testing.T) {
+		fakeSetup()
+		runs := 0
+		cleanup := DefineJob("jobs.cleanup", func(ctx context.Context, payload resizeImage) (interface{}, error) {
+			runs++
+			return nil, nil
+		})
+		crashed, err := cleanup.Enqueue(context.Background(), resizeImage{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		exhausted, err := cleanup.Enqueue(context.Background(), resizeImage{}, JobMaxAttempts(1))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// Simulate workers which crashed while running the jobs
+		expired := time.Now().Add(-time.Minute)
+		err = Db.Model(&Job{}).Where("id IN ?", []uint{crashed.ID, exhausted.ID}).
+			Updates(map[string]interface{}{"status": JobStatusRunning, "attempts": 1, "lease_id": "crashed", "leased_until": expired}).Error
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		if _, err := RunDueJobs(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+		if runs != 1 {
+			t.Errorf("egorm: Expected %d runs, got %d", 1, runs)
+		}
+		var job Job
+		if err := DbFind(&job, int(crashed.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if job.Status != JobStatusSucceeded || job.Attempts != 2 {
+			t.Errorf("egorm: Expected the job to be taken over, got %+v", job)
+		}
+		job = Job{}
+		if err := DbFind(&job, int(exhausted.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if job.Status != JobStatusFailed {
+			t.Errorf("egorm: Expected the job without attempts left to fail, got %+v", job)
+		}
+	})
+
+	t.Run("TestStartWorkers", func(t *testing.T) {
+		fakeSetup()
+		var lock sync.Mutex
+		runs := make(map[string]int)
+		started := make(chan struct{}, 10)
+		release := make(chan struct{})
+		slow := DefineJob("jobs.slow", func(ctx context.Context, payload resizeImage) (interface{}, error) {
+			lock.Lock()
+			runs[payload.Name]++
+			lock.Unlock()
+			started <- struct{}{}
+			<-release
+			return nil, nil
+		})
+		for _, name := range []string{"a", "b", "c", "d"} {
+			if _, err := slow.Enqueue(context.Background(), resizeImage{Name: name}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := StartWorkers(ctx, WorkerConcurrency(2), WorkerPollInterval(10*time.Millisecond), WorkerVisibilityTimeout(MinLeaseTTL))
+		for i := 0; i < 2; i++ {
+			select {
+			case <-started:
+			case <-time.After(5 * time.Second):
+				t.Error("egorm: Expected the workers to start the jobs")
+				cancel()
+				close(release)
+				return
+			}
+		}
+		// The heartbeats keep the leases of the running jobs
+		time.Sleep(MinLeaseTTL + 400*time.Millisecond)
+		cancel()
+		close(release)
+		<-done
+
+		jobs := make([]Job, 0)
+		if err := DbGet(&jobs, Where{"kind": "jobs.slow", "status": JobStatusSucceeded}); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(jobs) != 2 || len(runs) != 2 {
+			t.Errorf("egorm: Expected the %d running jobs to finish once, got %d: %v", 2, len(jobs), runs)
+		}
+		for name, count := range runs {
+			if count != 1 {
+				t.Errorf("egorm: Expected job %s to run once, got %d", name, count)
+			}
+		}
+	})
+
+	t.Run("TestInvalidWorkerOptions", func(t *testing.T) {
+		options := newWorkerOptions([]WorkerOption{WorkerConcurrency(0), WorkerPollInterval(-time.Second), WorkerVisibilityTimeout(10 * time.Millisecond)})
+		if options.concurrency != 4 || options.interval != time.Second || options.visibilityTimeout != 30*time.Second {
+			t.Errorf("egorm: Expected the default worker options, got %+v", options)
+		}
+	})
+}
@@ -0,0 +1,67 @@
+package egorm
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// JSON stores a value of type T as JSON column, TEXT on sqlite and JSONB on postgres.
+// Fields inside the column can be queried with Where{"column->field": value}.
+type JSON[T any] struct {
+	Data T
+}
+
+// NewJSON wraps data into a JSON column value.
+func NewJSON[T any](data T) JSON[T] {
+	return JSON[T]{Data: data}
+}
+
+func (j JSON[T]) Value() (driver.Value, error) {
+	encoded, err := json.Marshal(j.Data)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
+
+func (j *JSON[T]) Scan(value interface{}) error {
+	var encoded []byte
+	switch v := value.(type) {
+	case nil:
+		var zero T
+		j.Data = zero
+		return nil
+	case []byte:
+		encoded = v
+	case string:
+		encoded = []byte(v)
+	default:
+		return fmt.Errorf("egorm: Failed to scan %T into a JSON column", value)
+	}
+	return json.Unmarshal(encoded, &j.Data)
+}
+
+func (j JSON[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(j.Data)
+}
+
+func (j *JSON[T]) UnmarshalJSON(data []byte) error {
+	return json.Unmarshal(data, &j.Data)
+}
+
+func (JSON[T]) GormDataType() string {
+	return "json"
+}
+
+func (JSON[T]) GormDBDataType(db *gorm.DB, field *schema.Field) string {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return "JSONB"
+	default:
+		return "TEXT"
+	}
+}
@@ -0,0 +1,83 @@
+package egorm
+
+import (
+	"strings"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+)
+
+type JSONSettings struct {
+	Theme    string `json:"theme"`
+	FontSize int    `json:"font_size"`
+}
+
+type JSONSample struct {
+	gorm.Model
+	Name     string
+	Settings JSON[JSONSettings]
+}
+
+func TestJSONColumn(t *testing.T) {
+	t.Run("TestJSONColumn", func(t *testing.T) {
+		fakeSetup()
+		samples := []JSONSample{
+			{Name: "Dark", Settings: NewJSON(JSONSettings{Theme: "dark", FontSize: 14})},
+			{Name: "Light", Settings: NewJSON(JSONSettings{Theme: "light", FontSize: 10})},
+		}
+		for i := range samples {
+			if err := DbCreate(&samples[i]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		var found JSONSample
+		if err := DbFind(&found, int(samples[0].ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Settings.Data.Theme != "dark" || found.Settings.Data.FontSize != 14 {
+			t.Errorf("egorm: Expected settings to be loaded, got %+v", found.Settings.Data)
+		}
+
+		var results []JSONSample
+		if err := DbGet(&results, Where{"settings->theme": "dark"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(results) != 1 || results[0].Name != "Dark" {
+			t.Errorf("egorm: Expected the dark sample, got %v", results)
+		}
+
+		if err := DbGet(&results, Where{"settings->font_size <": 12, "name !=": "Dark"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(results) != 1 || results[0].Name != "Light" {
+			t.Errorf("egorm: Expected the light sample, got %v", results)
+		}
+
+		if err := DbGet(&results, Where{"name drop": "x"}); err == nil {
+			t.Error("egorm: Expected unsupported operators to be rejected")
+		}
+	})
+}
+
+func TestJSONPathPostgres(t *testing.T) {
+	t.Run("TestJSONPathPostgres", func(t *testing.T) {
+		db, err := gorm.Open(postgres.New(postgres.Config{DSN: "host=localhost"}), &gorm.Config{DryRun: true, DisableAutomaticPing: true})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		sql := db.ToSQL(func(tx *gorm.DB) *gorm.DB {
+			return tx.Model(&JSONSample{}).Scopes(whereScope(Where{"settings->ui->font_size >=": 12})).Find(&[]JSONSample{})
+		})
+		if !strings.Contains(sql, `("settings" #>> '{ui,font_size}')::numeric >= 12`) {
+			t.Errorf("egorm: Unexpected postgres SQL %s", sql)
+		}
+	})
+}
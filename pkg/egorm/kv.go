@@ -0,0 +1,306 @@
+package egorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const kvTableName = "egorm_kv"
+
+// kvRecord is a key in the egorm_kv table.
+type kvRecord struct {
+	Namespace string `gorm:"primaryKey"`
+	Key       string `gorm:"primaryKey"`
+	// Value is the JSON encoded value
+	Value string
+	// Version is increased by every write, CompareAndSwap only writes unchanged versions
+	Version   int64
+	ExpiresAt *time.Time `gorm:"index"`
+	UpdatedAt time.Time
+}
+
+func (kvRecord) TableName() string {
+	return kvTableName
+}
+
+// KVStore stores values of type T by key in a namespace, see KV.
+type KVStore[T any] struct {
+	Namespace string
+}
+
+// KVEntry is a key and its value, see KVStore.List.
+type KVEntry[T any] struct {
+	Key       string
+	Value     T
+	ExpiresAt *time.Time
+}
+
+// KVEvent is a change of a key, see KVStore.Watch.
+type KVEvent[T any] struct {
+	Key string
+	// Value is the new value, it is the zero value if the key was deleted
+	Value   T
+	Deleted bool
+}
+
+// KV returns the key-value store of namespace, e.g. KV[bool]("features").Set(ctx, "dark_mode", true).
+// Its values are stored JSON encoded in the egorm_kv table, which is created on first use. Stores
+// of the same namespace share their keys, so they should use the same T.
+func KV[T any](namespace string) KVStore[T] {
+	return KVStore[T]{Namespace: namespace}
+}
+
+type kvOptions struct {
+	ttl time.Duration
+}
+
+// KVOption configures KVStore.Set and KVStore.CompareAndSwap.
+type KVOption func(*kvOptions)
+
+// KVTTL lets the key expire after ttl, expired keys are treated as deleted. By default keys do not expire.
+func KVTTL(ttl time.Duration) KVOption {
+	return func(o *kvOptions) {
+		o.ttl = ttl
+	}
+}
+
+func (o kvOptions) expiresAt() *time.Time {
+	if o.ttl <= 0 {
+		return nil
+	}
+	expiresAt := time.Now().Add(o.ttl)
+	return &expiresAt
+}
+
+func prepareKV() error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	return autoMigrate(&kvRecord{})
+}
+
+// unexpired restricts a query to keys which did not expire.
+func unexpired(db *gorm.DB) *gorm.DB {
+	return db.Where("expires_at IS NULL OR expires_at > ?", time.Now())
+}
+
+// Get returns the value of key and whether it exists.
+func (s KVStore[T]) Get(ctx context.Context, key string) (T, bool, error) {
+	var value T
+	if err := prepareKV(); err != nil {
+		return value, false, err
+	}
+	records := make([]kvRecord, 0, 1)
+	err := withRetry(ctx, "get", func() error {
+		return Db.WithContext(ctx).Scopes(unexpired).Where("namespace = ? AND key = ?", s.Namespace, key).Limit(1).Find(&records).Error
+	})
+	if err != nil {
+		return value, false, fmt.Errorf("egorm: Failed to get key %s of %s: %w", key, s.Namespace, err)
+	}
+	if len(records) == 0 {
+		return value, false, nil
+	}
+	if err := json.Unmarshal([]byte(records[0].Value), &value); err != nil {
+		return value, false, fmt.Errorf("egorm: Failed to decode key %s of %s: %w", key, s.Namespace, err)
+	}
+	return value, true, nil
+}
+
+// Set stores value under key, replacing its previous value and ttl.
+func (s KVStore[T]) Set(ctx context.Context, key string, value T, opts ...KVOption) error {
+	if err := prepareKV(); err != nil {
+		return err
+	}
+	options := kvOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to encode key %s of %s: %w", key, s.Namespace, err)
+	}
+
+	record := kvRecord{Namespace: s.Namespace, Key: key, Value: string(encoded), Version: 1, ExpiresAt: options.expiresAt()}
+	err = withRetry(ctx, "save", func() error {
+		return Db.WithContext(ctx).Clauses(clause.OnConflict{
+			Columns: []clause.Column{{Name: "namespace"}, {Name: "key"}},
+			DoUpdates: append(clause.AssignmentColumns([]string{"value", "expires_at", "updated_at"}),
+				clause.Assignment{Column: clause.Column{Name: "version"}, Value: gorm.Expr(kvTableName + ".version + 1")}),
+		}).Create(&record).Error
+	})
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to set key %s of %s: %w", key, s.Namespace, err)
+	}
+	notifyKVWatchers(s.Namespace, key, record.Value, false)
+	return nil
+}
+
+// Delete removes key, deleting a missing key is no error.
+func (s KVStore[T]) Delete(ctx context.Context, key string) error {
+	if err := prepareKV(); err != nil {
+		return err
+	}
+	var affected int64
+	err := withRetry(ctx, "delete", func() error {
+		result := Db.WithContext(ctx).Where("namespace = ? AND key = ?", s.Namespace, key).Delete(&kvRecord{})
+		affected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to delete key %s of %s: %w", key, s.Namespace, err)
+	}
+	if affected > 0 {
+		notifyKVWatchers(s.Namespace, key, "", true)
+	}
+	return nil
+}
+
+// CompareAndSwap stores new under key if its current value equals old, compared by their JSON
+// encoding. It reports whether the value was swapped, missing keys are never swapped.
+func (s KVStore[T]) CompareAndSwap(ctx context.Context, key string, old T, new T, opts ...KVOption) (bool, error) {
+	if err := prepareKV(); err != nil {
+		return false, err
+	}
+	options := kvOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	encodedOld, err := json.Marshal(old)
+	if err != nil {
+		return false, fmt.Errorf("egorm: Failed to encode key %s of %s: %w", key, s.Namespace, err)
+	}
+	encodedNew, err := json.Marshal(new)
+	if err != nil {
+		return false, fmt.Errorf("egorm: Failed to encode key %s of %s: %w", key, s.Namespace, err)
+	}
+
+	swapped := false
+	err = withRetry(ctx, "update", func() error {
+		swapped = false
+		records := make([]kvRecord, 0, 1)
+		err := Db.WithContext(ctx).Scopes(unexpired).Where("namespace = ? AND key = ?", s.Namespace, key).Limit(1).Find(&records).Error
+		if err != nil || len(records) == 0 || records[0].Value != string(encodedOld) {
+			return err
+		}
+		// The version changes if another write happened since the value was read
+		result := Db.WithContext(ctx).Model(&kvRecord{}).Where("namespace = ? AND key = ? AND version = ?", s.Namespace, key, records[0].Version).
+			Updates(map[string]interface{}{"value": string(encodedNew), "version": records[0].Version + 1, "expires_at": options.expiresAt()})
+		swapped = result.RowsAffected > 0
+		return result.Error
+	})
+	if err != nil {
+		return false, fmt.Errorf("egorm: Failed to compare and swap key %s of %s: %w", key, s.Namespace, err)
+	}
+	if swapped {
+		notifyKVWatchers(s.Namespace, key, string(encodedNew), false)
+	}
+	return swapped, nil
+}
+
+// List returns the keys starting with prefix and their values, ordered by key.
+func (s KVStore[T]) List(ctx context.Context, prefix string) ([]KVEntry[T], error) {
+	if err := prepareKV(); err != nil {
+		return nil, err
+	}
+	records := make([]kvRecord, 0)
+	err := withRetry(ctx, "list", func() error {
+		// substr compares the prefix case sensitively, the lower bound lets the index skip to it
+		return Db.WithContext(ctx).Scopes(unexpired).Where("namespace = ? AND key >= ? AND substr(key, 1, ?) = ?",
+			s.Namespace, prefix, utf8.RuneCountInString(prefix), prefix).Order("key").Find(&records).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to list keys %s* of %s: %w", prefix, s.Namespace, err)
+	}
+
+	entries := make([]KVEntry[T], 0, len(records))
+	for _, record := range records {
+		entry := KVEntry[T]{Key: record.Key, ExpiresAt: record.ExpiresAt}
+		if err := json.Unmarshal([]byte(record.Value), &entry.Value); err != nil {
+			return nil, fmt.Errorf("egorm: Failed to decode key %s of %s: %w", record.Key, s.Namespace, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// PurgeExpiredKeys removes the expired keys of all namespaces and returns their number. Expired
+// keys are invisible without purging, it only frees their space.
+func PurgeExpiredKeys(ctx context.Context) (int64, error) {
+	if err := prepareKV(); err != nil {
+		return 0, err
+	}
+	var affected int64
+	err := withRetry(ctx, "delete", func() error {
+		result := Db.WithContext(ctx).Where("expires_at <= ?", time.Now()).Delete(&kvRecord{})
+		affected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, fmt.Errorf("egorm: Failed to purge expired keys: %w", err)
+	}
+	return affected, nil
+}
+
+type kvWatcher struct {
+	id        int
+	namespace string
+	prefix    string
+	fn        func(key string, value string, deleted bool)
+}
+
+var (
+	kvWatchersLock sync.RWMutex
+	kvWatchers     []kvWatcher
+	nextKVWatcher  int
+)
+
+// Watch calls fn after each change of a key starting with prefix through this process. Changes
+// of other processes and expiring keys are not reported. The returned function unsubscribes fn.
+func (s KVStore[T]) Watch(prefix string, fn func(event KVEvent[T])) func() {
+	kvWatchersLock.Lock()
+	defer kvWatchersLock.Unlock()
+	nextKVWatcher++
+	id := nextKVWatcher
+	kvWatchers = append(kvWatchers, kvWatcher{id: id, namespace: s.Namespace, prefix: prefix, fn: func(key string, value string, deleted bool) {
+		event := KVEvent[T]{Key: key, Deleted: deleted}
+		if !deleted {
+			if err := json.Unmarshal([]byte(value), &event.Value); err != nil {
+				return
+			}
+		}
+		fn(event)
+	}})
+
+	return func() {
+		kvWatchersLock.Lock()
+		defer kvWatchersLock.Unlock()
+		for i, watcher := range kvWatchers {
+			if watcher.id == id {
+				kvWatchers = append(kvWatchers[:i:i], kvWatchers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func notifyKVWatchers(namespace string, key string, value string, deleted bool) {
+	kvWatchersLock.RLock()
+	matching := make([]kvWatcher, 0)
+	for _, watcher := range kvWatchers {
+		if watcher.namespace == namespace && strings.HasPrefix(key, watcher.prefix) {
+			matching = append(matching, watcher)
+		}
+	}
+	kvWatchersLock.RUnlock()
+	for _, watcher := range matching {
+		watcher.fn(key, value, deleted)
+	}
+}
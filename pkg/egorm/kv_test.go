@@ -0,0 +1,181 @@
+package egorm
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type syncCursor struct {
+	Offset int
+	Etag   string
+}
+
+func TestKV(t *testing.T) {
+	t.Run("TestGetSetDelete", func(t *testing.T) {
+		fakeSetup()
+		ctx := context.Background()
+		cursors := KV[syncCursor]("kv.cursors")
+		if _, found, err := cursors.Get(ctx, "orders"); err != nil || found {
+			t.Errorf("egorm: Expected a missing key, got %v, %v", found, err)
+		}
+		if err := cursors.Set(ctx, "orders", syncCursor{Offset: 10, Etag: "a"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := cursors.Set(ctx, "orders", syncCursor{Offset: 20, Etag: "b"}); err != nil {
+			t.Error(err)
+			return
+		}
+		cursor, found, err := cursors.Get(ctx, "orders")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !found || cursor.Offset != 20 || cursor.Etag != "b" {
+			t.Errorf("egorm: Expected the second cursor, got %v, %+v", found, cursor)
+		}
+
+		// Namespaces do not share keys
+		if _, found, err := KV[syncCursor]("kv.other").Get(ctx, "orders"); err != nil || found {
+			t.Errorf("egorm: Expected the key to be missing in another namespace, got %v, %v", found, err)
+		}
+
+		if err := cursors.Delete(ctx, "orders"); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, found, err := cursors.Get(ctx, "orders"); err != nil || found {
+			t.Errorf("egorm: Expected the key to be deleted, got %v, %v", found, err)
+		}
+	})
+
+	t.Run("TestCompareAndSwap", func(t *testing.T) {
+		fakeSetup()
+		ctx := context.Background()
+		counters := KV[int]("kv.counters")
+		if swapped, err := counters.CompareAndSwap(ctx, "visits", 0, 1); err != nil || swapped {
+			t.Errorf("egorm: Expected a missing key not to be swapped, got %v, %v", swapped, err)
+		}
+		if err := counters.Set(ctx, "visits", 1); err != nil {
+			t.Error(err)
+			return
+		}
+		if swapped, err := counters.CompareAndSwap(ctx, "visits", 1, 2); err != nil || !swapped {
+			t.Errorf("egorm: Expected the value to be swapped, got %v, %v", swapped, err)
+		}
+		if swapped, err := counters.CompareAndSwap(ctx, "visits", 1, 3); err != nil || swapped {
+			t.Errorf("egorm: Expected a stale value not to be swapped, got %v, %v", swapped, err)
+		}
+		if visits, _, err := counters.Get(ctx, "visits"); err != nil || visits != 2 {
+			t.Errorf("egorm: Expected %d visits, got %d, %v", 2, visits, err)
+		}
+	})
+
+	t.Run("TestTTL", func(t *testing.T) {
+		fakeSetup()
+		ctx := context.Background()
+		sessions := KV[string]("kv.sessions")
+		if err := sessions.Set(ctx, "short", "a", KVTTL(time.Second)); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := sessions.Set(ctx, "long", "b", KVTTL(time.Hour)); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, found, err := sessions.Get(ctx, "short"); err != nil || !found {
+			t.Errorf("egorm: Expected the key before its expiry, got %v, %v", found, err)
+		}
+		time.Sleep(time.Second)
+		if _, found, err := sessions.Get(ctx, "short"); err != nil || found {
+			t.Errorf("egorm: Expected the key to expire, got %v, %v", found, err)
+		}
+		purged, err := PurgeExpiredKeys(ctx)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if purged < 1 {
+			t.Errorf("egorm: Expected the expired key to be purged, got %d", purged)
+		}
+		if _, found, err := sessions.Get(ctx, "long"); err != nil || !found {
+			t.Errorf("egorm: Expected the unexpired key to remain, got %v, %v", found, err)
+		}
+	})
+
+	t.Run("TestList", func(t *testing.T) {
+		fakeSetup()
+		ctx := context.Background()
+		features := KV[bool]("kv.features")
+		for key, enabled := range map[string]bool{"ui.dark_mode": true, "ui.beta": false, "api.v2": true, "ui_legacy": true, "UI.contrast": true, "ü.accents": true} {
+			if err := features.Set(ctx, key, enabled); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		entries, err := features.List(ctx, "ui.")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(entries) != 2 {
+			t.Errorf("egorm: Expected %d items, got %d", 2, len(entries))
+			return
+		}
+		if entries[0].Key != "ui.beta" || entries[0].Value || entries[1].Key != "ui.dark_mode" || !entries[1].Value {
+			t.Errorf("egorm: Expected the ui keys in order, got %+v", entries)
+		}
+
+		entries, err = features.List(ctx, "ü.")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(entries) != 1 || entries[0].Key != "ü.accents" {
+			t.Errorf("egorm: Expected the key with a multibyte prefix, got %+v", entries)
+		}
+	})
+
+	t.Run("TestWatch", func(t *testing.T) {
+		fakeSetup()
+		ctx := context.Background()
+		features := KV[bool]("kv.watched")
+		events := make([]KVEvent[bool], 0)
+		unsubscribe := features.Watch("ui.", func(event KVEvent[bool]) {
+			events = append(events, event)
+		})
+
+		for _, key := range []string{"ui.dark_mode", "api.v2"} {
+			if err := features.Set(ctx, key, true); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if _, err := features.CompareAndSwap(ctx, "ui.dark_mode", true, false); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := features.Delete(ctx, "ui.dark_mode"); err != nil {
+			t.Error(err)
+			return
+		}
+		unsubscribe()
+		if err := features.Set(ctx, "ui.dark_mode", true); err != nil {
+			t.Error(err)
+			return
+		}
+
+		expected := []KVEvent[bool]{{Key: "ui.dark_mode", Value: true}, {Key: "ui.dark_mode"}, {Key: "ui.dark_mode", Deleted: true}}
+		if len(events) != len(expected) {
+			t.Errorf("egorm: Expected events %v, got %v", expected, events)
+			return
+		}
+		for i := range expected {
+			if events[i] != expected[i] {
+				t.Errorf("egorm: Expected events %v, got %v", expected, events)
+				return
+			}
+		}
+	})
+}
@@ -1,14 +1,20 @@
 package egorm
 
 import (
+	"context"
 	"errors"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Where map[string]interface{}
 
 func DbGetAll[T any](input *[]T) error {
+	return DbGetAllContext(context.Background(), input)
+}
+
+func DbGetAllContext[T any](ctx context.Context, input *[]T) error {
 
 	if err := InitDB(); err != nil {
 		return err
@@ -19,14 +25,20 @@ func DbGetAll[T any](input *[]T) error {
 	if err != nil {
 		return err
 	}
-	result := Db.Find(input)
-	if result.Error != nil {
-		return result.Error
+	err = withRetry(ctx, "get_all", func() error {
+		return readDB(ctx).Find(input).Error
+	})
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
 func DbCreate[T any](input *T) error {
+	return DbCreateContext(context.Background(), input)
+}
+
+func DbCreateContext[T any](ctx context.Context, input *T) error {
 	if err := InitDB(); err != nil {
 		return err
 	}
@@ -34,14 +46,23 @@ func DbCreate[T any](input *T) error {
 	if err != nil {
 		return err
 	}
-	result := Db.Create(input)
-	if result.Error != nil {
-		return result.Error
+	if err := initVersion(input); err != nil {
+		return err
+	}
+	err = withRetryAfterCommit(ctx, "create", func(ctx context.Context) error {
+		return Db.WithContext(ctx).Create(input).Error
+	})
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
 func DbSave[T any](input *T) error {
+	return DbSaveContext(context.Background(), input)
+}
+
+func DbSaveContext[T any](ctx context.Context, input *T) error {
 	if err := InitDB(); err != nil {
 		return err
 	}
@@ -49,14 +70,52 @@ func DbSave[T any](input *T) error {
 	if err != nil {
 		return err
 	}
-	result := Db.Save(input)
-	if result.Error != nil {
-		return result.Error
+	s, versionField, err := lookupVersionField(input)
+	if err != nil {
+		return err
+	}
+	if versionField != nil {
+		return saveVersioned(ctx, input, s, versionField)
+	}
+
+	err = withRetryAfterCommit(ctx, "save", func(ctx context.Context) error {
+		return Db.WithContext(ctx).Save(input).Error
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// DbDelete deletes the given entity by its primary key. Models with a gorm.DeletedAt
+// field are soft deleted.
+func DbDelete[T any](input *T) error {
+	return DbDeleteContext(context.Background(), input)
+}
+
+func DbDeleteContext[T any](ctx context.Context, input *T) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	err := autoMigrate(input)
+	if err != nil {
+		return err
+	}
+
+	err = withRetryAfterCommit(ctx, "delete", func(ctx context.Context) error {
+		return Db.WithContext(ctx).Delete(input).Error
+	})
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
 func DbGet[T any](input *[]T, where map[string]interface{}) error {
+	return DbGetContext(context.Background(), input, where)
+}
+
+func DbGetContext[T any](ctx context.Context, input *[]T, where map[string]interface{}) error {
 	if err := InitDB(); err != nil {
 		return err
 	}
@@ -66,14 +125,22 @@ func DbGet[T any](input *[]T, where map[string]interface{}) error {
 		return err
 	}
 
-	result := Db.Where(where).Find(input)
-	if result.Error != nil {
-		return result.Error
+	err = cachedRead(ctx, input, "get", where, func(dest *[]T) error {
+		return withRetry(ctx, "get", func() error {
+			return readDB(ctx).Scopes(whereScope(where)).Find(dest).Error
+		})
+	})
+	if err != nil {
+		return err
 	}
 	return nil
 }
 
 func DbFirst[T any](input *T, where map[string]interface{}) error {
+	return DbFirstContext(context.Background(), input, where)
+}
+
+func DbFirstContext[T any](ctx context.Context, input *T, where map[string]interface{}) error {
 	if err := InitDB(); err != nil {
 		return err
 	}
@@ -83,19 +150,27 @@ func DbFirst[T any](input *T, where map[string]interface{}) error {
 		return err
 	}
 
-	result := Db.Where(where).First(input)
-	if result.Error != nil {
-		// Suppress error here, since it may be intended not to have an error here, input
-		// is then simply nil
-		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
-			return nil
-		}
-		return result.Error
-	}
-	return nil
+	return cachedRead(ctx, input, "first", where, func(dest *T) error {
+		return withRetry(ctx, "first", func() error {
+			err := readDB(ctx).Scopes(whereScope(where)).First(dest).Error
+			// Suppress error here, since it may be intended not to have an error here, input
+			// is then simply nil. Neither is it counted as a failed operation.
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil
+			}
+			return err
+		})
+	})
 }
 
-func DbFind[T any](input *T, id int) error {
+// DbFind loads the entity with the primary key id into input, input stays unchanged if there is
+// none. id is the value of the primary key, e.g. a uint, string, UUID or ULID. For composite keys,
+// id is a Where of the primary fields, or a []interface{} of their values in the order of the fields.
+func DbFind[T any](input *T, id interface{}) error {
+	return DbFindContext(context.Background(), input, id)
+}
+
+func DbFindContext[T any](ctx context.Context, input *T, id interface{}) error {
 	if err := InitDB(); err != nil {
 		return err
 	}
@@ -104,10 +179,22 @@ func DbFind[T any](input *T, id int) error {
 	if err != nil {
 		return err
 	}
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return err
+	}
+	conds, err := keyConditions(s, id)
+	if err != nil {
+		return err
+	}
 
-	result := Db.Find(input, id)
-	if result.Error != nil {
-		return result.Error
+	err = cachedRead(ctx, input, "find", id, func(dest *T) error {
+		return withRetry(ctx, "find", func() error {
+			return readDB(ctx).Where(clause.And(conds...)).Find(dest).Error
+		})
+	})
+	if err != nil {
+		return err
 	}
 
 	return nil
@@ -27,6 +27,15 @@ func cleanUp() {
 	}
 }
 
+// TestMain removes the sqlite file before and after all tests ran, since
+// the tests of the package share the database
+func TestMain(m *testing.M) {
+	cleanUp()
+	code := m.Run()
+	cleanUp()
+	os.Exit(code)
+}
+
 func TestSetup(t *testing.T) {
 	t.Run("TestSetup", func(t *testing.T) {
 		fakeSetup()
@@ -110,7 +119,6 @@ func TestGetAll(t *testing.T) {
 		if len(samples) != 2 {
 			t.Error(fmt.Errorf("egorm: Expected %d items, got %d", 2, len(samples)))
 		}
-		cleanUp()
 	})
 }
 
@@ -0,0 +1,67 @@
+package egorm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm/clause"
+)
+
+// ListOpts sorts and pages the results of DbList.
+type ListOpts struct {
+	// Order lists the field or column names to sort by, a leading "-" sorts descending
+	Order []string
+	// Limit is the maximum number of loaded entities, 0 loads all
+	Limit  int
+	Offset int
+}
+
+// DbList loads the entities of type T matching where in the order and window of opts and
+// returns the total number of matching entities, e.g. to render pagination.
+func DbList[T any](input *[]T, where map[string]interface{}, opts ListOpts) (int64, error) {
+	return DbListContext(context.Background(), input, where, opts)
+}
+
+func DbListContext[T any](ctx context.Context, input *[]T, where map[string]interface{}, opts ListOpts) (int64, error) {
+	if err := InitDB(); err != nil {
+		return 0, err
+	}
+	var tmp T
+	if err := autoMigrate(&tmp); err != nil {
+		return 0, err
+	}
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	order := make([]clause.OrderByColumn, 0, len(opts.Order))
+	for _, name := range opts.Order {
+		desc := strings.HasPrefix(name, "-")
+		field := s.LookUpField(strings.TrimPrefix(name, "-"))
+		if field == nil || field.DBName == "" {
+			return 0, fmt.Errorf("egorm: Unknown order column %q in %s", name, s.Table)
+		}
+		order = append(order, clause.OrderByColumn{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Desc: desc})
+	}
+
+	var total int64
+	err = withRetry(ctx, "list", func() error {
+		if err := readDB(ctx).Model(&tmp).Scopes(whereScope(where)).Count(&total).Error; err != nil {
+			return err
+		}
+		query := readDB(ctx).Scopes(whereScope(where)).Offset(opts.Offset)
+		if len(order) > 0 {
+			query = query.Clauses(clause.OrderBy{Columns: order})
+		}
+		if opts.Limit > 0 {
+			query = query.Limit(opts.Limit)
+		}
+		return query.Find(input).Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	return total, nil
+}
@@ -0,0 +1,42 @@
+package egorm
+
+import (
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type ListSample struct {
+	gorm.Model
+	Name  string
+	Score int
+}
+
+func TestList(t *testing.T) {
+	t.Run("TestList", func(t *testing.T) {
+		fakeSetup()
+		for i, name := range []string{"a", "b", "c", "d", "e"} {
+			if err := DbCreate(&ListSample{Name: name, Score: i % 2}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		var samples []ListSample
+		total, err := DbList(&samples, Where{"score": 0}, ListOpts{Order: []string{"-Name"}, Limit: 2, Offset: 1})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if total != 3 {
+			t.Errorf("egorm: Expected %d items in total, got %d", 3, total)
+		}
+		if len(samples) != 2 || samples[0].Name != "c" || samples[1].Name != "a" {
+			t.Errorf("egorm: Expected the page c, a, got %v", samples)
+		}
+
+		if _, err := DbList(&samples, Where{}, ListOpts{Order: []string{"missing"}}); err == nil {
+			t.Error("egorm: Expected unknown order columns to be rejected")
+		}
+	})
+}
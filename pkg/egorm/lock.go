@@ -0,0 +1,270 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const locksTableName = "egorm_locks"
+
+// ErrLockHeld is returned by TryLock if another holder has an unexpired lease of the lock.
+var ErrLockHeld = errors.New("egorm: lock is held")
+
+// MinLeaseTTL is the shortest ttl of locks, leaderships and job leases. Renewals and polls run
+// at a third or a quarter of the ttl, so shorter ones would keep the database busy.
+const MinLeaseTTL = time.Second
+
+// ErrLockLost is returned by Lease.Renew and Lease.Release once the lease expired and the lock
+// was taken over.
+var ErrLockLost = errors.New("egorm: lock lost")
+
+// lockRecord is a lock in the egorm_locks table. The row of a lock is kept after its release,
+// so the fencing token keeps increasing.
+type lockRecord struct {
+	Name      string `gorm:"primaryKey"`
+	Holder    string
+	Token     int64
+	ExpiresAt time.Time
+	UpdatedAt time.Time
+}
+
+func (lockRecord) TableName() string {
+	return locksTableName
+}
+
+// Lease is a held lock, see Lock.
+type Lease struct {
+	Name   string
+	Holder string
+	// Token is the fencing token of the lease, it increases with every acquisition of the lock.
+	// Pass it along with writes to other systems, so they can reject writes of former holders.
+	Token     int64
+	ExpiresAt time.Time
+	ttl       time.Duration
+}
+
+// Lock acquires the lock name for ttl, it waits until the lock is free or ctx is done. Leases
+// which expired are taken over. The lease has to be renewed before it expires, see Lease.Renew.
+// The clocks of all holders are compared, so they should be synchronized.
+func Lock(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	if ttl < MinLeaseTTL {
+		return nil, fmt.Errorf("egorm: ttl %s of lock %s is shorter than %s", ttl, name, MinLeaseTTL)
+	}
+	interval := ttl / 4
+	if interval > time.Second {
+		interval = time.Second
+	}
+	for {
+		lease, err := TryLock(ctx, name, ttl)
+		// Concurrent holders may keep sqlite busy, so it is retried like a held lock
+		if err == nil || !(errors.Is(err, ErrLockHeld) || IsRetryable(err)) {
+			return lease, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("egorm: Failed to acquire lock %s: %w", name, ctx.Err())
+		case <-time.After(interval):
+		}
+	}
+}
+
+// TryLock acquires the lock name for ttl like Lock, but returns ErrLockHeld right away if
+// the lock is held.
+func TryLock(ctx context.Context, name string, ttl time.Duration) (*Lease, error) {
+	if ttl < MinLeaseTTL {
+		return nil, fmt.Errorf("egorm: ttl %s of lock %s is shorter than %s", ttl, name, MinLeaseTTL)
+	}
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	if err := autoMigrate(&lockRecord{}); err != nil {
+		return nil, err
+	}
+	holder, err := newLeaseID()
+	if err != nil {
+		return nil, err
+	}
+
+	var lease *Lease
+	err = withRetry(ctx, "lock", func() error {
+		now := time.Now()
+		lease = &Lease{Name: name, Holder: holder, ExpiresAt: now.Add(ttl), ttl: ttl}
+		result := Db.WithContext(ctx).Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&lockRecord{Name: name, Holder: holder, Token: 1, ExpiresAt: lease.ExpiresAt})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			result = Db.WithContext(ctx).Model(&lockRecord{}).Where("name = ? AND expires_at < ?", name, now).
+				Updates(map[string]interface{}{"holder": holder, "token": gorm.Expr("token + 1"), "expires_at": lease.ExpiresAt})
+			if result.Error != nil {
+				return result.Error
+			}
+			if result.RowsAffected == 0 {
+				return ErrLockHeld
+			}
+		}
+		// The holder is unique, so the row still has the token of this acquisition
+		record := lockRecord{}
+		if err := Db.WithContext(ctx).Where("name = ? AND holder = ?", name, holder).First(&record).Error; err != nil {
+			return err
+		}
+		lease.Token = record.Token
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to acquire lock %s: %w", name, err)
+	}
+	return lease, nil
+}
+
+// Renew extends the lease by its ttl. It fails with ErrLockLost if the lock was taken over,
+// an expired lease which was not taken over is renewed.
+func (l *Lease) Renew(ctx context.Context) error {
+	expiresAt := time.Now().Add(l.ttl)
+	var affected int64
+	err := withRetry(ctx, "lock_renew", func() error {
+		result := Db.WithContext(ctx).Model(&lockRecord{}).Where("name = ? AND token = ? AND holder = ?", l.Name, l.Token, l.Holder).
+			Update("expires_at", expiresAt)
+		affected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to renew lock %s: %w", l.Name, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("egorm: Failed to renew lock %s: %w", l.Name, ErrLockLost)
+	}
+	l.ExpiresAt = expiresAt
+	return nil
+}
+
+// Release frees the lock, so the next holder does not have to wait for the lease to expire.
+func (l *Lease) Release(ctx context.Context) error {
+	var affected int64
+	err := withRetry(ctx, "lock_release", func() error {
+		result := Db.WithContext(ctx).Model(&lockRecord{}).Where("name = ? AND token = ? AND holder = ?", l.Name, l.Token, l.Holder).
+			Updates(map[string]interface{}{"holder": "", "expires_at": time.Unix(0, 0)})
+		affected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to release lock %s: %w", l.Name, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("egorm: Failed to release lock %s: %w", l.Name, ErrLockLost)
+	}
+	return nil
+}
+
+// ElectionCallbacks are called by Elect when the leadership changes.
+type ElectionCallbacks struct {
+	// OnElected is called once this instance became the leader, token is the fencing token of the
+	// leadership. ctx is cancelled once the leadership is lost, at the latest when the lease expires
+	// without being renewed. Elect waits for OnElected to return before it competes again.
+	OnElected func(ctx context.Context, token int64)
+	// OnDemoted is called after OnElected returned, once the leadership is lost or Elect stops.
+	OnDemoted func()
+}
+
+type electionOptions struct {
+	ttl time.Duration
+}
+
+// ElectionOption configures Elect.
+type ElectionOption func(*electionOptions)
+
+// ElectionTTL sets how long a leader stays elected without renewing its lease, defaults to
+// 15 seconds. The leader renews it every third of the ttl, followers try to take over as often.
+// A ttl shorter than MinLeaseTTL uses the default.
+func ElectionTTL(ttl time.Duration) ElectionOption {
+	return func(o *electionOptions) {
+		o.ttl = ttl
+	}
+}
+
+// Elect competes for the leadership of name until ctx is done. The leadership is a lease of
+// the lock name, which is renewed automatically and taken over by another instance once it
+// expired. When ctx is done, the leadership is released and the returned channel is closed.
+func Elect(ctx context.Context, name string, callbacks ElectionCallbacks, opts ...ElectionOption) <-chan struct{} {
+	options := electionOptions{ttl: 15 * time.Second}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.ttl < MinLeaseTTL {
+		options.ttl = 15 * time.Second
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(options.ttl / 3)
+		defer ticker.Stop()
+		for {
+			lease, err := TryLock(ctx, name, options.ttl)
+			if err == nil {
+				lead(ctx, lease, callbacks, ticker)
+			} else if !errors.Is(err, ErrLockHeld) && !IsRetryable(err) && ctx.Err() == nil {
+				eventLog().Error("egorm: Election failed", "lock", name, "error", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+// lead runs the callbacks of the leader and renews its lease until it is lost or ctx is done.
+func lead(ctx context.Context, lease *Lease, callbacks ElectionCallbacks, ticker *time.Ticker) {
+	// The leadership ends once the lease expires, even if renewals keep failing until then.
+	// Each renewal moves the expiry forward.
+	leaderCtx, cancel := context.WithCancel(ctx)
+	expiry := time.AfterFunc(time.Until(lease.ExpiresAt), cancel)
+	var running sync.WaitGroup
+	if callbacks.OnElected != nil {
+		running.Add(1)
+		go func() {
+			defer running.Done()
+			callbacks.OnElected(leaderCtx, lease.Token)
+		}()
+	}
+	defer func() {
+		expiry.Stop()
+		cancel()
+		running.Wait()
+		if callbacks.OnDemoted != nil {
+			callbacks.OnDemoted()
+		}
+	}()
+
+	for {
+		select {
+		case <-leaderCtx.Done():
+			// Also done with ctx, the leadership is released then
+			if ctx.Err() == nil {
+				eventLog().Warn("egorm: Lost the leadership, the lease expired", "lock", lease.Name)
+			} else if err := lease.Release(context.WithoutCancel(ctx)); err != nil {
+				eventLog().Warn("egorm: Failed to release the leadership", "lock", lease.Name, "error", err)
+			}
+			return
+		case <-ticker.C:
+			err := lease.Renew(ctx)
+			if errors.Is(err, ErrLockLost) {
+				eventLog().Warn("egorm: Lost the leadership", "lock", lease.Name, "error", err)
+				return
+			} else if err != nil {
+				eventLog().Warn("egorm: Failed to renew the leadership", "lock", lease.Name, "error", err)
+			} else {
+				expiry.Reset(time.Until(lease.ExpiresAt))
+			}
+		}
+	}
+}
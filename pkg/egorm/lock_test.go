@@ -0,0 +1,211 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLock(t *testing.T) {
+	t.Run("TestTryLock", func(t *testing.T) {
+		fakeSetup()
+		lease, err := TryLock(context.Background(), "lock.try", time.Minute)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := TryLock(context.Background(), "lock.try", time.Minute); !errors.Is(err, ErrLockHeld) {
+			t.Errorf("egorm: Expected ErrLockHeld, got %v", err)
+		}
+		if err := lease.Renew(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := lease.Release(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+
+		next, err := TryLock(context.Background(), "lock.try", time.Minute)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if next.Token <= lease.Token {
+			t.Errorf("egorm: Expected the token to increase from %d, got %d", lease.Token, next.Token)
+		}
+		if err := lease.Release(context.Background()); !errors.Is(err, ErrLockLost) {
+			t.Errorf("egorm: Expected ErrLockLost for a released lease, got %v", err)
+		}
+	})
+
+	t.Run("TestTakeOver", func(t *testing.T) {
+		fakeSetup()
+		expired, err := TryLock(context.Background(), "lock.expired", MinLeaseTTL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// Simulate a holder which crashed a while ago
+		if err := Db.Model(&lockRecord{}).Where("name = ?", "lock.expired").Update("expires_at", time.Now().Add(-time.Minute)).Error; err != nil {
+			t.Error(err)
+			return
+		}
+		lease, err := Lock(context.Background(), "lock.expired", time.Minute)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if lease.Token != expired.Token+1 {
+			t.Errorf("egorm: Expected token %d, got %d", expired.Token+1, lease.Token)
+		}
+		if err := expired.Renew(context.Background()); !errors.Is(err, ErrLockLost) {
+			t.Errorf("egorm: Expected ErrLockLost for the expired lease, got %v", err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		if _, err := Lock(ctx, "lock.expired", time.Minute); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("egorm: Expected to wait for the lock until the deadline, got %v", err)
+		}
+	})
+
+	t.Run("TestConcurrentHolders", func(t *testing.T) {
+		fakeSetup()
+		var holders, overlaps int32
+		tokens := make([]int64, 0)
+		var lock sync.Mutex
+		var wait sync.WaitGroup
+		for i := 0; i < 5; i++ {
+			wait.Add(1)
+			go func() {
+				defer wait.Done()
+				ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+				defer cancel()
+				lease, err := Lock(ctx, "lock.concurrent", time.Second)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if atomic.AddInt32(&holders, 1) > 1 {
+					atomic.AddInt32(&overlaps, 1)
+				}
+				lock.Lock()
+				tokens = append(tokens, lease.Token)
+				lock.Unlock()
+				time.Sleep(20 * time.Millisecond)
+				atomic.AddInt32(&holders, -1)
+				if err := lease.Release(context.Background()); err != nil {
+					t.Error(err)
+				}
+			}()
+		}
+		wait.Wait()
+
+		if overlaps != 0 {
+			t.Errorf("egorm: Expected one holder at a time, got %d overlaps", overlaps)
+		}
+		seen := make(map[int64]bool)
+		for _, token := range tokens {
+			if seen[token] {
+				t.Errorf("egorm: Expected unique tokens, got %v", tokens)
+			}
+			seen[token] = true
+		}
+		if len(tokens) != 5 {
+			t.Errorf("egorm: Expected %d items, got %d", 5, len(tokens))
+		}
+	})
+}
+
+func TestElect(t *testing.T) {
+	t.Run("TestElect", func(t *testing.T) {
+		fakeSetup()
+		var leaders, overlaps int32
+		elected := make(chan int, 10)
+		cancels := make([]context.CancelFunc, 3)
+		dones := make([]<-chan struct{}, 3)
+		for i := range cancels {
+			i := i
+			var ctx context.Context
+			ctx, cancels[i] = context.WithCancel(context.Background())
+			dones[i] = Elect(ctx, "lock.leader", ElectionCallbacks{
+				OnElected: func(ctx context.Context, token int64) {
+					if atomic.AddInt32(&leaders, 1) > 1 {
+						atomic.AddInt32(&overlaps, 1)
+					}
+					elected <- i
+					<-ctx.Done()
+				},
+				OnDemoted: func() {
+					atomic.AddInt32(&leaders, -1)
+				},
+			}, ElectionTTL(MinLeaseTTL))
+		}
+
+		// Every stopped leader is replaced by one of the remaining instances
+		for round := 0; round < 3; round++ {
+			select {
+			case leader := <-elected:
+				// Longer than the ttl, so the leader has to renew its lease
+				time.Sleep(MinLeaseTTL + 200*time.Millisecond)
+				cancels[leader]()
+				<-dones[leader]
+			case <-time.After(5 * time.Second):
+				t.Errorf("egorm: Expected a leader in round %d", round)
+				return
+			}
+		}
+		for i := range cancels {
+			cancels[i]()
+			<-dones[i]
+		}
+		if overlaps != 0 {
+			t.Errorf("egorm: Expected one leader at a time, got %d overlaps", overlaps)
+		}
+	})
+
+	t.Run("TestLeaseExpired", func(t *testing.T) {
+		fakeSetup()
+		lease, err := TryLock(context.Background(), "lock.lead", MinLeaseTTL)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		lease.ExpiresAt = time.Now().Add(50 * time.Millisecond)
+		// Renewals never happen, so the leadership has to end with the lease
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		ended := make(chan struct{})
+		go func() {
+			defer close(ended)
+			lead(context.Background(), lease, ElectionCallbacks{
+				OnElected: func(ctx context.Context, token int64) {
+					<-ctx.Done()
+				},
+			}, ticker)
+		}()
+		select {
+		case <-ended:
+		case <-time.After(5 * time.Second):
+			t.Error("egorm: Expected the leadership to end with its lease")
+		}
+	})
+
+	t.Run("TestInvalidTTL", func(t *testing.T) {
+		fakeSetup()
+		if _, err := TryLock(context.Background(), "lock.invalid", 0); err == nil {
+			t.Error("egorm: Expected an error for a ttl of 0")
+		}
+		if _, err := Lock(context.Background(), "lock.invalid", 10*time.Millisecond); err == nil {
+			t.Errorf("egorm: Expected an error for a ttl shorter than %s", MinLeaseTTL)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := Elect(ctx, "lock.invalid", ElectionCallbacks{}, ElectionTTL(0))
+		cancel()
+		<-done
+	})
+}
@@ -0,0 +1,250 @@
+package egorm
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// MetricsBuckets are the upper bounds in seconds of the operation latency histogram.
+var MetricsBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// OperationMetrics are the counters of one egorm operation, e.g. "create" or "find".
+type OperationMetrics struct {
+	Count uint64
+	// Errors counts the failed operations by error class, e.g. "unique_violation"
+	Errors map[string]uint64
+	// DurationSeconds is the total latency of all operations
+	DurationSeconds float64
+	// Buckets counts the operations per MetricsBuckets bound, not cumulative
+	Buckets []uint64
+}
+
+// Metrics is a snapshot of the metrics of the database layer since the start of the process.
+type Metrics struct {
+	Operations map[string]OperationMetrics
+	Migrations uint64
+	Cache      CacheStats
+	Retry      RetryStats
+	// Pools are the connection pool statistics of the primary database and the replicas
+	Pools map[string]sql.DBStats
+}
+
+var metricsLock sync.Mutex
+var operationMetrics = make(map[string]*OperationMetrics)
+var migrationCount uint64
+
+func init() {
+	expvar.Publish("egorm", expvar.Func(func() any {
+		return GetMetrics()
+	}))
+}
+
+// observeOperation records the outcome and latency of an operation.
+func observeOperation(operation string, duration time.Duration, err error) {
+	metricsLock.Lock()
+	defer metricsLock.Unlock()
+
+	metrics, ok := operationMetrics[operation]
+	if !ok {
+		metrics = &OperationMetrics{Errors: make(map[string]uint64), Buckets: make([]uint64, len(MetricsBuckets))}
+		operationMetrics[operation] = metrics
+	}
+	metrics.Count++
+	metrics.DurationSeconds += duration.Seconds()
+	for i, bound := range MetricsBuckets {
+		if duration.Seconds() <= bound {
+			metrics.Buckets[i]++
+			break
+		}
+	}
+	if err != nil {
+		metrics.Errors[errorClass(err)]++
+	}
+}
+
+// errorClass returns the metrics label of an error.
+func errorClass(err error) string {
+	for _, class := range []struct {
+		err  error
+		name string
+	}{
+		{ErrUniqueViolation, "unique_violation"},
+		{ErrForeignKeyViolation, "foreign_key_violation"},
+		{ErrNotNullViolation, "not_null_violation"},
+		{ErrCheckViolation, "check_violation"},
+		{ErrSerialization, "serialization"},
+		{ErrBusy, "busy"},
+		{ErrConnection, "connection"},
+		{ErrStaleObject, "stale_object"},
+		{ErrValidation, "validation"},
+		{gorm.ErrRecordNotFound, "not_found"},
+		{context.Canceled, "canceled"},
+		{context.DeadlineExceeded, "timeout"},
+	} {
+		if errors.Is(err, class.err) {
+			return class.name
+		}
+	}
+	return "other"
+}
+
+// GetMetrics returns a snapshot of the metrics.
+func GetMetrics() Metrics {
+	metrics := Metrics{
+		Operations: make(map[string]OperationMetrics),
+		Migrations: atomic.LoadUint64(&migrationCount),
+		Cache:      GetCacheStats(),
+		Retry:      GetRetryStats(),
+		Pools:      make(map[string]sql.DBStats),
+	}
+
+	metricsLock.Lock()
+	for operation, operationMetric := range operationMetrics {
+		copied := *operationMetric
+		copied.Errors = make(map[string]uint64, len(operationMetric.Errors))
+		for class, count := range operationMetric.Errors {
+			copied.Errors[class] = count
+		}
+		copied.Buckets = append([]uint64(nil), operationMetric.Buckets...)
+		metrics.Operations[operation] = copied
+	}
+	metricsLock.Unlock()
+
+	dbLock.RLock()
+	defer dbLock.RUnlock()
+	instances := map[string]*gorm.DB{"primary": Db}
+	for i, replica := range replicaDbs {
+		instances["replica_"+strconv.Itoa(i)] = replica
+	}
+	for name, instance := range instances {
+		if instance == nil {
+			continue
+		}
+		if sqlDB, err := instance.DB(); err == nil {
+			metrics.Pools[name] = sqlDB.Stats()
+		}
+	}
+	return metrics
+}
+
+// MetricsHandler serves the metrics in the Prometheus text exposition format.
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Write(formatMetrics(GetMetrics()))
+	})
+}
+
+func formatMetrics(metrics Metrics) []byte {
+	var out bytes.Buffer
+	header := func(name string, metricType string, help string) {
+		fmt.Fprintf(&out, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, metricType)
+	}
+	sample := func(name string, labels map[string]string, value interface{}) {
+		fmt.Fprintf(&out, "%s%s %v\n", name, formatLabels(labels), value)
+	}
+
+	operations := make([]string, 0, len(metrics.Operations))
+	for operation := range metrics.Operations {
+		operations = append(operations, operation)
+	}
+	sort.Strings(operations)
+
+	header("egorm_operations_total", "counter", "Number of database operations.")
+	for _, operation := range operations {
+		sample("egorm_operations_total", map[string]string{"operation": operation}, metrics.Operations[operation].Count)
+	}
+
+	header("egorm_operation_errors_total", "counter", "Number of failed database operations by error class.")
+	for _, operation := range operations {
+		errorMetrics := metrics.Operations[operation].Errors
+		classes := make([]string, 0, len(errorMetrics))
+		for class := range errorMetrics {
+			classes = append(classes, class)
+		}
+		sort.Strings(classes)
+		for _, class := range classes {
+			sample("egorm_operation_errors_total", map[string]string{"operation": operation, "class": class}, errorMetrics[class])
+		}
+	}
+
+	header("egorm_operation_duration_seconds", "histogram", "Latency of database operations including retries.")
+	for _, operation := range operations {
+		operationMetric := metrics.Operations[operation]
+		cumulative := uint64(0)
+		for i, bound := range MetricsBuckets {
+			cumulative += operationMetric.Buckets[i]
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			sample("egorm_operation_duration_seconds_bucket", map[string]string{"operation": operation, "le": le}, cumulative)
+		}
+		sample("egorm_operation_duration_seconds_bucket", map[string]string{"operation": operation, "le": "+Inf"}, operationMetric.Count)
+		sample("egorm_operation_duration_seconds_sum", map[string]string{"operation": operation}, operationMetric.DurationSeconds)
+		sample("egorm_operation_duration_seconds_count", map[string]string{"operation": operation}, operationMetric.Count)
+	}
+
+	header("egorm_migrations_total", "counter", "Number of auto migrations run.")
+	sample("egorm_migrations_total", nil, metrics.Migrations)
+	header("egorm_cache_hits_total", "counter", "Number of reads served from the cache.")
+	sample("egorm_cache_hits_total", nil, metrics.Cache.Hits)
+	header("egorm_cache_misses_total", "counter", "Number of cached reads that went to the database.")
+	sample("egorm_cache_misses_total", nil, metrics.Cache.Misses)
+	header("egorm_retries_total", "counter", "Number of repeated attempts after transient errors.")
+	sample("egorm_retries_total", nil, metrics.Retry.Retries)
+	header("egorm_retries_exhausted_total", "counter", "Number of operations that failed after all attempts.")
+	sample("egorm_retries_exhausted_total", nil, metrics.Retry.Exhausted)
+
+	pools := make([]string, 0, len(metrics.Pools))
+	for pool := range metrics.Pools {
+		pools = append(pools, pool)
+	}
+	sort.Strings(pools)
+	for _, gauge := range []struct {
+		name       string
+		metricType string
+		help       string
+		value      func(sql.DBStats) interface{}
+	}{
+		{"egorm_pool_open_connections", "gauge", "Number of open connections.", func(s sql.DBStats) interface{} { return s.OpenConnections }},
+		{"egorm_pool_in_use_connections", "gauge", "Number of connections in use.", func(s sql.DBStats) interface{} { return s.InUse }},
+		{"egorm_pool_idle_connections", "gauge", "Number of idle connections.", func(s sql.DBStats) interface{} { return s.Idle }},
+		{"egorm_pool_wait_total", "counter", "Number of waits for a connection.", func(s sql.DBStats) interface{} { return s.WaitCount }},
+		{"egorm_pool_wait_duration_seconds_total", "counter", "Total time waited for a connection.", func(s sql.DBStats) interface{} { return s.WaitDuration.Seconds() }},
+	} {
+		header(gauge.name, gauge.metricType, gauge.help)
+		for _, pool := range pools {
+			sample(gauge.name, map[string]string{"db": pool}, gauge.value(metrics.Pools[pool]))
+		}
+	}
+	return out.Bytes()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(labels))
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(labels[name]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
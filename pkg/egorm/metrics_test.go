@@ -0,0 +1,83 @@
+package egorm
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type MetricsSample struct {
+	gorm.Model
+	Name string `gorm:"uniqueIndex"`
+}
+
+func TestMetrics(t *testing.T) {
+	t.Run("TestMetrics", func(t *testing.T) {
+		fakeSetup()
+		before := GetMetrics()
+		if err := DbCreate(&MetricsSample{Name: "first"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbCreate(&MetricsSample{Name: "first"}); !errors.Is(err, ErrUniqueViolation) {
+			t.Errorf("egorm: Expected ErrUniqueViolation, got %v", err)
+		}
+		// DbFirst hides the not found error, so it is not counted either
+		var sample MetricsSample
+		if err := DbFirst(&sample, Where{"name": "missing"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		after := GetMetrics()
+		create := after.Operations["create"]
+		if create.Count-before.Operations["create"].Count != 2 {
+			t.Errorf("egorm: Expected %d creates, got %d", 2, create.Count-before.Operations["create"].Count)
+		}
+		if create.Errors["unique_violation"]-before.Operations["create"].Errors["unique_violation"] != 1 {
+			t.Errorf("egorm: Expected a unique violation, got %v", create.Errors)
+		}
+		first := after.Operations["first"]
+		if first.Count-before.Operations["first"].Count != 1 || first.Errors["not_found"] != before.Operations["first"].Errors["not_found"] {
+			t.Errorf("egorm: Expected a first without errors, got %v", first.Errors)
+		}
+		if after.Migrations == 0 {
+			t.Error("egorm: Expected the migration to be counted")
+		}
+		if _, ok := after.Pools["primary"]; !ok {
+			t.Errorf("egorm: Expected pool stats of the primary, got %v", after.Pools)
+		}
+
+		recorder := httptest.NewRecorder()
+		MetricsHandler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+		body := recorder.Body.String()
+		for _, expected := range []string{
+			"# TYPE egorm_operation_duration_seconds histogram\n",
+			`egorm_operation_errors_total{class="unique_violation",operation="create"} `,
+			`egorm_operation_duration_seconds_bucket{le="+Inf",operation="create"} `,
+			`egorm_pool_open_connections{db="primary"} `,
+			"egorm_cache_hits_total ",
+		} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("egorm: Expected %q in the metrics, got %s", expected, body)
+			}
+		}
+		if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+			t.Errorf("egorm: Unexpected content type %s", recorder.Header().Get("Content-Type"))
+		}
+
+		var published Metrics
+		if err := json.Unmarshal([]byte(expvar.Get("egorm").String()), &published); err != nil {
+			t.Error(err)
+			return
+		}
+		if published.Operations["create"].Count < create.Count {
+			t.Errorf("egorm: Expected the metrics in expvar, got %+v", published.Operations["create"])
+		}
+	})
+}
@@ -3,12 +3,37 @@ package egorm
 import (
 	"fmt"
 	"reflect"
+	"sync"
+	"sync/atomic"
 
 	"github.com/martenwallewein/easy-going/pkg/eslices"
+	"gorm.io/gorm"
 )
 
 var alreadyMigratedTypes []string = make([]string, 0)
 
+// alreadyMigratedLock guards alreadyMigratedTypes, which background workers update as well.
+var alreadyMigratedLock sync.RWMutex
+
+func isMigrated(typeName string) bool {
+	alreadyMigratedLock.RLock()
+	defer alreadyMigratedLock.RUnlock()
+	return eslices.IndexOf(typeName, alreadyMigratedTypes) >= 0
+}
+
+func markMigrated(typeName string) {
+	alreadyMigratedLock.Lock()
+	defer alreadyMigratedLock.Unlock()
+	alreadyMigratedTypes = eslices.AppendToSliceIfMissing(alreadyMigratedTypes, typeName)
+}
+
+// resetMigrated forgets all migrated types, e.g. when the database is replaced.
+func resetMigrated() {
+	alreadyMigratedLock.Lock()
+	defer alreadyMigratedLock.Unlock()
+	alreadyMigratedTypes = make([]string, 0)
+}
+
 func getInterfaceTypeAsString[T any](input *T) string {
 	return reflect.TypeOf((*T)(nil)).Elem().Name()
 }
@@ -16,14 +41,36 @@ func getInterfaceTypeAsString[T any](input *T) string {
 func autoMigrate[T any](input *T) error {
 	typeName := getInterfaceTypeAsString(input)
 
-	if eslices.IndexOf(typeName, alreadyMigratedTypes) >= 0 {
+	if isMigrated(typeName) {
 		return nil
 	}
 	err := Db.AutoMigrate(input)
 	if err != nil {
-		return fmt.Errorf("egorm: Failed to perform automigration for %s: %s", typeName, err)
+		return fmt.Errorf("egorm: Failed to perform automigration for %s: %w", typeName, translateError(err))
 	}
 
-	alreadyMigratedTypes = eslices.AppendToSliceIfMissing(alreadyMigratedTypes, typeName)
+	atomic.AddUint64(&migrationCount, 1)
+	markMigrated(typeName)
+	return nil
+}
+
+// autoMigrateTx creates the table of T through tx, e.g. for writes inside a transaction where
+// migrating through Db would wait for the lock of tx on sqlite. Since tx may still roll back,
+// the type is remembered as migrated once tx committed, if it is a transaction of
+// DbTransaction. Otherwise it is migrated again with the next transaction.
+func autoMigrateTx[T any](tx *gorm.DB, input *T) error {
+	typeName := getInterfaceTypeAsString(input)
+
+	if isMigrated(typeName) {
+		return nil
+	}
+	if err := tx.AutoMigrate(input); err != nil {
+		return fmt.Errorf("egorm: Failed to perform automigration for %s: %w", typeName, translateError(err))
+	}
+	if events, ok := tx.Statement.Context.Value(pendingEventsContextKey).(*pendingEvents); ok {
+		events.add(func() {
+			markMigrated(typeName)
+		})
+	}
 	return nil
 }
@@ -0,0 +1,228 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const migrationTableName = "schema_migrations"
+
+// migrationFilePattern matches migration files like 20060102150405_add_users.up.sql.
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_([A-Za-z0-9_]+)\.(up|down)\.sql$`)
+
+// SchemaMigration records an applied SQL migration.
+type SchemaMigration struct {
+	Version   string `gorm:"primaryKey"`
+	Name      string
+	AppliedAt time.Time
+}
+
+func (SchemaMigration) TableName() string {
+	return migrationTableName
+}
+
+// Migration is a versioned SQL migration, read from a pair of files <version>_<name>.up.sql
+// and <version>_<name>.down.sql in a migrations directory.
+type Migration struct {
+	Version string
+	Name    string
+	// Up and Down are the SQL statements of the migration, Down may be empty
+	Up   string
+	Down string
+	// AppliedAt is nil for pending migrations
+	AppliedAt *time.Time
+}
+
+// MigrateOpts configures MigrateUp and MigrateDown.
+type MigrateOpts struct {
+	// Steps is the maximum number of migrations to apply or revert, 0 applies all
+	// pending migrations respectively reverts the last one
+	Steps int
+	// DryRun returns the migrations that would run without running them
+	DryRun bool
+}
+
+// LoadMigrations reads the migrations of dir ordered by version.
+func LoadMigrations(dir string) ([]Migration, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to read migrations from %s: %w", dir, err)
+	}
+	migrations := make(map[string]*Migration)
+	for _, entry := range entries {
+		match := migrationFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := migrations[match[1]]
+		if !ok {
+			migration = &Migration{Version: match[1], Name: match[2]}
+			migrations[match[1]] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("egorm: Migration %s has the names %s and %s", match[1], migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	sorted := make([]Migration, 0, len(migrations))
+	for _, migration := range migrations {
+		if strings.TrimSpace(migration.Up) == "" {
+			return nil, fmt.Errorf("egorm: Migration %s_%s has no up statements", migration.Version, migration.Name)
+		}
+		sorted = append(sorted, *migration)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Version < sorted[j].Version
+	})
+	return sorted, nil
+}
+
+// CreateMigration writes empty up and down files for a new migration named after the
+// current time and returns their paths.
+func CreateMigration(dir string, name string) (string, string, error) {
+	name = strings.Trim(regexp.MustCompile(`[^A-Za-z0-9]+`).ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", "", fmt.Errorf("egorm: Migration name is empty")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", "", err
+	}
+	base := filepath.Join(dir, time.Now().UTC().Format("20060102150405")+"_"+name)
+	up, down := base+".up.sql", base+".down.sql"
+	for _, path := range []string{up, down} {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return "", "", err
+		}
+		file.Close()
+	}
+	return up, down, nil
+}
+
+// MigrationStatus returns the migrations of dir, with the time they were applied.
+func MigrationStatus(ctx context.Context, dir string) ([]Migration, error) {
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := range migrations {
+		if record, ok := applied[migrations[i].Version]; ok {
+			appliedAt := record.AppliedAt
+			migrations[i].AppliedAt = &appliedAt
+		}
+	}
+	return migrations, nil
+}
+
+// MigrateUp applies the pending migrations of dir in order, each in its own transaction,
+// and returns the applied migrations.
+func MigrateUp(ctx context.Context, dir string, opts MigrateOpts) ([]Migration, error) {
+	migrations, err := MigrationStatus(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	pending := make([]Migration, 0)
+	for _, migration := range migrations {
+		if migration.AppliedAt == nil && (opts.Steps <= 0 || len(pending) < opts.Steps) {
+			pending = append(pending, migration)
+		}
+	}
+	if opts.DryRun {
+		return pending, nil
+	}
+
+	for i, migration := range pending {
+		err := DbTransactionContext(ctx, func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return pending[:i], fmt.Errorf("egorm: Migration %s_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return pending, nil
+}
+
+// MigrateDown reverts the last applied migrations of dir in reverse order and returns them.
+func MigrateDown(ctx context.Context, dir string, opts MigrateOpts) ([]Migration, error) {
+	migrations, err := MigrationStatus(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	steps := opts.Steps
+	if steps <= 0 {
+		steps = 1
+	}
+	reverted := make([]Migration, 0)
+	for i := len(migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+		if migrations[i].AppliedAt == nil {
+			continue
+		}
+		if strings.TrimSpace(migrations[i].Down) == "" {
+			return nil, fmt.Errorf("egorm: Migration %s_%s has no down statements", migrations[i].Version, migrations[i].Name)
+		}
+		reverted = append(reverted, migrations[i])
+	}
+	if opts.DryRun {
+		return reverted, nil
+	}
+
+	for i, migration := range reverted {
+		err := DbTransactionContext(ctx, func(tx *gorm.DB) error {
+			if err := tx.Exec(migration.Down).Error; err != nil {
+				return err
+			}
+			return tx.Delete(&SchemaMigration{Version: migration.Version}).Error
+		})
+		if err != nil {
+			return reverted[:i], fmt.Errorf("egorm: Reverting migration %s_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return reverted, nil
+}
+
+// appliedMigrations returns the applied migrations by version.
+func appliedMigrations(ctx context.Context) (map[string]SchemaMigration, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	if err := autoMigrate(&SchemaMigration{}); err != nil {
+		return nil, err
+	}
+	records := make([]SchemaMigration, 0)
+	err := withRetry(ctx, "migrate", func() error {
+		return Db.WithContext(ctx).Find(&records).Error
+	})
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+	applied := make(map[string]SchemaMigration, len(records))
+	for _, record := range records {
+		applied[record.Version] = record
+	}
+	return applied, nil
+}
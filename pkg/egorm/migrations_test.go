@@ -0,0 +1,74 @@
+package egorm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	t.Run("TestMigrations", func(t *testing.T) {
+		fakeSetup()
+		dir, err := os.MkdirTemp("", "egorm_migrations")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer os.RemoveAll(dir)
+
+		up, down, err := CreateMigration(dir, "Add Gadgets")
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		os.WriteFile(up, []byte("CREATE TABLE gadgets (id INTEGER PRIMARY KEY, name TEXT);"), 0644)
+		os.WriteFile(down, []byte("DROP TABLE gadgets;"), 0644)
+		os.WriteFile(filepath.Join(dir, "99990101000000_add_color.up.sql"), []byte("ALTER TABLE gadgets ADD COLUMN color TEXT;"), 0644)
+		os.WriteFile(filepath.Join(dir, "99990101000000_add_color.down.sql"), []byte("ALTER TABLE gadgets DROP COLUMN color;"), 0644)
+
+		ctx := context.Background()
+		if err := InitDB(); err != nil {
+			t.Error(err)
+			return
+		}
+		pending, err := MigrateUp(ctx, dir, MigrateOpts{DryRun: true})
+		if err != nil || len(pending) != 2 || pending[0].Name != "add_gadgets" {
+			t.Errorf("egorm: Expected 2 pending migrations, got %v, %v", pending, err)
+			return
+		}
+		if Db.Migrator().HasTable("gadgets") {
+			t.Error("egorm: Expected the dry run not to create the table")
+		}
+
+		if applied, err := MigrateUp(ctx, dir, MigrateOpts{Steps: 1}); err != nil || len(applied) != 1 {
+			t.Errorf("egorm: Expected 1 applied migration, got %v, %v", applied, err)
+			return
+		}
+		if applied, err := MigrateUp(ctx, dir, MigrateOpts{}); err != nil || len(applied) != 1 || applied[0].Name != "add_color" {
+			t.Errorf("egorm: Expected the second migration to be applied, got %v, %v", applied, err)
+			return
+		}
+		if !Db.Migrator().HasColumn("gadgets", "color") {
+			t.Error("egorm: Expected the migrations to add the color column")
+		}
+
+		if reverted, err := MigrateDown(ctx, dir, MigrateOpts{Steps: 2}); err != nil || len(reverted) != 2 || reverted[0].Name != "add_color" {
+			t.Errorf("egorm: Expected both migrations to be reverted, got %v, %v", reverted, err)
+			return
+		}
+		status, err := MigrationStatus(ctx, dir)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for _, migration := range status {
+			if migration.AppliedAt != nil {
+				t.Errorf("egorm: Expected %s to be reverted", migration.Name)
+			}
+		}
+		if Db.Migrator().HasTable("gadgets") {
+			t.Error("egorm: Expected the table to be dropped")
+		}
+	})
+}
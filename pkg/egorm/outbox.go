@@ -0,0 +1,364 @@
+package egorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const outboxTableName = "egorm_outbox"
+
+const (
+	OutboxStatusPending   = "pending"
+	OutboxStatusDelivered = "delivered"
+	OutboxStatusDead      = "dead"
+)
+
+// OutboxMessage is a message in the egorm_outbox table.
+type OutboxMessage struct {
+	ID      uint   `gorm:"primaryKey"`
+	Topic   string `gorm:"index:idx_outbox_due"`
+	Payload string
+	// Status is one of OutboxStatusPending, OutboxStatusDelivered and OutboxStatusDead
+	Status        string    `gorm:"index:idx_outbox_due"`
+	NextAttemptAt time.Time `gorm:"index:idx_outbox_due"`
+	Attempts      int
+	LastError     string
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+}
+
+func (OutboxMessage) TableName() string {
+	return outboxTableName
+}
+
+// Decode unmarshals the JSON payload of the message into v.
+func (m OutboxMessage) Decode(v interface{}) error {
+	if err := json.Unmarshal([]byte(m.Payload), v); err != nil {
+		return fmt.Errorf("egorm: Failed to decode outbox message %d: %w", m.ID, err)
+	}
+	return nil
+}
+
+// OutboxHandler delivers a message of the outbox. Returning an error schedules a retry.
+type OutboxHandler func(ctx context.Context, message OutboxMessage) error
+
+// OutboxStore writes messages into the outbox table and dispatches them to the handlers of
+// their topic, see Outbox.
+type OutboxStore struct {
+	lock     sync.RWMutex
+	handlers map[string]OutboxHandler
+	// dispatchLock makes the dispatcher a single writer on databases without row locks
+	dispatchLock sync.Mutex
+}
+
+// Outbox is a transactional outbox. Messages enqueued inside a transaction are only dispatched
+// once it committed, and are delivered at least once, so handlers have to be idempotent.
+var Outbox = &OutboxStore{handlers: make(map[string]OutboxHandler)}
+
+// DefaultOutboxRetryPolicy retries failed deliveries for about a quarter of an hour before a message is dead.
+var DefaultOutboxRetryPolicy = RetryPolicy{
+	MaxAttempts:    10,
+	InitialBackoff: 1 * time.Second,
+	MaxBackoff:     5 * time.Minute,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+type outboxOptions struct {
+	interval  time.Duration
+	batchSize int
+	retry     RetryPolicy
+	retention time.Duration
+}
+
+// OutboxOption configures Outbox.Dispatch and Outbox.Start.
+type OutboxOption func(*outboxOptions)
+
+// OutboxInterval sets the time between two polls of the dispatcher, defaults to one second.
+func OutboxInterval(interval time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.interval = interval
+	}
+}
+
+// OutboxBatchSize sets the maximum number of messages delivered per poll, defaults to 100.
+func OutboxBatchSize(size int) OutboxOption {
+	return func(o *outboxOptions) {
+		o.batchSize = size
+	}
+}
+
+// OutboxRetry sets the backoff between deliveries of a failing message. After MaxAttempts
+// deliveries, or once the Retryable function of the policy rejects an error, the message is dead.
+// Defaults to DefaultOutboxRetryPolicy, a MaxAttempts of 0 uses its attempts.
+func OutboxRetry(policy RetryPolicy) OutboxOption {
+	return func(o *outboxOptions) {
+		o.retry = policy
+	}
+}
+
+// OutboxRetention keeps delivered messages for the given duration before they are removed,
+// by default they are removed right after their delivery.
+func OutboxRetention(retention time.Duration) OutboxOption {
+	return func(o *outboxOptions) {
+		o.retention = retention
+	}
+}
+
+// Enqueue writes a message with the JSON encoded payload into the outbox using tx, which is
+// usually the transaction of DbTransaction. Payloads of type []byte and json.RawMessage are
+// stored as they are. Until the outbox table was created by Start, Dispatch or a committed
+// transaction of DbTransaction, Enqueue creates it in tx.
+func (o *OutboxStore) Enqueue(tx *gorm.DB, topic string, payload interface{}) error {
+	var encoded []byte
+	switch value := payload.(type) {
+	case []byte:
+		encoded = value
+	case json.RawMessage:
+		encoded = value
+	default:
+		var err error
+		if encoded, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("egorm: Failed to encode outbox message for %s: %w", topic, err)
+		}
+	}
+
+	if err := autoMigrateTx(tx, &OutboxMessage{}); err != nil {
+		return err
+	}
+
+	message := OutboxMessage{Topic: topic, Payload: string(encoded), Status: OutboxStatusPending, NextAttemptAt: time.Now()}
+	if err := tx.Create(&message).Error; err != nil {
+		return fmt.Errorf("egorm: Failed to enqueue outbox message for %s: %w", topic, translateError(err))
+	}
+	return nil
+}
+
+// Handle subscribes handler to the messages of topic, replacing a previous handler of the topic.
+// Messages of topics without a handler stay pending. The returned function unsubscribes handler.
+func (o *OutboxStore) Handle(topic string, handler OutboxHandler) func() {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.handlers[topic] = handler
+	return func() {
+		o.lock.Lock()
+		defer o.lock.Unlock()
+		delete(o.handlers, topic)
+	}
+}
+
+func (o *OutboxStore) topics() []string {
+	o.lock.RLock()
+	defer o.lock.RUnlock()
+	topics := make([]string, 0, len(o.handlers))
+	for topic := range o.handlers {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+// Start runs a dispatcher until ctx is done, it polls the outbox every interval and delivers
+// due messages. Messages which are being delivered when ctx is done are finished, the returned
+// channel is closed once the dispatcher stopped. Several dispatchers may run at the same time,
+// on postgres they skip the messages locked by others, on sqlite the dispatchers of a process take
+// turns. sqlite dispatchers of different processes may deliver the same message twice, so only
+// one process should dispatch, e.g. the leader of Elect.
+func (o *OutboxStore) Start(ctx context.Context, opts ...OutboxOption) <-chan struct{} {
+	options := newOutboxOptions(opts)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(options.interval)
+		defer ticker.Stop()
+		for {
+			delivered, err := o.Dispatch(context.WithoutCancel(ctx), opts...)
+			if err != nil {
+				eventLog().Error("egorm: Outbox dispatch failed", "error", err)
+			}
+			// A full batch hints at more due messages, so the next poll starts right away
+			if err == nil && delivered >= options.batchSize {
+				if ctx.Err() != nil {
+					return
+				}
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return done
+}
+
+func newOutboxOptions(opts []OutboxOption) outboxOptions {
+	options := outboxOptions{interval: 1 * time.Second, batchSize: 100, retry: DefaultOutboxRetryPolicy}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.retry.MaxAttempts == 0 {
+		options.retry.MaxAttempts = DefaultOutboxRetryPolicy.MaxAttempts
+	}
+	return options
+}
+
+// Dispatch delivers one batch of due messages to their handlers and removes delivered messages
+// past their retention. It returns the number of attempted deliveries.
+func (o *OutboxStore) Dispatch(ctx context.Context, opts ...OutboxOption) (int, error) {
+	if err := InitDB(); err != nil {
+		return 0, err
+	}
+	if err := autoMigrate(&OutboxMessage{}); err != nil {
+		return 0, err
+	}
+	options := newOutboxOptions(opts)
+	topics := o.topics()
+	if len(topics) == 0 {
+		return 0, nil
+	}
+
+	attempted := 0
+	err := withRetry(ctx, "outbox_dispatch", func() error {
+		attempted = 0
+		if Db.Dialector.Name() == "postgres" {
+			// The rows stay locked until the deliveries are recorded, other dispatchers skip them
+			return Db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+				messages, err := dueMessages(tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}), topics, options.batchSize)
+				if err != nil {
+					return err
+				}
+				for i := range messages {
+					attempted++
+					if err := o.deliver(ctx, tx, &messages[i], options); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		}
+
+		// sqlite has no row locks and a transaction would block the writes of the handlers. The
+		// lock only covers the dispatchers of this process, see Start.
+		o.dispatchLock.Lock()
+		defer o.dispatchLock.Unlock()
+		messages, err := dueMessages(Db.WithContext(ctx), topics, options.batchSize)
+		if err != nil {
+			return err
+		}
+		for i := range messages {
+			attempted++
+			if err := o.deliver(ctx, Db.WithContext(ctx), &messages[i], options); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return attempted, fmt.Errorf("egorm: Failed to dispatch outbox messages: %w", err)
+	}
+
+	err = withRetry(ctx, "outbox_cleanup", func() error {
+		return Db.WithContext(ctx).Where("status = ? AND delivered_at <= ?", OutboxStatusDelivered, time.Now().Add(-options.retention)).
+			Delete(&OutboxMessage{}).Error
+	})
+	if err != nil {
+		return attempted, fmt.Errorf("egorm: Failed to remove delivered outbox messages: %w", err)
+	}
+	return attempted, nil
+}
+
+func dueMessages(db *gorm.DB, topics []string, batchSize int) ([]OutboxMessage, error) {
+	messages := make([]OutboxMessage, 0)
+	err := db.Where("status = ? AND topic IN ? AND next_attempt_at <= ?", OutboxStatusPending, topics, time.Now()).
+		Order("next_attempt_at, id").Limit(batchSize).Find(&messages).Error
+	return messages, err
+}
+
+// deliver passes the message to the handler of its topic and records the outcome. The returned
+// error is the error of the recording, failed deliveries are retried later.
+func (o *OutboxStore) deliver(ctx context.Context, db *gorm.DB, message *OutboxMessage, options outboxOptions) error {
+	o.lock.RLock()
+	handler := o.handlers[message.Topic]
+	o.lock.RUnlock()
+	if handler == nil {
+		// Unsubscribed since the messages were loaded
+		return nil
+	}
+
+	err := callOutboxHandler(ctx, handler, *message)
+	message.Attempts++
+	now := time.Now()
+	switch {
+	case err == nil:
+		message.Status = OutboxStatusDelivered
+		message.DeliveredAt = &now
+		message.LastError = ""
+	case message.Attempts >= options.retry.MaxAttempts || (options.retry.Retryable != nil && !options.retry.Retryable(err)):
+		message.Status = OutboxStatusDead
+		message.LastError = err.Error()
+		eventLog().Error("egorm: Outbox message is dead after all attempts", "message", message.ID, "topic", message.Topic, "attempts", message.Attempts, "error", err)
+	default:
+		message.NextAttemptAt = now.Add(options.retry.backoff(message.Attempts))
+		message.LastError = err.Error()
+	}
+	return db.Save(message).Error
+}
+
+// callOutboxHandler turns a panic of the handler into a failed delivery.
+func callOutboxHandler(ctx context.Context, handler OutboxHandler, message OutboxMessage) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("egorm: Outbox handler of %s panicked: %v", message.Topic, recovered)
+		}
+	}()
+	return handler(ctx, message)
+}
+
+// DeadLetters returns the messages whose delivery failed too often, oldest first.
+func (o *OutboxStore) DeadLetters(ctx context.Context) ([]OutboxMessage, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	if err := autoMigrate(&OutboxMessage{}); err != nil {
+		return nil, err
+	}
+	messages := make([]OutboxMessage, 0)
+	err := withRetry(ctx, "get", func() error {
+		return Db.WithContext(ctx).Where("status = ?", OutboxStatusDead).Order("id").Find(&messages).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to get dead outbox messages: %w", err)
+	}
+	return messages, nil
+}
+
+// Requeue resets the attempts of a dead message, so it is delivered again with the next dispatch.
+func (o *OutboxStore) Requeue(ctx context.Context, id uint) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	if err := autoMigrate(&OutboxMessage{}); err != nil {
+		return err
+	}
+	var affected int64
+	err := withRetry(ctx, "update", func() error {
+		result := Db.WithContext(ctx).Model(&OutboxMessage{}).Where("id = ? AND status = ?", id, OutboxStatusDead).
+			Updates(map[string]interface{}{"status": OutboxStatusPending, "attempts": 0, "next_attempt_at": time.Now()})
+		affected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to requeue outbox message %d: %w", id, err)
+	}
+	if affected == 0 {
+		return fmt.Errorf("egorm: Outbox message %d is not dead: %w", id, gorm.ErrRecordNotFound)
+	}
+	return nil
+}
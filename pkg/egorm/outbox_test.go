@@ -0,0 +1,250 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type OutboxOrder struct {
+	gorm.Model
+	Item string
+}
+
+type orderPlaced struct {
+	OrderID uint
+	Item    string
+}
+
+func TestOutbox(t *testing.T) {
+	t.Run("TestEnqueue", func(t *testing.T) {
+		fakeSetup()
+		if err := InitDB(); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Db.AutoMigrate(&OutboxOrder{}); err != nil {
+			t.Error(err)
+			return
+		}
+		// Enqueue migrates the outbox in the transaction, it is remembered once that committed
+		resetMigrated()
+		errRollback := errors.New("rollback")
+		err := DbTransaction(func(tx *gorm.DB) error {
+			if err := Outbox.Enqueue(tx, "outbox.rolled_back", orderPlaced{Item: "book"}); err != nil {
+				return err
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Errorf("egorm: Expected the transaction to roll back, got %v", err)
+		}
+		if isMigrated("OutboxMessage") {
+			t.Error("egorm: Expected the migration of a rolled back transaction not to be remembered")
+		}
+
+		order := OutboxOrder{Item: "book"}
+		err = DbTransaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&order).Error; err != nil {
+				return err
+			}
+			return Outbox.Enqueue(tx, "outbox.placed", orderPlaced{OrderID: order.ID, Item: order.Item})
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if !isMigrated("OutboxMessage") {
+			t.Error("egorm: Expected the migration of a committed transaction to be remembered")
+		}
+
+		messages := make([]OutboxMessage, 0)
+		if err := Db.Where("topic LIKE ?", "outbox.%").Find(&messages).Error; err != nil {
+			t.Error(err)
+			return
+		}
+		if len(messages) != 1 {
+			t.Errorf("egorm: Expected %d items, got %d", 1, len(messages))
+			return
+		}
+		var payload orderPlaced
+		if err := messages[0].Decode(&payload); err != nil {
+			t.Error(err)
+			return
+		}
+		if messages[0].Status != OutboxStatusPending || payload.OrderID != order.ID || payload.Item != "book" {
+			t.Errorf("egorm: Expected a pending message of the order, got %+v", messages[0])
+		}
+	})
+
+	t.Run("TestDispatch", func(t *testing.T) {
+		fakeSetup()
+		for i := 0; i < 3; i++ {
+			if err := Outbox.Enqueue(Db, "dispatch.placed", orderPlaced{OrderID: uint(i)}); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		// Messages of topics without a handler stay pending
+		if err := Outbox.Enqueue(Db, "dispatch.unhandled", orderPlaced{}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		received := make([]uint, 0)
+		defer Outbox.Handle("dispatch.placed", func(ctx context.Context, message OutboxMessage) error {
+			var payload orderPlaced
+			if err := message.Decode(&payload); err != nil {
+				return err
+			}
+			received = append(received, payload.OrderID)
+			return nil
+		})()
+
+		delivered, err := Outbox.Dispatch(context.Background(), OutboxBatchSize(2))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if delivered != 2 {
+			t.Errorf("egorm: Expected %d deliveries, got %d", 2, delivered)
+		}
+		if _, err := Outbox.Dispatch(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(received) != 3 || received[0] != 0 || received[2] != 2 {
+			t.Errorf("egorm: Expected the messages in order, got %v", received)
+		}
+
+		var remaining int64
+		if err := Db.Model(&OutboxMessage{}).Where("topic LIKE ?", "dispatch.%").Count(&remaining).Error; err != nil {
+			t.Error(err)
+			return
+		}
+		if remaining != 1 {
+			t.Errorf("egorm: Expected only the unhandled message to remain, got %d", remaining)
+		}
+	})
+
+	t.Run("TestDeadLetter", func(t *testing.T) {
+		fakeSetup()
+		if err := Outbox.Enqueue(Db, "dead.placed", orderPlaced{Item: "broken"}); err != nil {
+			t.Error(err)
+			return
+		}
+		failing := true
+		attempts := 0
+		defer Outbox.Handle("dead.placed", func(ctx context.Context, message OutboxMessage) error {
+			attempts++
+			if failing {
+				return errors.New("unavailable")
+			}
+			return nil
+		})()
+
+		retry := OutboxRetry(RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour, Multiplier: 1})
+		if _, err := Outbox.Dispatch(context.Background(), retry); err != nil {
+			t.Error(err)
+			return
+		}
+		// The backoff delays the next attempt
+		if _, err := Outbox.Dispatch(context.Background(), retry); err != nil {
+			t.Error(err)
+			return
+		}
+		if attempts != 1 {
+			t.Errorf("egorm: Expected %d attempts, got %d", 1, attempts)
+		}
+
+		noBackoff := OutboxRetry(RetryPolicy{MaxAttempts: 3})
+		for i := 0; i < 3; i++ {
+			if err := Db.Model(&OutboxMessage{}).Where("topic = ?", "dead.placed").Update("next_attempt_at", time.Now()).Error; err != nil {
+				t.Error(err)
+				return
+			}
+			if _, err := Outbox.Dispatch(context.Background(), noBackoff); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+		if attempts != 3 {
+			t.Errorf("egorm: Expected %d attempts, got %d", 3, attempts)
+		}
+
+		dead, err := Outbox.DeadLetters(context.Background())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(dead) != 1 || dead[0].Attempts != 3 || dead[0].LastError != "unavailable" {
+			t.Errorf("egorm: Expected one dead message after 3 attempts, got %+v", dead)
+			return
+		}
+
+		failing = false
+		id := dead[0].ID
+		if err := Outbox.Requeue(context.Background(), id); err != nil {
+			t.Error(err)
+			return
+		}
+		if _, err := Outbox.Dispatch(context.Background()); err != nil {
+			t.Error(err)
+			return
+		}
+		if dead, err = Outbox.DeadLetters(context.Background()); err != nil || len(dead) != 0 {
+			t.Errorf("egorm: Expected no dead messages after the requeue, got %d, %v", len(dead), err)
+		}
+		if err := Outbox.Requeue(context.Background(), id); !errors.Is(err, gorm.ErrRecordNotFound) {
+			t.Errorf("egorm: Expected ErrRecordNotFound, got %v", err)
+		}
+	})
+
+	t.Run("TestStart", func(t *testing.T) {
+		fakeSetup()
+		received := make(chan string, 1)
+		defer Outbox.Handle("start.placed", func(ctx context.Context, message OutboxMessage) error {
+			var payload orderPlaced
+			if err := message.Decode(&payload); err != nil {
+				return err
+			}
+			// Handlers may write to the database while they are dispatched
+			if err := DbCreateContext(ctx, &OutboxOrder{Item: payload.Item}); err != nil {
+				return err
+			}
+			received <- payload.Item
+			return nil
+		})()
+
+		err := DbTransaction(func(tx *gorm.DB) error {
+			return Outbox.Enqueue(tx, "start.placed", orderPlaced{Item: "lamp"})
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		done := Outbox.Start(ctx, OutboxInterval(10*time.Millisecond))
+
+		select {
+		case item := <-received:
+			if item != "lamp" {
+				t.Errorf("egorm: Expected the lamp to be delivered, got %s", item)
+			}
+		case <-time.After(5 * time.Second):
+			t.Error("egorm: Expected the dispatcher to deliver the message")
+		}
+		cancel()
+		<-done
+	})
+
+	t.Run("TestOutboxRetryDefaults", func(t *testing.T) {
+		options := newOutboxOptions([]OutboxOption{OutboxRetry(RetryPolicy{InitialBackoff: time.Second})})
+		if options.retry.MaxAttempts != DefaultOutboxRetryPolicy.MaxAttempts || options.retry.InitialBackoff != time.Second {
+			t.Errorf("egorm: Expected the default attempts for a policy without attempts, got %+v", options.retry)
+		}
+	})
+}
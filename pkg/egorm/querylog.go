@@ -0,0 +1,291 @@
+package egorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// LevelOff disables query logging if used as QueryLogOpts.Level.
+const LevelOff = slog.Level(16)
+
+// maxSampledQueries bounds the number of distinct queries the sampler keeps counters for.
+const maxSampledQueries = 10000
+
+// QueryLogOpts configures the structured query logger. Successful queries are logged at Debug,
+// slow queries at Warn and failed queries at Error.
+type QueryLogOpts struct {
+	// Logger receives the records, defaults to slog.Default(). egorm also writes its own records,
+	// e.g. of retries and failed background work, to it
+	Logger *slog.Logger
+	// Level is the minimum level of logged records, use LevelOff to disable logging
+	Level slog.Level
+	// SlowThreshold logs queries that take longer at Warn, 0 disables the detection
+	SlowThreshold time.Duration
+	// SampleRate logs only every n-th execution of the same successful query, 0 or 1 log all
+	SampleRate int
+	// Redact returns the logged value of a query argument, defaults to RedactStrings
+	Redact func(arg interface{}) interface{}
+}
+
+// RedactStrings hides string and binary arguments, which may contain personal data or secrets.
+// Numbers, booleans, times and NULL are logged as is.
+func RedactStrings(arg interface{}) interface{} {
+	switch v := arg.(type) {
+	case nil, bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Time:
+		return v
+	case *time.Time:
+		return v
+	}
+	return "[redacted]"
+}
+
+// RedactNone logs all arguments as is.
+func RedactNone(arg interface{}) interface{} {
+	return arg
+}
+
+// queryLogOptsFromEnv reads the query log configuration from EGORM_LOG_* env variables,
+// it returns nil if EGORM_LOG_LEVEL is not set.
+func queryLogOptsFromEnv() (*QueryLogOpts, error) {
+	level := os.Getenv("EGORM_LOG_LEVEL")
+	if level == "" {
+		return nil, nil
+	}
+	opts := &QueryLogOpts{}
+	if strings.EqualFold(level, "off") {
+		opts.Level = LevelOff
+	} else if err := opts.Level.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("egorm: Invalid EGORM_LOG_LEVEL %q: %w", level, err)
+	}
+	if threshold := os.Getenv("EGORM_LOG_SLOW_THRESHOLD"); threshold != "" {
+		var err error
+		if opts.SlowThreshold, err = time.ParseDuration(threshold); err != nil {
+			return nil, fmt.Errorf("egorm: Invalid EGORM_LOG_SLOW_THRESHOLD %q: %w", threshold, err)
+		}
+	}
+	if rate := os.Getenv("EGORM_LOG_SAMPLE_RATE"); rate != "" {
+		var err error
+		if opts.SampleRate, err = strconv.Atoi(rate); err != nil {
+			return nil, fmt.Errorf("egorm: Invalid EGORM_LOG_SAMPLE_RATE %q: %w", rate, err)
+		}
+	}
+	if redact := os.Getenv("EGORM_LOG_REDACT"); redact != "" {
+		enabled, err := strconv.ParseBool(redact)
+		if err != nil {
+			return nil, fmt.Errorf("egorm: Invalid EGORM_LOG_REDACT %q: %w", redact, err)
+		}
+		if !enabled {
+			opts.Redact = RedactNone
+		}
+	}
+	return opts, nil
+}
+
+// gormConfig returns the gorm configuration with the query logger of the connect options,
+// or of the env if they do not configure one. Without either gorm's default logger is used.
+func gormConfig(opts *QueryLogOpts) (*gorm.Config, error) {
+	if opts == nil {
+		var err error
+		if opts, err = queryLogOptsFromEnv(); err != nil || opts == nil {
+			eventLogger.Store(nil)
+			return &gorm.Config{}, err
+		}
+	}
+	eventLogger.Store(opts.Logger)
+	return &gorm.Config{Logger: NewQueryLogger(*opts)}, nil
+}
+
+// eventLogger is the logger of the query log options, if they configure one.
+var eventLogger atomic.Pointer[slog.Logger]
+
+// eventLog returns the logger of egorm's own records, e.g. of retries and failed background
+// work. It is the logger of the query log options and defaults to slog.Default().
+func eventLog() *slog.Logger {
+	if l := eventLogger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+type queryLogger struct {
+	opts    QueryLogOpts
+	sampler *querySampler
+}
+
+// NewQueryLogger returns a gorm logger that writes structured records with log/slog. The record
+// of a query has the attributes sql, args, duration, rows and caller. egorm uses it if the connect
+// options or env configure query logging, it can also be passed to gorm.Config directly.
+func NewQueryLogger(opts QueryLogOpts) logger.Interface {
+	if opts.Logger == nil {
+		opts.Logger = slog.Default()
+	}
+	if opts.Redact == nil {
+		opts.Redact = RedactStrings
+	}
+	return &queryLogger{opts: opts, sampler: &querySampler{counts: make(map[string]int)}}
+}
+
+// LogMode maps the gorm log levels, e.g. of db.Debug(), to slog levels.
+func (l *queryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	switch level {
+	case logger.Silent:
+		copied.opts.Level = LevelOff
+	case logger.Error:
+		copied.opts.Level = slog.LevelError
+	case logger.Warn:
+		copied.opts.Level = slog.LevelWarn
+	case logger.Info:
+		copied.opts.Level = slog.LevelDebug
+	}
+	return &copied
+}
+
+func (l *queryLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	l.log(ctx, slog.LevelInfo, fmt.Sprintf(msg, data...))
+}
+
+func (l *queryLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.log(ctx, slog.LevelWarn, fmt.Sprintf(msg, data...))
+}
+
+func (l *queryLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	l.log(ctx, slog.LevelError, fmt.Sprintf(msg, data...))
+}
+
+func (l *queryLogger) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if level < l.opts.Level || !l.opts.Logger.Enabled(ctx, level) {
+		return
+	}
+	attrs = append(attrs, slog.String("caller", queryCaller()))
+	l.opts.Logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+func (l *queryLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.opts.Level >= LevelOff {
+		return
+	}
+	elapsed := time.Since(begin)
+
+	level, msg := slog.LevelDebug, "egorm: Query"
+	switch {
+	case err != nil && !errors.Is(err, gorm.ErrRecordNotFound):
+		level, msg = slog.LevelError, "egorm: Query failed"
+	case l.opts.SlowThreshold > 0 && elapsed > l.opts.SlowThreshold:
+		level, msg = slog.LevelWarn, "egorm: Slow query"
+	}
+	if level < l.opts.Level || !l.opts.Logger.Enabled(ctx, level) {
+		return
+	}
+
+	// ParamsFilter stores the SQL with placeholders and the arguments in the capture of the statement
+	explained, rows := fc()
+	sql, args := explained, []interface{}(nil)
+	if capture, ok := ctx.Value(queryCaptureContextKey).(*queryCapture); ok && capture.sql != "" {
+		sql, args = capture.sql, capture.args
+	}
+
+	attrs := []slog.Attr{
+		slog.String("sql", sql),
+		slog.Any("args", args),
+		slog.Duration("duration", elapsed),
+		slog.Int64("rows", rows),
+	}
+	if level == slog.LevelDebug && l.opts.SampleRate > 1 {
+		count, sampled := l.sampler.sample(sql, l.opts.SampleRate)
+		if !sampled {
+			return
+		}
+		attrs = append(attrs, slog.Int("count", count))
+	}
+	if err != nil {
+		attrs = append(attrs, slog.String("error", err.Error()))
+	}
+	l.log(ctx, level, msg, attrs...)
+}
+
+// ParamsFilter keeps the arguments out of the SQL and records redacted copies of them.
+func (l *queryLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	redacted := make([]interface{}, len(params))
+	for i, param := range params {
+		if valuer, ok := param.(driver.Valuer); ok {
+			if value, err := valuer.Value(); err == nil {
+				param = value
+			}
+		}
+		redacted[i] = l.opts.Redact(param)
+	}
+	if capture, ok := ctx.Value(queryCaptureContextKey).(*queryCapture); ok {
+		capture.sql, capture.args = sql, redacted
+	}
+	return sql, redacted
+}
+
+// queryCapture transports the SQL and arguments of a statement from ParamsFilter to Trace,
+// which both only receive the context of the statement.
+type queryCapture struct {
+	sql  string
+	args []interface{}
+}
+
+// queryLogBeforeStatement adds a capture to the context of statements that are logged.
+// Every execution gets its own capture, since statements may share their context.
+func queryLogBeforeStatement(db *gorm.DB) {
+	if _, ok := db.Logger.(*queryLogger); !ok || db.Statement.Context == nil {
+		return
+	}
+	db.Statement.Context = context.WithValue(db.Statement.Context, queryCaptureContextKey, &queryCapture{})
+}
+
+type querySampler struct {
+	mutex  sync.Mutex
+	counts map[string]int
+}
+
+// sample counts the executions of sql and reports whether this one is logged.
+func (s *querySampler) sample(sql string, rate int) (int, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.counts[sql]; !ok && len(s.counts) >= maxSampledQueries {
+		s.counts = make(map[string]int)
+	}
+	s.counts[sql]++
+	count := s.counts[sql]
+	return count, count%rate == 1
+}
+
+var egormSourceDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file)
+}()
+
+// queryCaller returns the first caller outside of gorm and egorm.
+func queryCaller() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs)])
+	for {
+		frame, more := frames.Next()
+		internal := strings.Contains(frame.File, "gorm.io/") ||
+			(filepath.Dir(frame.File) == egormSourceDir && !strings.HasSuffix(frame.File, "_test.go"))
+		if !internal && !strings.HasPrefix(frame.Function, "database/sql") && !strings.HasPrefix(frame.Function, "runtime.") {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
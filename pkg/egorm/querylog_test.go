@@ -0,0 +1,137 @@
+package egorm
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type QueryLogSample struct {
+	gorm.Model
+	Email string
+	Age   int
+}
+
+// readLogRecords parses the JSON records written by a slog.JSONHandler.
+func readLogRecords(t *testing.T, buffer *bytes.Buffer) []map[string]interface{} {
+	records := make([]map[string]interface{}, 0)
+	for _, line := range strings.Split(strings.TrimSpace(buffer.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		record := make(map[string]interface{})
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Error(err)
+		}
+		records = append(records, record)
+	}
+	buffer.Reset()
+	return records
+}
+
+func TestQueryLog(t *testing.T) {
+	t.Run("TestQueryLog", func(t *testing.T) {
+		var buffer bytes.Buffer
+		if err := CloseDB(); err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			CloseDB()
+			fakeSetup()
+		}()
+		SetSQLiteConnectOpts(&SQLiteConnectOpts{
+			Path: path.Join(os.TempDir(), "egorm_test.sqlite"),
+			QueryLog: &QueryLogOpts{
+				Logger: slog.New(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug})),
+				Level:  slog.LevelDebug,
+			},
+		})
+		if err := InitDB(); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := Db.AutoMigrate(&QueryLogSample{}); err != nil {
+			t.Error(err)
+			return
+		}
+		buffer.Reset()
+
+		if err := DbCreate(&QueryLogSample{Email: "jane@example.com", Age: 42}); err != nil {
+			t.Error(err)
+			return
+		}
+		records := readLogRecords(t, &buffer)
+		var insert map[string]interface{}
+		for _, record := range records {
+			if strings.HasPrefix(record["sql"].(string), "INSERT INTO `query_log_samples`") {
+				insert = record
+			}
+		}
+		if insert == nil {
+			t.Errorf("egorm: Expected a record of the insert, got %v", records)
+			return
+		}
+		args := insert["args"].([]interface{})
+		if strings.Contains(insert["sql"].(string), "jane") || args[3] != "[redacted]" || args[4] != float64(42) {
+			t.Errorf("egorm: Expected the email to be redacted, got %v", insert)
+		}
+		if insert["level"] != "DEBUG" || insert["rows"] != float64(1) || !strings.Contains(insert["caller"].(string), "querylog_test.go") {
+			t.Errorf("egorm: Unexpected record %v", insert)
+		}
+
+		// Errors are logged at Error level
+		Db.Exec("SELECT * FROM missing_table")
+		records = readLogRecords(t, &buffer)
+		if len(records) != 1 || records[0]["level"] != "ERROR" || records[0]["error"] == nil {
+			t.Errorf("egorm: Expected an error record, got %v", records)
+		}
+	})
+
+	t.Run("TestQueryLogFilters", func(t *testing.T) {
+		var buffer bytes.Buffer
+		db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{Logger: NewQueryLogger(QueryLogOpts{
+			Logger:        slog.New(slog.NewJSONHandler(&buffer, &slog.HandlerOptions{Level: slog.LevelDebug})),
+			Level:         slog.LevelDebug,
+			SampleRate:    3,
+			SlowThreshold: 20 * time.Millisecond,
+		})})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := registerCallbacks(db); err != nil {
+			t.Error(err)
+			return
+		}
+
+		for i := 0; i < 7; i++ {
+			db.Exec("SELECT ?", i)
+		}
+		records := readLogRecords(t, &buffer)
+		if len(records) != 3 || records[2]["count"] != float64(7) {
+			t.Errorf("egorm: Expected every third query to be logged, got %v", records)
+		}
+
+		db.Exec("WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c WHERE x < 3000000) SELECT count(*) FROM c")
+		records = readLogRecords(t, &buffer)
+		if len(records) != 1 || records[0]["level"] != "WARN" {
+			t.Errorf("egorm: Expected a slow query record, got %v", records)
+		}
+
+		db.Logger = db.Logger.LogMode(logger.Silent)
+		db.Exec("SELECT 1")
+		if buffer.Len() != 0 {
+			t.Errorf("egorm: Expected no records in silent mode, got %s", buffer.String())
+		}
+	})
+}
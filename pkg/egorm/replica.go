@@ -0,0 +1,65 @@
+package egorm
+
+import (
+	"context"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// ReplicaPolicy decides which read replica serves a read.
+type ReplicaPolicy int
+
+const (
+	// RoundRobin uses all replicas in turn
+	RoundRobin ReplicaPolicy = iota
+	// LeastConnections uses the replica with the fewest connections in use
+	LeastConnections
+)
+
+var replicaDbs []*gorm.DB
+var replicaPolicy ReplicaPolicy
+var replicaCounter uint64
+
+// UsePrimary returns a context whose reads are served by the primary instead of a replica,
+// e.g. to read data that was just written and may not be replicated yet.
+func UsePrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, usePrimaryContextKey, true)
+}
+
+func usesPrimary(ctx context.Context) bool {
+	usePrimary, _ := ctx.Value(usePrimaryContextKey).(bool)
+	return usePrimary
+}
+
+// readDB returns the database that serves reads for the context. Without replicas or
+// if UsePrimary was set, this is the primary.
+func readDB(ctx context.Context) *gorm.DB {
+	if len(replicaDbs) == 0 || usesPrimary(ctx) {
+		return Db.WithContext(ctx)
+	}
+
+	switch replicaPolicy {
+	case LeastConnections:
+		return leastConnectionsReplica().WithContext(ctx)
+	default:
+		next := atomic.AddUint64(&replicaCounter, 1)
+		return replicaDbs[next%uint64(len(replicaDbs))].WithContext(ctx)
+	}
+}
+
+func leastConnectionsReplica() *gorm.DB {
+	selected := replicaDbs[0]
+	minInUse := -1
+	for _, replica := range replicaDbs {
+		sqlDB, err := replica.DB()
+		if err != nil {
+			continue
+		}
+		if inUse := sqlDB.Stats().InUse; minInUse < 0 || inUse < minInUse {
+			selected = replica
+			minInUse = inUse
+		}
+	}
+	return selected
+}
@@ -0,0 +1,80 @@
+package egorm
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+)
+
+type ReplicaSample struct {
+	gorm.Model
+	Name string
+}
+
+func TestReplicaRouting(t *testing.T) {
+	t.Run("TestReplicaRouting", func(t *testing.T) {
+		primaryPath := path.Join(os.TempDir(), "egorm_test_primary.sqlite")
+		replicaPath := path.Join(os.TempDir(), "egorm_test_replica.sqlite")
+		defer func() {
+			if err := CloseDB(); err != nil {
+				t.Error(err)
+			}
+			os.Remove(primaryPath)
+			os.Remove(replicaPath)
+			fakeSetup()
+		}()
+
+		// The replica is filled by hand, since sqlite files are not replicated
+		replica, err := gorm.Open(sqlite.Open(replicaPath), &gorm.Config{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := replica.AutoMigrate(&ReplicaSample{}); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := replica.Create(&ReplicaSample{Name: "Replica"}).Error; err != nil {
+			t.Error(err)
+			return
+		}
+		if sqlDB, err := replica.DB(); err == nil {
+			sqlDB.Close()
+		}
+
+		if err := CloseDB(); err != nil {
+			t.Error(err)
+			return
+		}
+		SetSQLiteConnectOpts(&SQLiteConnectOpts{
+			Path:     primaryPath,
+			Replicas: []string{replicaPath},
+		})
+
+		if err := DbCreate(&ReplicaSample{Name: "Primary"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var samples []ReplicaSample
+		if err := DbGetAll(&samples); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(samples) != 1 || samples[0].Name != "Replica" {
+			t.Errorf("egorm: Expected reads to be served by the replica, got %v", samples)
+		}
+
+		if err := DbGetAllContext(UsePrimary(context.Background()), &samples); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(samples) != 1 || samples[0].Name != "Primary" {
+			t.Errorf("egorm: Expected reads to be served by the primary, got %v", samples)
+		}
+	})
+}
@@ -0,0 +1,241 @@
+package egorm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type retentionPolicy struct {
+	schema    *schema.Schema
+	migrate   func() error
+	column    string
+	maxAge    time.Duration
+	hardAfter time.Duration
+}
+
+var (
+	retentionLock     sync.RWMutex
+	retentionPolicies []retentionPolicy
+)
+
+type retentionOptions struct {
+	hardAfter time.Duration
+}
+
+// RetentionOption configures Retain.
+type RetentionOption func(*retentionOptions)
+
+// HardDeleteAfter also removes soft deleted rows for good, once they were deleted longer than after.
+func HardDeleteAfter(after time.Duration) RetentionOption {
+	return func(o *retentionOptions) {
+		o.hardAfter = after
+	}
+}
+
+// Retain declares that rows of T are purged once the time in column is older than maxAge, e.g.
+// Retain[LogEntry](90*24*time.Hour, "created_at"). Rows of models with a gorm.DeletedAt field are
+// soft deleted, see HardDeleteAfter. A maxAge of 0 only applies HardDeleteAfter. Purging happens
+// in Purge and SchedulePurges, a second policy for T replaces the first one.
+func Retain[T any](maxAge time.Duration, column string, opts ...RetentionOption) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	options := retentionOptions{}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	s, err := parseSchema((*T)(nil))
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to parse the model of the retention policy: %w", err)
+	}
+	if len(s.PrimaryFields) != 1 {
+		return fmt.Errorf("egorm: Retention of %s needs exactly one primary key", s.Name)
+	}
+
+	policy := retentionPolicy{schema: s, maxAge: maxAge, hardAfter: options.hardAfter, migrate: func() error {
+		var tmp T
+		return autoMigrate(&tmp)
+	}}
+	if maxAge > 0 {
+		field := s.LookUpField(column)
+		if field == nil || field.DBName == "" {
+			return fmt.Errorf("egorm: Unknown retention column %s of %s", column, s.Name)
+		}
+		policy.column = field.DBName
+	}
+	if options.hardAfter > 0 && deletedAtField(s) == nil {
+		return fmt.Errorf("egorm: %s has no gorm.DeletedAt field to hard delete", s.Name)
+	}
+
+	retentionLock.Lock()
+	defer retentionLock.Unlock()
+	for i := range retentionPolicies {
+		if retentionPolicies[i].schema.ModelType == s.ModelType {
+			retentionPolicies[i] = policy
+			return nil
+		}
+	}
+	retentionPolicies = append(retentionPolicies, policy)
+	return nil
+}
+
+func deletedAtField(s *schema.Schema) *schema.Field {
+	for _, field := range s.Fields {
+		if field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) && field.DBName != "" {
+			return field
+		}
+	}
+	return nil
+}
+
+// PurgeResult reports the rows purged from a table, see Purge.
+type PurgeResult struct {
+	Table string
+	// Expired is the number of rows older than the retention
+	Expired int64
+	// HardDeleted is the number of soft deleted rows that were removed for good
+	HardDeleted int64
+	DryRun      bool
+}
+
+// defaultPurgeBatchSize is the number of rows Purge deletes per statement by default.
+const defaultPurgeBatchSize = 1000
+
+type purgeOptions struct {
+	batchSize int
+	dryRun    bool
+}
+
+// PurgeOption configures Purge and SchedulePurges.
+type PurgeOption func(*purgeOptions)
+
+// PurgeBatchSize sets the number of rows deleted per statement, defaults to 1000. Small batches
+// keep the locks of each statement short. Sizes below 1 use the default.
+func PurgeBatchSize(size int) PurgeOption {
+	return func(o *purgeOptions) {
+		if size < 1 {
+			size = defaultPurgeBatchSize
+		}
+		o.batchSize = size
+	}
+}
+
+// PurgeDryRun only counts the rows which would be purged.
+func PurgeDryRun() PurgeOption {
+	return func(o *purgeOptions) {
+		o.dryRun = true
+	}
+}
+
+// Purge applies all retention policies and returns the purged rows per table, in the order the
+// policies were declared. For models with a tenant, ctx has to allow all tenants, see WithoutTenant.
+func Purge(ctx context.Context, opts ...PurgeOption) ([]PurgeResult, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	options := purgeOptions{batchSize: defaultPurgeBatchSize}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	retentionLock.RLock()
+	policies := append([]retentionPolicy(nil), retentionPolicies...)
+	retentionLock.RUnlock()
+
+	results := make([]PurgeResult, 0, len(policies))
+	for _, policy := range policies {
+		if err := policy.migrate(); err != nil {
+			return results, err
+		}
+		model := reflect.New(policy.schema.ModelType).Interface()
+		result := PurgeResult{Table: policy.schema.Table, DryRun: options.dryRun}
+		now := time.Now()
+
+		var err error
+		if policy.maxAge > 0 {
+			expired := Db.WithContext(ctx).Model(model).Where(clause.Lt{Column: clause.Column{Name: policy.column}, Value: now.Add(-policy.maxAge)})
+			if result.Expired, err = purgeRows(expired, policy, options); err != nil {
+				return results, fmt.Errorf("egorm: Failed to purge expired rows of %s: %w", policy.schema.Table, err)
+			}
+		}
+		if policy.hardAfter > 0 {
+			column := clause.Column{Name: deletedAtField(policy.schema).DBName}
+			deleted := Db.WithContext(ctx).Unscoped().Model(model).Where(clause.Lt{Column: column, Value: now.Add(-policy.hardAfter)})
+			if result.HardDeleted, err = purgeRows(deleted, policy, options); err != nil {
+				return results, fmt.Errorf("egorm: Failed to hard delete rows of %s: %w", policy.schema.Table, err)
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// purgeRows deletes the rows matched by query in batches, or counts them in a dry run.
+func purgeRows(query *gorm.DB, policy retentionPolicy, options purgeOptions) (int64, error) {
+	if options.dryRun {
+		var count int64
+		err := withRetry(query.Statement.Context, "purge", func() error {
+			return query.Session(&gorm.Session{}).Count(&count).Error
+		})
+		return count, err
+	}
+
+	primaryKey := policy.schema.PrimaryFields[0]
+	total := int64(0)
+	for {
+		ids := reflect.New(reflect.SliceOf(primaryKey.FieldType))
+		var deleted int64
+		err := withRetry(query.Statement.Context, "purge", func() error {
+			ids.Elem().SetLen(0)
+			err := query.Session(&gorm.Session{}).Order(primaryKey.DBName).Limit(options.batchSize).Pluck(primaryKey.DBName, ids.Interface()).Error
+			if err != nil || ids.Elem().Len() == 0 {
+				return err
+			}
+			result := query.Session(&gorm.Session{}).Where(map[string]interface{}{primaryKey.DBName: ids.Elem().Interface()}).
+				Delete(reflect.New(policy.schema.ModelType).Interface())
+			deleted = result.RowsAffected
+			return result.Error
+		})
+		if err != nil {
+			return total, err
+		}
+		total += deleted
+		if ids.Elem().Len() == 0 || ids.Elem().Len() < options.batchSize {
+			return total, nil
+		}
+	}
+}
+
+// SchedulePurges runs Purge every interval until ctx is done and logs the purged rows. A running
+// purge is finished after ctx is done, the returned channel is closed once the scheduler stopped.
+func SchedulePurges(ctx context.Context, interval time.Duration, opts ...PurgeOption) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				results, err := Purge(context.WithoutCancel(ctx), opts...)
+				for _, result := range results {
+					if result.Expired > 0 || result.HardDeleted > 0 {
+						eventLog().Info("egorm: Purged rows", "table", result.Table, "expired", result.Expired, "hard_deleted", result.HardDeleted)
+					}
+				}
+				if err != nil {
+					eventLog().Error("egorm: Scheduled purge failed", "error", err)
+				}
+			}
+		}
+	}()
+	return done
+}
@@ -0,0 +1,109 @@
+package egorm
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RetainedEvent struct {
+	gorm.Model
+	Message string
+}
+
+func TestRetention(t *testing.T) {
+	t.Run("TestRetention", func(t *testing.T) {
+		fakeSetup()
+		defer func() {
+			retentionPolicies = nil
+		}()
+		if err := Retain[RetainedEvent](24*time.Hour, "unknown_column"); err == nil {
+			t.Error("egorm: Expected an error for an unknown column")
+		}
+		if err := Retain[RetainedEvent](24*time.Hour, "CreatedAt", HardDeleteAfter(time.Hour)); err != nil {
+			t.Error(err)
+			return
+		}
+
+		now := time.Now()
+		events := []RetainedEvent{
+			{Model: gorm.Model{CreatedAt: now.Add(-48 * time.Hour)}, Message: "old"},
+			{Model: gorm.Model{CreatedAt: now.Add(-72 * time.Hour)}, Message: "older"},
+			{Model: gorm.Model{CreatedAt: now.Add(-96 * time.Hour)}, Message: "oldest"},
+			{Model: gorm.Model{CreatedAt: now.Add(-time.Hour)}, Message: "recent"},
+			{Model: gorm.Model{CreatedAt: now.Add(-time.Hour), DeletedAt: gorm.DeletedAt{Time: now.Add(-2 * time.Hour), Valid: true}}, Message: "deleted"},
+		}
+		for i := range events {
+			if err := DbCreate(&events[i]); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+
+		results, err := Purge(context.Background(), PurgeDryRun())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(results) != 1 || results[0].Expired != 3 || results[0].HardDeleted != 1 || !results[0].DryRun {
+			t.Errorf("egorm: Expected to count 3 expired and 1 deleted row, got %+v", results)
+		}
+		var count int64
+		if err := Db.Unscoped().Model(&RetainedEvent{}).Count(&count).Error; err != nil || count != 5 {
+			t.Errorf("egorm: Expected the dry run to keep all rows, got %d, %v", count, err)
+		}
+
+		results, err = Purge(context.Background(), PurgeBatchSize(2))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(results) != 1 || results[0].Table != "retained_events" || results[0].Expired != 3 || results[0].HardDeleted != 1 {
+			t.Errorf("egorm: Expected to purge 3 expired and 1 deleted row, got %+v", results)
+		}
+
+		remaining := make([]RetainedEvent, 0)
+		if err := DbGetAll(&remaining); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(remaining) != 1 || remaining[0].Message != "recent" {
+			t.Errorf("egorm: Expected only the recent event, got %+v", remaining)
+		}
+		// The expired rows are soft deleted, until they were deleted for an hour
+		if err := Db.Unscoped().Model(&RetainedEvent{}).Count(&count).Error; err != nil || count != 4 {
+			t.Errorf("egorm: Expected %d rows including the soft deleted ones, got %d, %v", 4, count, err)
+		}
+
+		// Invalid batch sizes use the default instead of purging without an end
+		for _, size := range []int{0, -1} {
+			old := RetainedEvent{Model: gorm.Model{CreatedAt: now.Add(-48 * time.Hour)}, Message: "old"}
+			if err := DbCreate(&old); err != nil {
+				t.Error(err)
+				return
+			}
+			purged := make(chan []PurgeResult, 1)
+			go func() {
+				results, _ := Purge(context.Background(), PurgeBatchSize(size))
+				purged <- results
+			}()
+			select {
+			case results := <-purged:
+				if len(results) != 1 || results[0].Expired != 1 {
+					t.Errorf("egorm: Expected to purge 1 expired row with batch size %d, got %+v", size, results)
+				}
+			case <-time.After(5 * time.Second):
+				t.Errorf("egorm: Expected the purge with batch size %d to finish", size)
+				return
+			}
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := SchedulePurges(ctx, 10*time.Millisecond)
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+		<-done
+	})
+}
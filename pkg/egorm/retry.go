@@ -0,0 +1,145 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// RetryPolicy configures how egorm retries operations that failed with a transient error,
+// e.g. SQLITE_BUSY or a postgres serialization failure.
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts including the first one, values below 2 disable retries
+	MaxAttempts int
+	// InitialBackoff is the wait time before the first retry
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait time between two attempts
+	MaxBackoff time.Duration
+	// Multiplier is applied to the backoff after each attempt
+	Multiplier float64
+	// Jitter randomizes each backoff by up to this fraction, e.g. 0.2 for +/- 20%
+	Jitter float64
+	// Retryable decides whether an error is transient, defaults to IsRetryable
+	Retryable func(err error) bool
+}
+
+// RetryStats counts the retries performed by egorm since the start of the process.
+type RetryStats struct {
+	// Retries is the number of attempts that were repeated
+	Retries uint64
+	// Exhausted is the number of operations that still failed after all attempts
+	Exhausted uint64
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    3,
+	InitialBackoff: 20 * time.Millisecond,
+	MaxBackoff:     1 * time.Second,
+	Multiplier:     2,
+	Jitter:         0.2,
+}
+
+var retryPolicy = DefaultRetryPolicy
+var retryCount uint64
+var retryExhaustedCount uint64
+
+// SetRetryPolicy replaces the retry policy, pass nil to disable retries.
+func SetRetryPolicy(policy *RetryPolicy) {
+	if policy == nil {
+		retryPolicy = RetryPolicy{MaxAttempts: 1}
+		return
+	}
+	retryPolicy = *policy
+}
+
+// GetRetryStats returns the retry counters.
+func GetRetryStats() RetryStats {
+	return RetryStats{
+		Retries:   atomic.LoadUint64(&retryCount),
+		Exhausted: atomic.LoadUint64(&retryExhaustedCount),
+	}
+}
+
+// IsRetryable reports whether err is a transient error which may succeed when retried.
+func IsRetryable(err error) bool {
+	return errors.Is(err, ErrBusy) || errors.Is(err, ErrSerialization)
+}
+
+func (p *RetryPolicy) backoff(attempt int) time.Duration {
+	backoff := float64(p.InitialBackoff)
+	for i := 1; i < attempt; i++ {
+		backoff *= p.Multiplier
+	}
+	if p.MaxBackoff > 0 && backoff > float64(p.MaxBackoff) {
+		backoff = float64(p.MaxBackoff)
+	}
+	if p.Jitter > 0 {
+		backoff += backoff * p.Jitter * (rand.Float64()*2 - 1)
+	}
+	return time.Duration(backoff)
+}
+
+// withRetry runs fn until it succeeds, fails with a non transient error, or the attempts
+// of the retry policy are used up. Errors are returned translated. The outcome is recorded
+// in the metrics of the operation. If ctx is done while waiting for the next attempt, the
+// last error is returned along with the error of ctx.
+func withRetry(ctx context.Context, operation string, fn func() error) (err error) {
+	start := time.Now()
+	defer func() {
+		observeOperation(operation, time.Since(start), err)
+	}()
+
+	policy := retryPolicy
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+
+	for attempt := 1; ; attempt++ {
+		err := translateError(fn())
+		if err == nil || !retryable(err) {
+			return err
+		}
+
+		if attempt >= policy.MaxAttempts {
+			if policy.MaxAttempts > 1 {
+				atomic.AddUint64(&retryExhaustedCount, 1)
+				eventLog().Warn("egorm: Giving up after all attempts", "operation", operation, "attempts", attempt, "error", err)
+			}
+			return err
+		}
+
+		atomic.AddUint64(&retryCount, 1)
+		backoff := policy.backoff(attempt)
+		eventLog().Info("egorm: Retrying", "operation", operation, "backoff", backoff, "attempt", attempt+1, "max_attempts", policy.MaxAttempts, "error", err)
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-timer.C:
+		}
+	}
+}
+
+// DbTransaction runs fn inside a transaction. The transaction is committed if fn returns nil
+// and rolled back otherwise. If it fails with a transient error, the whole closure is retried
+// according to the retry policy, so fn must not have side effects outside the transaction.
+func DbTransaction(fn func(tx *gorm.DB) error) error {
+	return DbTransactionContext(context.Background(), fn)
+}
+
+func DbTransactionContext(ctx context.Context, fn func(tx *gorm.DB) error) error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+
+	return withRetryAfterCommit(ctx, "transaction", func(ctx context.Context) error {
+		return Db.WithContext(ctx).Transaction(fn)
+	})
+}
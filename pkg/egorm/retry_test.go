@@ -0,0 +1,130 @@
+package egorm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type RetrySample struct {
+	gorm.Model
+	Name string
+}
+
+func TestRetry(t *testing.T) {
+	t.Run("TestRetry", func(t *testing.T) {
+		SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond, Multiplier: 2})
+		defer SetRetryPolicy(&DefaultRetryPolicy)
+
+		before := GetRetryStats()
+		attempts := 0
+		err := withRetry(context.Background(), "test", func() error {
+			attempts++
+			if attempts < 3 {
+				return &DbError{Kind: ErrBusy}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if attempts != 3 {
+			t.Errorf("egorm: Expected %d attempts, got %d", 3, attempts)
+		}
+		if retries := GetRetryStats().Retries - before.Retries; retries != 2 {
+			t.Errorf("egorm: Expected %d retries, got %d", 2, retries)
+		}
+
+		attempts = 0
+		err = withRetry(context.Background(), "test", func() error {
+			attempts++
+			return &DbError{Kind: ErrUniqueViolation}
+		})
+		if !errors.Is(err, ErrUniqueViolation) || attempts != 1 {
+			t.Errorf("egorm: Expected non transient errors not to be retried, got %d attempts", attempts)
+		}
+
+		attempts = 0
+		err = withRetry(context.Background(), "test", func() error {
+			attempts++
+			return &DbError{Kind: ErrSerialization}
+		})
+		if !errors.Is(err, ErrSerialization) || attempts != 3 {
+			t.Errorf("egorm: Expected %d attempts before giving up, got %d", 3, attempts)
+		}
+	})
+
+	t.Run("TestRetryLog", func(t *testing.T) {
+		SetRetryPolicy(&RetryPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond})
+		defer SetRetryPolicy(&DefaultRetryPolicy)
+		var buf bytes.Buffer
+		defer eventLogger.Store(eventLogger.Swap(slog.New(slog.NewJSONHandler(&buf, nil))))
+
+		_ = withRetry(context.Background(), "test", func() error {
+			return &DbError{Kind: ErrBusy}
+		})
+		for _, expected := range []string{`"msg":"egorm: Retrying","operation":"test"`, `"msg":"egorm: Giving up after all attempts","operation":"test","attempts":2`} {
+			if !strings.Contains(buf.String(), expected) {
+				t.Errorf("egorm: Expected the record %s, got %s", expected, buf.String())
+			}
+		}
+	})
+
+	t.Run("TestRetryCancelled", func(t *testing.T) {
+		SetRetryPolicy(&RetryPolicy{MaxAttempts: 3, InitialBackoff: time.Hour})
+		defer SetRetryPolicy(&DefaultRetryPolicy)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		attempts := 0
+		err := withRetry(ctx, "test", func() error {
+			attempts++
+			return &DbError{Kind: ErrBusy}
+		})
+		if !errors.Is(err, context.DeadlineExceeded) || !errors.Is(err, ErrBusy) || attempts != 1 {
+			t.Errorf("egorm: Expected the backoff to end with ctx after %d attempt, got %d: %v", 1, attempts, err)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("egorm: Expected the backoff to end with ctx, took %s", elapsed)
+		}
+	})
+}
+
+func TestTransaction(t *testing.T) {
+	t.Run("TestTransaction", func(t *testing.T) {
+		fakeSetup()
+		err := DbCreate(&RetrySample{Name: "Committed"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		err = DbTransaction(func(tx *gorm.DB) error {
+			if err := tx.Create(&RetrySample{Name: "RolledBack"}).Error; err != nil {
+				return err
+			}
+			return errors.New("abort")
+		})
+		if err == nil {
+			t.Error("egorm: Expected transaction error")
+			return
+		}
+
+		var samples []RetrySample
+		if err := DbGetAll(&samples); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(samples) != 1 {
+			t.Errorf("egorm: Expected %d items, got %d", 1, len(samples))
+		}
+	})
+}
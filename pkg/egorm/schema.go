@@ -0,0 +1,221 @@
+package egorm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/martenwallewein/easy-going/pkg/eslices"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// parseSchema returns the gorm schema of the given model.
+func parseSchema(model interface{}) (*schema.Schema, error) {
+	stmt := &gorm.Statement{DB: Db}
+	if err := stmt.Parse(model); err != nil {
+		return nil, err
+	}
+	return stmt.Schema, nil
+}
+
+// hasEgormTag reports whether the egorm struct tag of the field contains the given
+// option, e.g. `egorm:"version"`. Options are separated by commas.
+func hasEgormTag(field *schema.Field, option string) bool {
+	for _, tagOption := range strings.Split(field.Tag.Get("egorm"), ",") {
+		if strings.TrimSpace(tagOption) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// egormTagValue returns the value of an egorm struct tag option with a value, e.g. "Email"
+// for `egorm:"blindindex:Email"`, or an empty string if the option is not set.
+func egormTagValue(field *schema.Field, option string) string {
+	for _, tagOption := range strings.Split(field.Tag.Get("egorm"), ",") {
+		if name, value, found := strings.Cut(strings.TrimSpace(tagOption), ":"); found && name == option {
+			return value
+		}
+	}
+	return ""
+}
+
+// lookupTaggedField returns the first field of the schema that has the given egorm tag option.
+func lookupTaggedField(s *schema.Schema, option string) *schema.Field {
+	for _, field := range s.Fields {
+		if hasEgormTag(field, option) {
+			return field
+		}
+	}
+	return nil
+}
+
+// isInternalTable reports whether the table is managed by egorm itself.
+func isInternalTable(table string) bool {
+	return eslices.IndexOf(table, internalTables) >= 0
+}
+
+// primaryKeyConditions returns conditions matching the primary key of the statement's model,
+// if the model is a single struct with a non-zero primary key.
+func primaryKeyConditions(stmt *gorm.Statement) []clause.Expression {
+	conds := make([]clause.Expression, 0)
+	if stmt.Schema == nil || stmt.ReflectValue.Kind() != reflect.Struct {
+		return conds
+	}
+	for _, primaryField := range stmt.Schema.PrimaryFields {
+		if value, isZero := primaryField.ValueOf(stmt.Context, stmt.ReflectValue); !isZero {
+			conds = append(conds, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: primaryField.DBName}, Value: value})
+		}
+	}
+	return conds
+}
+
+// rowConditions returns conditions matching the primary key of a row that was loaded as map.
+func rowConditions(s *schema.Schema, row map[string]interface{}) []clause.Expression {
+	conds := make([]clause.Expression, 0, len(s.PrimaryFields))
+	for _, primaryField := range s.PrimaryFields {
+		conds = append(conds, clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: primaryField.DBName}, Value: row[primaryField.DBName]})
+	}
+	return conds
+}
+
+// keyConditions returns conditions matching the primary key id. For a single primary key, id
+// is its value, e.g. a uint, string or UUID. For composite keys, id is a Where of field or column
+// names to values, or a []interface{} with the values in the order of the primary fields.
+func keyConditions(s *schema.Schema, id interface{}) ([]clause.Expression, error) {
+	if len(s.PrimaryFields) == 0 {
+		return nil, fmt.Errorf("egorm: %s has no primary key", s.Name)
+	}
+	column := func(field *schema.Field) clause.Column {
+		return clause.Column{Table: clause.CurrentTable, Name: field.DBName}
+	}
+	conds := make([]clause.Expression, 0, len(s.PrimaryFields))
+
+	var values map[string]interface{}
+	switch key := id.(type) {
+	case Where:
+		values = key
+	case map[string]interface{}:
+		values = key
+	case []interface{}:
+		if len(key) != len(s.PrimaryFields) {
+			return nil, fmt.Errorf("egorm: %s has %d primary fields, got %d key values", s.Name, len(s.PrimaryFields), len(key))
+		}
+		for i, primaryField := range s.PrimaryFields {
+			conds = append(conds, clause.Eq{Column: column(primaryField), Value: key[i]})
+		}
+		return conds, nil
+	default:
+		if len(s.PrimaryFields) != 1 {
+			return nil, fmt.Errorf("egorm: %s has a composite primary key, pass a Where or []interface{} as key", s.Name)
+		}
+		return append(conds, clause.Eq{Column: column(s.PrimaryFields[0]), Value: id}), nil
+	}
+
+	for _, primaryField := range s.PrimaryFields {
+		value, ok := values[primaryField.Name]
+		if !ok {
+			value, ok = values[primaryField.DBName]
+		}
+		if !ok {
+			return nil, fmt.Errorf("egorm: Key of %s is missing %s", s.Name, primaryField.Name)
+		}
+		conds = append(conds, clause.Eq{Column: column(primaryField), Value: value})
+	}
+	if len(values) != len(conds) {
+		return nil, fmt.Errorf("egorm: Key of %s contains fields which are not primary", s.Name)
+	}
+	return conds, nil
+}
+
+// rowID formats the primary key of a row that was loaded as map, composite keys are joined by commas.
+func rowID(s *schema.Schema, row map[string]interface{}) string {
+	values := make([]string, 0, len(s.PrimaryFields))
+	for _, primaryField := range s.PrimaryFields {
+		values = append(values, fmt.Sprint(row[primaryField.DBName]))
+	}
+	return strings.Join(values, ",")
+}
+
+// affectedRowsKey stores the rows loaded by loadAffectedRows on the statement.
+const affectedRowsKey = "egorm:affected_rows"
+
+// loadAffectedRows loads the rows that the statement in db is going to modify as maps of
+// column names to values. It runs in the same transaction as the statement. Statements
+// without any condition are skipped and return no rows. The rows are loaded once per statement
+// and shared by the callbacks of its chain, so they must not be modified.
+func loadAffectedRows(db *gorm.DB) ([]map[string]interface{}, error) {
+	if rows, ok := db.InstanceGet(affectedRowsKey); ok {
+		return rows.([]map[string]interface{}), nil
+	}
+	rows := make([]map[string]interface{}, 0)
+	query, ok := affectedRowsQuery(db)
+	if !ok {
+		return rows, nil
+	}
+	if err := query.Table(db.Statement.Table).Find(&rows).Error; err != nil {
+		return rows, err
+	}
+	db.InstanceSet(affectedRowsKey, rows)
+	return rows, nil
+}
+
+// affectedRowsQuery returns a query for the rows that the statement in db is going to modify,
+// or false if the statement has no conditions.
+func affectedRowsQuery(db *gorm.DB) (*gorm.DB, bool) {
+	stmt := db.Statement
+	query := db.Session(&gorm.Session{NewDB: true})
+	hasConditions := false
+	if where, ok := stmt.Clauses["WHERE"]; ok {
+		if whereClause, ok := where.Expression.(clause.Where); ok && len(whereClause.Exprs) > 0 {
+			query = query.Clauses(whereClause)
+			hasConditions = true
+		}
+	}
+	if conds := primaryKeyConditions(stmt); len(conds) > 0 {
+		query = query.Clauses(clause.Where{Exprs: conds})
+		hasConditions = true
+	}
+	return query, hasConditions
+}
+
+// loadRow loads a single row by the primary key of the given row.
+func loadRow(db *gorm.DB, s *schema.Schema, table string, row map[string]interface{}) (map[string]interface{}, error) {
+	loaded := make(map[string]interface{})
+	err := db.Session(&gorm.Session{NewDB: true}).
+		Table(table).
+		Clauses(clause.Where{Exprs: rowConditions(s, row)}).
+		Take(&loaded).Error
+	return loaded, err
+}
+
+// loadCreatedRows loads the rows of the models that were just created by the statement in db.
+func loadCreatedRows(db *gorm.DB) ([]map[string]interface{}, error) {
+	stmt := db.Statement
+	rows := make([]map[string]interface{}, 0)
+
+	models := make([]reflect.Value, 0)
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Struct:
+		models = append(models, stmt.ReflectValue)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			models = append(models, reflect.Indirect(stmt.ReflectValue.Index(i)))
+		}
+	}
+
+	for _, model := range models {
+		key := make(map[string]interface{})
+		for _, primaryField := range stmt.Schema.PrimaryFields {
+			key[primaryField.DBName], _ = primaryField.ValueOf(stmt.Context, model)
+		}
+		row, err := loadRow(db, stmt.Schema, stmt.Table, key)
+		if err != nil {
+			return nil, err
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
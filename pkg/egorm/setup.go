@@ -1,10 +1,14 @@
 package egorm
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/glebarez/sqlite"
 	"gorm.io/driver/postgres"
@@ -14,6 +18,13 @@ import (
 var Db *gorm.DB
 var initOnce sync.Once
 var initOnceErr error
+
+// dbLock guards connecting, closing and restoring the database, InitDB holds it shared.
+var dbLock sync.RWMutex
+var restoring atomic.Bool
+
+// ErrRestoring is returned by egorm calls while Restore swaps the database.
+var ErrRestoring = errors.New("egorm: database is being restored")
 var sqliteOps *SQLiteConnectOpts
 var postgresOps *PostgresConnectOpts
 
@@ -23,6 +34,12 @@ type PostgresConnectOpts struct {
 	Database string
 	User     string
 	Password string
+	// Replicas are read replicas as host or host:port, using the same credentials as the primary
+	Replicas []string
+	// ReplicaPolicy selects the replica for each read, defaults to RoundRobin
+	ReplicaPolicy ReplicaPolicy
+	// QueryLog enables structured query logging, defaults to the EGORM_LOG_* env variables
+	QueryLog *QueryLogOpts
 }
 
 func SetPostgresConnectOpts(ops *PostgresConnectOpts) {
@@ -37,6 +54,7 @@ func setupPostgres() (*gorm.DB, error) {
 		dbName := os.Getenv("EGORM_POSTGRES_DB_DATABASE")
 		dbUser := os.Getenv("EGORM_POSTGRES_DB_USER")
 		dbPassword := os.Getenv("EGORM_POSTGRES_DB_PASSWORD")
+		dbReplicas := os.Getenv("EGORM_POSTGRES_DB_REPLICAS")
 		port, _ := strconv.Atoi(dbPort)
 		postgresOps = &PostgresConnectOpts{
 			Host:     dbHost,
@@ -44,25 +62,59 @@ func setupPostgres() (*gorm.DB, error) {
 			Database: dbName,
 			User:     dbUser,
 			Password: dbPassword,
+			Replicas: splitList(dbReplicas),
 		}
 	}
 
+	return openPostgres(postgresOps.Host, postgresOps.Port)
+}
+
+func openPostgres(host string, port int) (*gorm.DB, error) {
 	connectionString := fmt.Sprintf("host=%s port=%d user=%s dbname=%s password=%s sslmode=disable",
-		postgresOps.Host,
-		postgresOps.Port,
+		host,
+		port,
 		postgresOps.User,
 		postgresOps.Database,
 		postgresOps.Password)
 
-	db, err := gorm.Open(postgres.Open(connectionString))
+	config, err := gormConfig(postgresOps.QueryLog)
 	if err != nil {
 		return nil, err
 	}
+	db, err := gorm.Open(postgres.Open(connectionString), config)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to connect to postgres %s:%d: %w", host, port, connectionError(err))
+	}
 	return db, nil
 }
 
+func setupPostgresReplicas() ([]*gorm.DB, error) {
+	replicas := make([]*gorm.DB, 0, len(postgresOps.Replicas))
+	for _, endpoint := range postgresOps.Replicas {
+		host, port := endpoint, postgresOps.Port
+		if h, p, err := net.SplitHostPort(endpoint); err == nil {
+			host = h
+			if port, err = strconv.Atoi(p); err != nil {
+				return nil, fmt.Errorf("egorm: Invalid port in postgres replica %s: %w", endpoint, err)
+			}
+		}
+		replica, err := openPostgres(host, port)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, nil
+}
+
 type SQLiteConnectOpts struct {
 	Path string
+	// Replicas are paths of sqlite files that serve reads, mainly to test replica routing
+	Replicas []string
+	// ReplicaPolicy selects the replica for each read, defaults to RoundRobin
+	ReplicaPolicy ReplicaPolicy
+	// QueryLog enables structured query logging, defaults to the EGORM_LOG_* env variables
+	QueryLog *QueryLogOpts
 }
 
 func SetSQLiteConnectOpts(ops *SQLiteConnectOpts) {
@@ -70,30 +122,79 @@ func SetSQLiteConnectOpts(ops *SQLiteConnectOpts) {
 }
 
 func setupSQLite() (*gorm.DB, error) {
-	var dbLocation string
+	dbLocation, err := sqlitePath()
+	if err != nil {
+		return nil, err
+	}
+
+	return openSQLite(dbLocation)
+}
+
+// sqlitePath returns the path of the primary sqlite file from the connect options or env.
+func sqlitePath() (string, error) {
 	if sqliteOps == nil || sqliteOps.Path == "" {
-		dbLocation = os.Getenv("EGORM_DB_SQLITE_PATH")
+		dbLocation := os.Getenv("EGORM_DB_SQLITE_PATH")
 		if dbLocation == "" {
-			return nil, fmt.Errorf("egorm: sqliteOpts.Path not specified and DB_SQLITE_PATH env is empty")
+			return "", fmt.Errorf("egorm: sqliteOpts.Path not specified and DB_SQLITE_PATH env is empty")
 		}
-	} else {
-		dbLocation = sqliteOps.Path
+		return dbLocation, nil
 	}
+	return sqliteOps.Path, nil
+}
 
+func openSQLite(dbLocation string) (*gorm.DB, error) {
 	// Create the sqlite file if it's not available
 	if _, err := os.Stat(dbLocation); err != nil {
 		if _, err = os.Create(dbLocation); err != nil {
-			return nil, fmt.Errorf("egorm: Failed to create sqlite file %s: %s", dbLocation, err)
+			return nil, fmt.Errorf("egorm: Failed to create sqlite file %s: %w", dbLocation, err)
 		}
 	}
 
-	db, err := gorm.Open(sqlite.Open(dbLocation), &gorm.Config{})
+	var queryLog *QueryLogOpts
+	if sqliteOps != nil {
+		queryLog = sqliteOps.QueryLog
+	}
+	config, err := gormConfig(queryLog)
 	if err != nil {
-		return nil, fmt.Errorf("egorm: Failed connect to sqlite file %s: %s", dbLocation, err)
+		return nil, err
+	}
+	db, err := gorm.Open(sqlite.Open(dbLocation), config)
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed connect to sqlite file %s: %w", dbLocation, connectionError(err))
 	}
 	return db, nil
 }
 
+func setupSQLiteReplicas() ([]*gorm.DB, error) {
+	var paths []string
+	if sqliteOps == nil || sqliteOps.Path == "" {
+		paths = splitList(os.Getenv("EGORM_DB_SQLITE_REPLICAS"))
+	} else {
+		paths = sqliteOps.Replicas
+	}
+
+	replicas := make([]*gorm.DB, 0, len(paths))
+	for _, path := range paths {
+		replica, err := openSQLite(path)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, replica)
+	}
+	return replicas, nil
+}
+
+// splitList splits a comma separated list, e.g. from an env variable, and drops empty entries.
+func splitList(list string) []string {
+	entries := make([]string, 0)
+	for _, entry := range strings.Split(list, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
 /*func autoMigrate(db *gorm.DB) error {
 	return db.AutoMigrate(&DbTarget{})
 }*/
@@ -113,12 +214,24 @@ func initializeDatabaseLayer() {
 
 	// var err error
 
+	var replicas []*gorm.DB
+
 	switch dbs {
 	case "sqlite":
 		db, initOnceErr = setupSQLite()
+		if initOnceErr == nil {
+			replicas, initOnceErr = setupSQLiteReplicas()
+		}
+		if sqliteOps != nil {
+			replicaPolicy = sqliteOps.ReplicaPolicy
+		}
 		break
 	case "postgres":
 		db, initOnceErr = setupPostgres()
+		if initOnceErr == nil {
+			replicas, initOnceErr = setupPostgresReplicas()
+		}
+		replicaPolicy = postgresOps.ReplicaPolicy
 		break
 	default:
 		initOnceErr = fmt.Errorf("No database found, set the DB env")
@@ -133,11 +246,60 @@ func initializeDatabaseLayer() {
 	if initOnceErr != nil {
 		return
 	}*/
+	for _, instance := range append([]*gorm.DB{db}, replicas...) {
+		initOnceErr = registerCallbacks(instance)
+		if initOnceErr != nil {
+			return
+		}
+	}
 	Db = db
+	replicaDbs = replicas
 
+	// The audit log may have been enabled before a reconnect
+	if auditEnabled {
+		initOnceErr = autoMigrate(&AuditEntry{})
+	}
 }
 
 func InitDB() error {
+	if restoring.Load() {
+		return ErrRestoring
+	}
+	dbLock.RLock()
+	defer dbLock.RUnlock()
 	initOnce.Do(initializeDatabaseLayer)
 	return initOnceErr
 }
+
+// CloseDB closes the connections to the database and its replicas. The next call to
+// any egorm function connects again, using the connect options set at that time.
+func CloseDB() error {
+	dbLock.Lock()
+	defer dbLock.Unlock()
+	return closeDB()
+}
+
+// closeDB closes the connections, dbLock has to be held. Db keeps the closed connections
+// until the next InitDB, so calls racing the close fail with an error instead of panicking.
+func closeDB() error {
+	instances := append([]*gorm.DB{Db}, replicaDbs...)
+	initOnce = sync.Once{}
+	initOnceErr = nil
+	resetMigrated()
+	// The next database may have other rows, e.g. after a restore
+	invalidateAllCaches()
+
+	for _, instance := range instances {
+		if instance == nil {
+			continue
+		}
+		sqlDB, err := instance.DB()
+		if err != nil {
+			return err
+		}
+		if err := sqlDB.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
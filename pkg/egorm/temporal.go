@@ -0,0 +1,319 @@
+package egorm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// historyTableSuffix is appended to the table of a model to name its history table.
+const historyTableSuffix = "_history"
+
+var (
+	historyLock   sync.RWMutex
+	historyTables = make(map[string]*schema.Schema)
+)
+
+// Version is a version of an entity and the time it was valid, see Versions.
+type Version[T any] struct {
+	Entity    T
+	ValidFrom time.Time
+	// ValidTo is the zero time for the current version
+	ValidTo time.Time
+}
+
+// EnableHistory turns on system versioning for T: every update or delete copies the prior rows
+// into the table <table>_history, which is created with the columns of T and valid_from,
+// valid_to. Versions are recorded by egorm's callbacks, so raw SQL statements are not versioned.
+// The first version of an entity is valid from its CreatedAt, or from the beginning of time if
+// T has no CreatedAt field.
+func EnableHistory[T any]() error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	var tmp T
+	if err := autoMigrate(&tmp); err != nil {
+		return err
+	}
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return fmt.Errorf("egorm: Failed to enable history for %T: %w", tmp, err)
+	}
+	if len(s.PrimaryFields) == 0 {
+		return fmt.Errorf("egorm: History of %s needs a primary key", s.Name)
+	}
+
+	table := s.Table + historyTableSuffix
+	if err := Db.Table(table).AutoMigrate(historyModel(s, table)); err != nil {
+		return fmt.Errorf("egorm: Failed to create the history table %s: %w", table, translateError(err))
+	}
+
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	historyTables[s.Table] = s
+	return nil
+}
+
+// DisableHistory stops recording versions of T, its history table is kept.
+func DisableHistory[T any]() error {
+	if err := InitDB(); err != nil {
+		return err
+	}
+	s, err := parseSchema((*T)(nil))
+	if err != nil {
+		return err
+	}
+	historyLock.Lock()
+	defer historyLock.Unlock()
+	delete(historyTables, s.Table)
+	return nil
+}
+
+// historyModel returns a new struct for migrating the history table of s. It has the columns
+// of s with their database types, but without their constraints and defaults.
+func historyModel(s *schema.Schema, table string) interface{} {
+	keyIndex := fmt.Sprintf("index:idx_%s_key", table)
+	fields := []reflect.StructField{
+		{Name: "HistoryID", Type: reflect.TypeOf(uint64(0)), Tag: `gorm:"column:history_id;primaryKey;autoIncrement"`},
+		{Name: "ValidFrom", Type: reflect.TypeOf(time.Time{}), Tag: `gorm:"column:valid_from"`},
+		{Name: "ValidTo", Type: reflect.TypeOf(time.Time{}), Tag: reflect.StructTag(fmt.Sprintf(`gorm:"column:valid_to;%s,priority:20"`, keyIndex))},
+	}
+	for i, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		plain := *field
+		plain.PrimaryKey = false
+		plain.AutoIncrement = false
+		tag := fmt.Sprintf("column:%s;type:%s", field.DBName, Db.Dialector.DataTypeOf(&plain))
+		if field.PrimaryKey {
+			tag += ";" + keyIndex + ",priority:10"
+		}
+		fields = append(fields, reflect.StructField{
+			Name: fmt.Sprintf("Column%d", i),
+			Type: historyColumnType(field.DataType),
+			Tag:  reflect.StructTag(fmt.Sprintf(`gorm:"%s"`, tag)),
+		})
+	}
+	return reflect.New(reflect.StructOf(fields)).Interface()
+}
+
+// historyColumnType returns a plain Go type for a column, the column type itself is set by tag.
+func historyColumnType(dataType schema.DataType) reflect.Type {
+	switch dataType {
+	case schema.Bool:
+		return reflect.TypeOf(false)
+	case schema.Int:
+		return reflect.TypeOf(int64(0))
+	case schema.Uint:
+		return reflect.TypeOf(uint64(0))
+	case schema.Float:
+		return reflect.TypeOf(float64(0))
+	case schema.Time:
+		return reflect.TypeOf(time.Time{})
+	case schema.Bytes:
+		return reflect.TypeOf([]byte(nil))
+	default:
+		return reflect.TypeOf("")
+	}
+}
+
+// historySchema returns the schema of the model with the given table, if its history is enabled.
+func historySchema(table string) (*schema.Schema, bool) {
+	historyLock.RLock()
+	defer historyLock.RUnlock()
+	s, ok := historyTables[table]
+	return s, ok
+}
+
+// historyBeforeWrite copies the rows that are going to be updated or deleted into the history
+// table, in the same transaction as the statement.
+func historyBeforeWrite(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil || db.DryRun {
+		return
+	}
+	s, ok := historySchema(db.Statement.Table)
+	if !ok {
+		return
+	}
+	rows, err := loadAffectedRows(db)
+	if err != nil {
+		db.AddError(fmt.Errorf("egorm: Failed to load rows for history: %w", err))
+		return
+	}
+
+	table := s.Table + historyTableSuffix
+	now := time.Now()
+	for _, affected := range rows {
+		validFrom, err := currentValidFrom(db.Session(&gorm.Session{NewDB: true}), s, affected)
+		if err != nil {
+			db.AddError(fmt.Errorf("egorm: Failed to load the history of %s %s: %w", s.Name, rowID(s, affected), err))
+			return
+		}
+		// The affected rows are shared with the audit log, so the version is a copy
+		row := make(map[string]interface{}, len(affected)+2)
+		for column, value := range affected {
+			row[column] = value
+		}
+		row["valid_from"] = validFrom
+		row["valid_to"] = now
+		if err := db.Session(&gorm.Session{NewDB: true}).Table(table).Create(row).Error; err != nil {
+			db.AddError(fmt.Errorf("egorm: Failed to write the history of %s %s: %w", s.Name, rowID(s, row), err))
+			return
+		}
+	}
+}
+
+// currentValidFrom returns the time the current version of row is valid from: the end of its
+// last recorded version, its creation or the zero time.
+func currentValidFrom(db *gorm.DB, s *schema.Schema, row map[string]interface{}) (time.Time, error) {
+	var last []time.Time
+	err := db.Table(s.Table+historyTableSuffix).
+		Clauses(clause.Where{Exprs: rowConditions(s, row)}).
+		Order("valid_to DESC").Limit(1).
+		Pluck("valid_to", &last).Error
+	if err != nil {
+		return time.Time{}, err
+	}
+	if len(last) > 0 {
+		return last[0], nil
+	}
+	if field := s.LookUpField("CreatedAt"); field != nil && field.DBName != "" {
+		if created, ok := row[field.DBName].(time.Time); ok {
+			return created, nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// AsOf returns the entities of type T matching where as they were at the given time. Entities
+// which did not exist or were deleted at that time are not returned.
+func AsOf[T any](at time.Time, where Where) ([]T, error) {
+	return AsOfContext[T](context.Background(), at, where)
+}
+
+func AsOfContext[T any](ctx context.Context, at time.Time, where Where) ([]T, error) {
+	s, err := prepareHistory[T]()
+	if err != nil {
+		return nil, err
+	}
+	table := s.Table + historyTableSuffix
+
+	versions := make([]T, 0)
+	err = withRetry(ctx, "as_of", func() error {
+		versions = versions[:0]
+		if err := readDB(ctx).Model(new(T)).Table(table).Unscoped().Scopes(whereScope(where)).
+			Where("valid_from <= ? AND valid_to > ?", at, at).
+			Order("valid_from").Find(&versions).Error; err != nil {
+			return err
+		}
+
+		// Current rows are valid unless a recorded version ends after at
+		stmt := Db.Statement
+		keys := make([]string, 0, len(s.PrimaryFields))
+		for _, primaryField := range s.PrimaryFields {
+			keys = append(keys, fmt.Sprintf("h.%s = %s.%s", stmt.Quote(primaryField.DBName), stmt.Quote(s.Table), stmt.Quote(primaryField.DBName)))
+		}
+		current := readDB(ctx).Model(new(T)).Scopes(whereScope(where)).
+			Where(fmt.Sprintf("NOT EXISTS (SELECT 1 FROM %s h WHERE %s AND h.valid_to > ?)", stmt.Quote(table), strings.Join(keys, " AND ")), at)
+		if field := s.LookUpField("CreatedAt"); field != nil && field.DBName != "" {
+			current = current.Where(clause.Lte{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: at})
+		}
+		rows := make([]T, 0)
+		if err := current.Find(&rows).Error; err != nil {
+			return err
+		}
+		versions = append(versions, rows...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to load %s as of %s: %w", s.Name, at.Format(time.RFC3339), err)
+	}
+	return versions, nil
+}
+
+// Versions returns all versions of the entity of type T with the given primary key, ordered
+// from oldest to newest. The last version is the current one, unless the entity was deleted.
+// See DbFind for the format of id.
+func Versions[T any](id interface{}) ([]Version[T], error) {
+	return VersionsContext[T](context.Background(), id)
+}
+
+func VersionsContext[T any](ctx context.Context, id interface{}) ([]Version[T], error) {
+	s, err := prepareHistory[T]()
+	if err != nil {
+		return nil, err
+	}
+	conds, err := keyConditions(s, id)
+	if err != nil {
+		return nil, err
+	}
+
+	versions := make([]Version[T], 0)
+	err = withRetry(ctx, "versions", func() error {
+		versions = versions[:0]
+		query := readDB(ctx).Table(s.Table + historyTableSuffix).Unscoped().Where(clause.And(conds...)).Order("valid_to, history_id")
+		entities := make([]T, 0)
+		if err := query.Session(&gorm.Session{}).Model(new(T)).Find(&entities).Error; err != nil {
+			return err
+		}
+		// Loaded separately, since T has no fields for them
+		validity := make([]struct {
+			ValidFrom time.Time
+			ValidTo   time.Time
+		}, 0, len(entities))
+		if err := query.Session(&gorm.Session{}).Select("valid_from, valid_to").Find(&validity).Error; err != nil {
+			return err
+		}
+		if len(validity) != len(entities) {
+			return fmt.Errorf("egorm: History of %s changed while it was loaded", s.Name)
+		}
+		for i := range entities {
+			versions = append(versions, Version[T]{Entity: entities[i], ValidFrom: validity[i].ValidFrom, ValidTo: validity[i].ValidTo})
+		}
+
+		entities = entities[:0]
+		if err := readDB(ctx).Where(clause.And(conds...)).Limit(1).Find(&entities).Error; err != nil || len(entities) == 0 {
+			return err
+		}
+		entity := reflect.ValueOf(&entities[0]).Elem()
+		row := make(map[string]interface{})
+		for _, field := range s.Fields {
+			if field.DBName != "" {
+				row[field.DBName], _ = field.ValueOf(ctx, entity)
+			}
+		}
+		validFrom, err := currentValidFrom(readDB(ctx), s, row)
+		if err != nil {
+			return err
+		}
+		versions = append(versions, Version[T]{Entity: entities[0], ValidFrom: validFrom})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("egorm: Failed to load the versions of %s %v: %w", s.Name, id, err)
+	}
+	return versions, nil
+}
+
+// prepareHistory returns the schema of T, whose history has to be enabled.
+func prepareHistory[T any]() (*schema.Schema, error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	s, err := parseSchema((*T)(nil))
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := historySchema(s.Table); !ok {
+		return nil, fmt.Errorf("egorm: History of %s is not enabled, see EnableHistory", s.Name)
+	}
+	return s, nil
+}
@@ -0,0 +1,197 @@
+package egorm
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type VersionedPrice struct {
+	gorm.Model
+	Product string
+	Cents   int
+}
+
+type AuditedPrice struct {
+	gorm.Model
+	Cents int
+}
+
+type UnversionedPrice struct {
+	gorm.Model
+	Cents int
+}
+
+func TestHistory(t *testing.T) {
+	fakeSetup()
+	if err := EnableHistory[VersionedPrice](); err != nil {
+		t.Error(err)
+		return
+	}
+	defer DisableHistory[VersionedPrice]()
+
+	t.Run("TestHistoryNotEnabled", func(t *testing.T) {
+		if _, err := Versions[UnversionedPrice](1); err == nil {
+			t.Error("egorm: Expected an error for a model without history")
+		}
+	})
+
+	price := VersionedPrice{Product: "tea", Cents: 100}
+	if err := DbCreate(&price); err != nil {
+		t.Error(err)
+		return
+	}
+	other := VersionedPrice{Product: "coffee", Cents: 300}
+	if err := DbCreate(&other); err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	first := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	if _, err := DbUpdate[VersionedPrice](price.ID, map[string]interface{}{"cents": 120}); err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	second := time.Now()
+	time.Sleep(10 * time.Millisecond)
+
+	price.Cents = 150
+	if err := DbSave(&price); err != nil {
+		t.Error(err)
+		return
+	}
+	if err := DbDelete(&other); err != nil {
+		t.Error(err)
+		return
+	}
+	time.Sleep(10 * time.Millisecond)
+	third := time.Now()
+
+	t.Run("TestVersions", func(t *testing.T) {
+		versions, err := Versions[VersionedPrice](price.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(versions) != 3 {
+			t.Errorf("egorm: Expected %d versions, got %d", 3, len(versions))
+			return
+		}
+		for i, cents := range []int{100, 120, 150} {
+			if versions[i].Entity.Cents != cents {
+				t.Errorf("egorm: Expected version %d to cost %d, got %d", i, cents, versions[i].Entity.Cents)
+			}
+		}
+		if !versions[0].ValidFrom.Equal(versions[0].Entity.CreatedAt) || !versions[1].ValidFrom.Equal(versions[0].ValidTo) ||
+			!versions[2].ValidFrom.Equal(versions[1].ValidTo) || !versions[2].ValidTo.IsZero() {
+			t.Errorf("egorm: Expected consecutive versions, got %+v", versions)
+		}
+	})
+
+	t.Run("TestAsOf", func(t *testing.T) {
+		for _, tc := range []struct {
+			at       time.Time
+			expected map[string]int
+		}{
+			{price.CreatedAt.Add(-time.Second), map[string]int{}},
+			{first, map[string]int{"tea": 100, "coffee": 300}},
+			{second, map[string]int{"tea": 120, "coffee": 300}},
+			{third, map[string]int{"tea": 150}},
+		} {
+			prices, err := AsOf[VersionedPrice](tc.at, nil)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if len(prices) != len(tc.expected) {
+				t.Errorf("egorm: Expected %d prices as of %s, got %+v", len(tc.expected), tc.at, prices)
+				continue
+			}
+			for _, p := range prices {
+				if tc.expected[p.Product] != p.Cents {
+					t.Errorf("egorm: Expected %s to cost %d as of %s, got %d", p.Product, tc.expected[p.Product], tc.at, p.Cents)
+				}
+			}
+		}
+
+		prices, err := AsOf[VersionedPrice](first, Where{"product": "tea"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(prices) != 1 || prices[0].Cents != 100 {
+			t.Errorf("egorm: Expected the first price of tea, got %+v", prices)
+		}
+	})
+
+	t.Run("TestDeletedVersions", func(t *testing.T) {
+		versions, err := Versions[VersionedPrice](other.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(versions) != 1 || versions[0].Entity.Cents != 300 || versions[0].ValidTo.IsZero() {
+			t.Errorf("egorm: Expected the deleted version only, got %+v", versions)
+		}
+	})
+}
+
+func TestHistoryWithAudit(t *testing.T) {
+	t.Run("TestHistoryWithAudit", func(t *testing.T) {
+		fakeSetup()
+		if err := EnableHistory[AuditedPrice](); err != nil {
+			t.Error(err)
+			return
+		}
+		defer DisableHistory[AuditedPrice]()
+		if err := EnableAudit(&AuditedPrice{}); err != nil {
+			t.Error(err)
+			return
+		}
+		defer DisableAudit()
+		price := AuditedPrice{Cents: 100}
+		if err := DbCreate(&price); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var buf bytes.Buffer
+		previous := Db.Config.Logger
+		Db.Config.Logger = NewQueryLogger(QueryLogOpts{Logger: slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), Level: slog.LevelDebug})
+		_, err := DbUpdate[AuditedPrice](price.ID, map[string]interface{}{"cents": 120})
+		Db.Config.Logger = previous
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		// The audit log and the history share the rows loaded before the update, the audit log
+		// loads the updated row afterwards
+		if selects := strings.Count(buf.String(), "SELECT * FROM `audited_prices` WHERE"); selects != 2 {
+			t.Errorf("egorm: Expected %d selects of the price, got %d: %s", 2, selects, buf.String())
+		}
+
+		versions, err := Versions[AuditedPrice](price.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(versions) != 2 || versions[0].Entity.Cents != 100 || versions[1].Entity.Cents != 120 {
+			t.Errorf("egorm: Expected both versions of the price, got %+v", versions)
+		}
+		entries, err := History[AuditedPrice](price.ID)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(entries) != 2 || strings.Contains(entries[1].Changes, "valid_") {
+			t.Errorf("egorm: Expected the create and the update without history columns, got %+v", entries)
+		}
+	})
+}
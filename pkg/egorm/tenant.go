@@ -0,0 +1,188 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var (
+	// ErrMissingTenant is returned when a tenant scoped model is accessed without a tenant in the context.
+	ErrMissingTenant = errors.New("egorm: no tenant in context")
+	// ErrCrossTenant is returned when a model of another tenant is written.
+	ErrCrossTenant = errors.New("egorm: cross tenant access")
+)
+
+const AuditOperationTenantBypass = "tenant_bypass"
+
+var tenancyEnabled bool
+
+// EnableTenancy scopes all models with a tenant field to the tenant of the context, see WithTenant.
+// The tenant field is either tagged with `egorm:"tenant"` or named TenantID. Reads, updates and
+// deletes are filtered by the tenant and creates get the tenant assigned. Models without a tenant
+// field are not affected, neither are raw SQL statements.
+func EnableTenancy() {
+	tenancyEnabled = true
+}
+
+// DisableTenancy turns off tenant scoping.
+func DisableTenancy() {
+	tenancyEnabled = false
+}
+
+type tenantBypass struct {
+	reason string
+}
+
+// WithTenant returns a context whose statements are scoped to the given tenant.
+func WithTenant(ctx context.Context, tenantID interface{}) context.Context {
+	return context.WithValue(ctx, tenantContextKey, tenantID)
+}
+
+// TenantFromContext returns the tenant of the context or nil.
+func TenantFromContext(ctx context.Context) interface{} {
+	if ctx == nil {
+		return nil
+	}
+	return ctx.Value(tenantContextKey)
+}
+
+// WithoutTenant returns a context whose statements are not scoped to any tenant, e.g. for
+// maintenance jobs. Every statement issued with it is logged together with the actor and the
+// reason, writes are also recorded in the audit log if it is enabled.
+func WithoutTenant(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, tenantBypassContextKey, tenantBypass{reason: reason})
+}
+
+// lookupTenantField returns the tenant field of the schema or nil if the model is not tenant scoped.
+func lookupTenantField(s *schema.Schema) *schema.Field {
+	if field := lookupTaggedField(s, "tenant"); field != nil {
+		return field
+	}
+	return s.LookUpField("TenantID")
+}
+
+// tenantScope returns the tenant field of the statement's model and the tenant of its context.
+// The field is nil if the statement is not subject to tenant scoping. write is set for creates,
+// updates and deletes.
+func tenantScope(db *gorm.DB, write bool) (*schema.Field, interface{}) {
+	stmt := db.Statement
+	if !tenancyEnabled || stmt.Schema == nil || isInternalTable(stmt.Table) {
+		return nil, nil
+	}
+	field := lookupTenantField(stmt.Schema)
+	if field == nil {
+		return nil, nil
+	}
+
+	if bypass, ok := stmt.Context.Value(tenantBypassContextKey).(tenantBypass); ok {
+		recordTenantBypass(db, bypass, write)
+		return nil, nil
+	}
+
+	tenant := TenantFromContext(stmt.Context)
+	if tenant == nil {
+		db.AddError(fmt.Errorf("%w for %s", ErrMissingTenant, stmt.Schema.Name))
+		return nil, nil
+	}
+	return field, tenant
+}
+
+// recordTenantBypass logs a statement without tenant scope and records writes in the audit log.
+// Reads are not recorded, they may run on a read only replica and would add a row per query.
+// Writes run on the primary, the entry is written in their transaction.
+func recordTenantBypass(db *gorm.DB, bypass tenantBypass, write bool) {
+	actor := ActorFromContext(db.Statement.Context)
+	eventLog().Info("egorm: Tenant scope bypassed", "model", db.Statement.Schema.Name, "actor", actor, "reason", bypass.reason)
+	if !auditEnabled || !write {
+		return
+	}
+	writeAuditEntries(db, []AuditEntry{{
+		EntityType: db.Statement.Schema.Name,
+		Operation:  AuditOperationTenantBypass,
+		Actor:      actor,
+		Timestamp:  db.NowFunc(),
+		Changes:    fmt.Sprintf("%q", bypass.reason),
+	}})
+}
+
+func addTenantCondition(stmt *gorm.Statement, field *schema.Field, tenant interface{}) {
+	stmt.AddClause(clause.Where{Exprs: []clause.Expression{
+		clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: tenant},
+	}})
+}
+
+// checkTenant fails with ErrCrossTenant if the model has a different tenant than the context.
+// Models without a tenant get the tenant of the context assigned.
+func checkTenant(db *gorm.DB, field *schema.Field, tenant interface{}, model reflect.Value) {
+	value, isZero := field.ValueOf(db.Statement.Context, model)
+	if isZero {
+		if err := field.Set(db.Statement.Context, model, tenant); err != nil {
+			db.AddError(err)
+		}
+		return
+	}
+	if fmt.Sprint(value) != fmt.Sprint(tenant) {
+		db.AddError(fmt.Errorf("%w: %s belongs to tenant %v", ErrCrossTenant, db.Statement.Schema.Name, value))
+	}
+}
+
+func tenantBeforeCreate(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	field, tenant := tenantScope(db, true)
+	if field == nil {
+		return
+	}
+
+	switch db.Statement.ReflectValue.Kind() {
+	case reflect.Struct:
+		checkTenant(db, field, tenant, db.Statement.ReflectValue)
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < db.Statement.ReflectValue.Len(); i++ {
+			checkTenant(db, field, tenant, reflect.Indirect(db.Statement.ReflectValue.Index(i)))
+		}
+	}
+}
+
+func tenantBeforeQuery(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	field, tenant := tenantScope(db, false)
+	if field == nil {
+		return
+	}
+	addTenantCondition(db.Statement, field, tenant)
+}
+
+func tenantBeforeWrite(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	field, tenant := tenantScope(db, true)
+	if field == nil {
+		return
+	}
+
+	if db.Statement.ReflectValue.Kind() == reflect.Struct {
+		checkTenant(db, field, tenant, db.Statement.ReflectValue)
+		if db.Error != nil {
+			return
+		}
+	}
+
+	// Statements without any condition are left to gorm, which rejects them unless global
+	// updates are allowed. Otherwise the tenant condition would turn them into valid ones.
+	_, hasWhere := db.Statement.Clauses["WHERE"]
+	if !hasWhere && len(primaryKeyConditions(db.Statement)) == 0 && !db.AllowGlobalUpdate {
+		return
+	}
+	addTenantCondition(db.Statement, field, tenant)
+}
@@ -0,0 +1,110 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type TenantSample struct {
+	gorm.Model
+	TenantID string
+	Name     string
+}
+
+func TestTenancy(t *testing.T) {
+	t.Run("TestTenancy", func(t *testing.T) {
+		fakeSetup()
+		EnableTenancy()
+		defer DisableTenancy()
+
+		acme := WithTenant(context.Background(), "acme")
+		globex := WithTenant(context.Background(), "globex")
+
+		acmeSample := TenantSample{Name: "Shared"}
+		if err := DbCreateContext(acme, &acmeSample); err != nil {
+			t.Error(err)
+			return
+		}
+		if acmeSample.TenantID != "acme" {
+			t.Errorf("egorm: Expected tenant %s, got %s", "acme", acmeSample.TenantID)
+		}
+		if err := DbCreateContext(globex, &TenantSample{Name: "Shared"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var samples []TenantSample
+		if err := DbGetContext(acme, &samples, Where{"name": "Shared"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(samples) != 1 || samples[0].TenantID != "acme" {
+			t.Errorf("egorm: Expected only the sample of acme, got %v", samples)
+		}
+
+		var found TenantSample
+		if err := DbFindContext(globex, &found, int(acmeSample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.ID != 0 {
+			t.Error("egorm: Expected the sample of acme to be invisible to globex")
+		}
+
+		acmeSample.Name = "Stolen"
+		if err := DbSaveContext(globex, &acmeSample); !errors.Is(err, ErrCrossTenant) {
+			t.Errorf("egorm: Expected ErrCrossTenant, got %v", err)
+		}
+
+		if err := DbGetAll(&samples); !errors.Is(err, ErrMissingTenant) {
+			t.Errorf("egorm: Expected ErrMissingTenant, got %v", err)
+		}
+
+		if err := DbGetAllContext(WithoutTenant(context.Background(), "test"), &samples); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(samples) != 2 {
+			t.Errorf("egorm: Expected %d items, got %d", 2, len(samples))
+		}
+	})
+
+	t.Run("TestTenantBypassAudit", func(t *testing.T) {
+		fakeSetup()
+		EnableTenancy()
+		defer DisableTenancy()
+		if err := EnableAudit(&TenantSample{}); err != nil {
+			t.Error(err)
+			return
+		}
+		defer DisableAudit()
+		maintenance := WithoutTenant(context.Background(), "maintenance")
+		countBypasses := func() int64 {
+			var count int64
+			if err := Db.Model(&AuditEntry{}).Where("operation = ? AND changes = ?", AuditOperationTenantBypass, `"maintenance"`).Count(&count).Error; err != nil {
+				t.Error(err)
+			}
+			return count
+		}
+
+		// Reads may run on replicas, so only writes are recorded
+		samples := make([]TenantSample, 0)
+		if err := DbGetAllContext(maintenance, &samples); err != nil {
+			t.Error(err)
+			return
+		}
+		if count := countBypasses(); count != 0 {
+			t.Errorf("egorm: Expected no audit entry for a read, got %d", count)
+		}
+		if err := DbCreateContext(maintenance, &TenantSample{TenantID: "acme", Name: "Maintained"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if count := countBypasses(); count != 1 {
+			t.Errorf("egorm: Expected %d audit entry for a write, got %d", 1, count)
+		}
+	})
+}
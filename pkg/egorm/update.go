@@ -0,0 +1,287 @@
+package egorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+type updateOptions struct {
+	fields []string
+}
+
+// UpdateOption configures DbUpdate and DbUpdateWhere.
+type UpdateOption func(*updateOptions)
+
+// Fields restricts an update to the given fields, by field or column name. Selected fields
+// are written even if they have a zero value in the changes.
+func Fields(fields ...string) UpdateOption {
+	return func(o *updateOptions) {
+		o.fields = append(o.fields, fields...)
+	}
+}
+
+// DbUpdate updates the entity of type T with the primary key id, see DbFind for the forms of
+// id. Changes are either a map of field or column names to values, or a struct of type T whose
+// non-zero fields are written. Use Fields to select the fields to write. Returns the number of
+// updated rows.
+func DbUpdate[T any](id interface{}, changes interface{}, opts ...UpdateOption) (int64, error) {
+	return DbUpdateContext[T](context.Background(), id, changes, opts...)
+}
+
+func DbUpdateContext[T any](ctx context.Context, id interface{}, changes interface{}, opts ...UpdateOption) (int64, error) {
+	if err := InitDB(); err != nil {
+		return 0, err
+	}
+	var tmp T
+	if err := autoMigrate(&tmp); err != nil {
+		return 0, err
+	}
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return 0, err
+	}
+	conds, err := keyConditions(s, id)
+	if err != nil {
+		return 0, err
+	}
+
+	condition := func(db *gorm.DB) *gorm.DB {
+		return db.Where(clause.And(conds...))
+	}
+	return dbUpdate(ctx, &tmp, s, condition, changes, opts)
+}
+
+// DbUpdateWhere updates all entities of type T matching where, see DbUpdate for the changes.
+// Returns the number of updated rows.
+func DbUpdateWhere[T any](where map[string]interface{}, changes interface{}, opts ...UpdateOption) (int64, error) {
+	return DbUpdateWhereContext[T](context.Background(), where, changes, opts...)
+}
+
+func DbUpdateWhereContext[T any](ctx context.Context, where map[string]interface{}, changes interface{}, opts ...UpdateOption) (int64, error) {
+	if err := InitDB(); err != nil {
+		return 0, err
+	}
+	var tmp T
+	if err := autoMigrate(&tmp); err != nil {
+		return 0, err
+	}
+	s, err := parseSchema(&tmp)
+	if err != nil {
+		return 0, err
+	}
+	return dbUpdate(ctx, &tmp, s, whereScope(where), changes, opts)
+}
+
+func dbUpdate(ctx context.Context, model interface{}, s *schema.Schema, condition func(*gorm.DB) *gorm.DB, changes interface{}, opts []UpdateOption) (int64, error) {
+	options := &updateOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	values, err := changesToMap(ctx, s, changes, options.fields)
+	if err != nil {
+		return 0, err
+	}
+	if len(values) == 0 {
+		return 0, nil
+	}
+
+	// Versioned models get their version incremented. If the changes contain a version,
+	// only rows with that version are updated, which is the optimistic locking check.
+	var expectedVersion interface{}
+	versionField := lookupTaggedField(s, "version")
+	if versionField != nil {
+		if version, ok := values[versionField.DBName]; ok {
+			expectedVersion = version
+			values[versionField.DBName] = gorm.Expr("? + 1", version)
+		} else {
+			values[versionField.DBName] = gorm.Expr("? + 1", clause.Column{Table: clause.CurrentTable, Name: versionField.DBName})
+		}
+	}
+
+	var rowsAffected int64
+	err = withRetryAfterCommit(ctx, "update", func(ctx context.Context) error {
+		query := Db.WithContext(ctx).Model(model).Scopes(condition)
+		if expectedVersion != nil {
+			query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: versionField.DBName}, Value: expectedVersion})
+		}
+		result := query.Updates(values)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return 0, err
+	}
+	if expectedVersion != nil && rowsAffected == 0 {
+		return 0, ErrStaleObject
+	}
+	return rowsAffected, nil
+}
+
+// changesToMap converts the changes of an update into a map of column names to values.
+func changesToMap(ctx context.Context, s *schema.Schema, changes interface{}, fields []string) (map[string]interface{}, error) {
+	selected := make(map[string]bool)
+	for _, name := range fields {
+		field := s.LookUpField(name)
+		if field == nil {
+			return nil, fmt.Errorf("egorm: Unknown field %s of %s", name, s.Name)
+		}
+		selected[field.DBName] = true
+	}
+
+	values := make(map[string]interface{})
+	if changeMap, ok := changes.(map[string]interface{}); ok {
+		for name, value := range changeMap {
+			field := s.LookUpField(name)
+			if field == nil {
+				return nil, fmt.Errorf("egorm: Unknown field %s of %s", name, s.Name)
+			}
+			if len(selected) == 0 || selected[field.DBName] {
+				values[field.DBName] = value
+			}
+		}
+		return values, nil
+	}
+
+	reflectValue := reflect.Indirect(reflect.ValueOf(changes))
+	if reflectValue.Kind() != reflect.Struct || reflectValue.Type() != s.ModelType {
+		return nil, fmt.Errorf("egorm: Changes must be a map or a %s, got %T", s.Name, changes)
+	}
+	for _, field := range s.Fields {
+		if field.DBName == "" || field.PrimaryKey {
+			continue
+		}
+		value, isZero := field.ValueOf(ctx, reflectValue)
+		if selected[field.DBName] || (len(selected) == 0 && !isZero) {
+			values[field.DBName] = value
+		}
+	}
+	return values, nil
+}
+
+// Tracker remembers the state of an entity when it was loaded, to only write the fields that
+// changed since then, see DbUpdateDirty.
+type Tracker[T any] struct {
+	Entity   *T
+	snapshot map[string]interface{}
+}
+
+// Track takes a snapshot of the entity's fields.
+func Track[T any](entity *T) (*Tracker[T], error) {
+	if err := InitDB(); err != nil {
+		return nil, err
+	}
+	tracker := &Tracker[T]{Entity: entity}
+	if err := tracker.Reset(); err != nil {
+		return nil, err
+	}
+	return tracker, nil
+}
+
+// Reset takes a new snapshot, e.g. after the entity was written.
+func (t *Tracker[T]) Reset() error {
+	s, err := parseSchema(t.Entity)
+	if err != nil {
+		return err
+	}
+	t.snapshot = fieldValues(s, reflect.ValueOf(t.Entity).Elem())
+	return nil
+}
+
+// DirtyFields returns the names of the fields that changed since the snapshot.
+func (t *Tracker[T]) DirtyFields() ([]string, error) {
+	s, err := parseSchema(t.Entity)
+	if err != nil {
+		return nil, err
+	}
+	current := fieldValues(s, reflect.ValueOf(t.Entity).Elem())
+	dirty := make([]string, 0)
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		if !reflect.DeepEqual(t.snapshot[field.DBName], current[field.DBName]) {
+			dirty = append(dirty, field.Name)
+		}
+	}
+	return dirty, nil
+}
+
+// fieldValues returns the values of the fields JSON encoded, so changes inside of maps, slices
+// and pointers are detected as well. Values that cannot be encoded are compared as they are.
+func fieldValues(s *schema.Schema, reflectValue reflect.Value) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, field := range s.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		value, _ := field.ValueOf(context.Background(), reflectValue)
+		if encoded, err := json.Marshal(value); err == nil {
+			values[field.DBName] = string(encoded)
+		} else {
+			values[field.DBName] = value
+		}
+	}
+	return values
+}
+
+// DbUpdateDirty writes only the fields of the tracked entity that changed since the snapshot
+// and takes a new snapshot afterwards. Versioned entities are checked for concurrent changes.
+func DbUpdateDirty[T any](tracker *Tracker[T]) (int64, error) {
+	return DbUpdateDirtyContext(context.Background(), tracker)
+}
+
+func DbUpdateDirtyContext[T any](ctx context.Context, tracker *Tracker[T]) (int64, error) {
+	dirty, err := tracker.DirtyFields()
+	if err != nil {
+		return 0, err
+	}
+	if len(dirty) == 0 {
+		return 0, nil
+	}
+
+	s, err := parseSchema(tracker.Entity)
+	if err != nil {
+		return 0, err
+	}
+	reflectValue := reflect.ValueOf(tracker.Entity).Elem()
+	if len(s.PrimaryFields) == 0 {
+		return 0, fmt.Errorf("egorm: %s has no primary key to update by", s.Name)
+	}
+	key := make([]interface{}, 0, len(s.PrimaryFields))
+	for _, primaryField := range s.PrimaryFields {
+		value, _ := primaryField.ValueOf(ctx, reflectValue)
+		key = append(key, value)
+	}
+	var id interface{} = key
+	if len(key) == 1 {
+		id = key[0]
+	}
+
+	versionField := lookupTaggedField(s, "version")
+	if versionField != nil {
+		dirty = append(dirty, versionField.Name)
+	}
+
+	rowsAffected, err := DbUpdateContext[T](ctx, id, tracker.Entity, Fields(dirty...))
+	if err != nil {
+		return 0, err
+	}
+
+	if versionField != nil {
+		version, err := getVersion(versionField, reflectValue)
+		if err != nil {
+			return 0, err
+		}
+		if err := versionField.Set(ctx, reflectValue, version+1); err != nil {
+			return 0, err
+		}
+	}
+	return rowsAffected, tracker.Reset()
+}
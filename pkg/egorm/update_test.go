@@ -0,0 +1,194 @@
+package egorm
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type UpdateSample struct {
+	gorm.Model
+	Versioned
+	Name  string
+	Email string
+	Score int
+	Tags  JSON[[]string]
+}
+
+type CompositeUpdateSample struct {
+	Tenant string `gorm:"primaryKey"`
+	Code   string `gorm:"primaryKey"`
+	Name   string
+}
+
+func TestPartialUpdate(t *testing.T) {
+	t.Run("TestPartialUpdate", func(t *testing.T) {
+		fakeSetup()
+		sample := UpdateSample{Name: "Name", Email: "mail@example.com", Score: 5}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+
+		rows, err := DbUpdate[UpdateSample](sample.ID, UpdateSample{Name: "Renamed", Email: "ignored@example.com"}, Fields("Name", "Score"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if rows != 1 {
+			t.Errorf("egorm: Expected %d updated row, got %d", 1, rows)
+		}
+
+		var found UpdateSample
+		if err := DbFind(&found, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Name != "Renamed" || found.Email != "mail@example.com" || found.Score != 0 || found.Version != 2 {
+			t.Errorf("egorm: Expected only name and score to be updated, got %+v", found)
+		}
+
+		rows, err = DbUpdateWhere[UpdateSample](Where{"name": "Renamed"}, map[string]interface{}{"Email": "new@example.com"})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if rows != 1 {
+			t.Errorf("egorm: Expected %d updated row, got %d", 1, rows)
+		}
+
+		_, err = DbUpdate[UpdateSample](sample.ID, map[string]interface{}{"Version": 1, "Name": "Stale"})
+		if !errors.Is(err, ErrStaleObject) {
+			t.Errorf("egorm: Expected ErrStaleObject, got %v", err)
+		}
+	})
+}
+
+func TestDirtyTracking(t *testing.T) {
+	t.Run("TestDirtyTracking", func(t *testing.T) {
+		fakeSetup()
+		sample := UpdateSample{Name: "Tracked", Email: "tracked@example.com"}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var first, second UpdateSample
+		if err := DbFind(&first, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbFind(&second, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		tracker, err := Track(&first)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		// A concurrent change to another field is kept by the dirty update
+		if _, err := DbUpdate[UpdateSample](sample.ID, map[string]interface{}{"Email": "concurrent@example.com"}); err != nil {
+			t.Error(err)
+			return
+		}
+
+		first.Name = "Dirty"
+		dirty, err := tracker.DirtyFields()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(dirty) != 1 || dirty[0] != "Name" {
+			t.Errorf("egorm: Expected Name to be dirty, got %v", dirty)
+		}
+
+		// The version changed with the concurrent update, so this is a stale write
+		if _, err := DbUpdateDirty(tracker); !errors.Is(err, ErrStaleObject) {
+			t.Errorf("egorm: Expected ErrStaleObject, got %v", err)
+			return
+		}
+
+		if err := DbFind(&second, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		tracker, err = Track(&second)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		second.Name = "Dirty"
+		if _, err := DbUpdateDirty(tracker); err != nil {
+			t.Error(err)
+			return
+		}
+
+		var found UpdateSample
+		if err := DbFind(&found, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Name != "Dirty" || found.Email != "concurrent@example.com" || found.Version != second.Version {
+			t.Errorf("egorm: Expected dirty name and concurrent email, got %+v", found)
+		}
+	})
+}
+
+func TestDirtyTrackingKeys(t *testing.T) {
+	t.Run("TestDirtyTrackingInPlace", func(t *testing.T) {
+		fakeSetup()
+		sample := UpdateSample{Name: "Tagged", Tags: JSON[[]string]{Data: []string{"a"}}}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		tracker, err := Track(&sample)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sample.Tags.Data[0] = "b"
+		dirty, err := tracker.DirtyFields()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if len(dirty) != 1 || dirty[0] != "Tags" {
+			t.Errorf("egorm: Expected Tags to be dirty, got %v", dirty)
+		}
+	})
+
+	t.Run("TestDirtyTrackingCompositeKey", func(t *testing.T) {
+		fakeSetup()
+		sample := CompositeUpdateSample{Tenant: "a", Code: "x", Name: "Before"}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbCreate(&CompositeUpdateSample{Tenant: "b", Code: "x", Name: "Other"}); err != nil {
+			t.Error(err)
+			return
+		}
+		tracker, err := Track(&sample)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		sample.Name = "After"
+		rows, err := DbUpdateDirty(tracker)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if rows != 1 {
+			t.Errorf("egorm: Expected %d updated row, got %d", 1, rows)
+		}
+		var other CompositeUpdateSample
+		if err := DbFind(&other, Where{"Tenant": "b", "Code": "x"}); err != nil || other.Name != "Other" {
+			t.Errorf("egorm: Expected the other row to be unchanged, got %+v, %v", other, err)
+		}
+	})
+}
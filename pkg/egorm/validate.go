@@ -0,0 +1,464 @@
+package egorm
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrValidation is the error class of a *ValidationError, use errors.Is to check for it.
+var ErrValidation = errors.New("egorm: validation failed")
+
+// Validator is implemented by models with validation rules beyond the validate tags. Validate
+// runs after the tag rules of the model, before it is created or saved. Returning a
+// *ValidationError reports errors for single fields.
+type Validator interface {
+	Validate() error
+}
+
+// FieldError is a single failed validation rule.
+type FieldError struct {
+	// Field is the path of the field, e.g. Email, Address.City or Items[2].Name. It is empty for
+	// errors of a Validator that are not bound to a field.
+	Field string
+	// Rule is the failed rule of the validate tag, e.g. required, or validate for a Validator
+	Rule    string
+	Message string
+}
+
+func (e FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + " " + e.Message
+}
+
+// ValidationError lists the failed validation rules of a model. It is returned by all writes
+// of models with validate tags or a Validator.
+//
+//	type User struct {
+//		Name  string `validate:"required,min=3,max=64"`
+//		Email string `validate:"required,email"`
+//		Role  string `validate:"omitempty,oneof=admin member"`
+//	}
+type ValidationError struct {
+	Errors []FieldError
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Errors))
+	for i, fieldErr := range e.Errors {
+		messages[i] = fieldErr.Error()
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(messages, "; "))
+}
+
+func (e *ValidationError) Is(target error) bool {
+	return target == ErrValidation
+}
+
+type validationRule struct {
+	name  string
+	param string
+}
+
+// validatedField is a field of a struct type with its validation rules.
+type validatedField struct {
+	index     int
+	name      string
+	omitempty bool
+	rules     []validationRule
+	// embedded fields are validated without a path prefix, e.g. the fields of gorm.Model
+	embedded bool
+}
+
+var validatedFieldsCache sync.Map
+
+var (
+	validatorType  = reflect.TypeOf((*Validator)(nil)).Elem()
+	timeType       = reflect.TypeOf(time.Time{})
+	deletedAtType  = reflect.TypeOf(gorm.DeletedAt{})
+	knownRuleNames = map[string]bool{"required": true, "email": true, "min": true, "max": true, "oneof": true}
+)
+
+// Validate checks the validate tags of value, which must be a struct or a pointer to one, and
+// calls its Validate method if it is a Validator. Nested structs and slices of structs are
+// validated as well. Returns a *ValidationError if a rule failed.
+func Validate(value interface{}) error {
+	errs, err := validateValue(reflect.ValueOf(value), "")
+	if err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+func validateValue(value reflect.Value, path string) ([]FieldError, error) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return nil, nil
+		}
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		errs := make([]FieldError, 0)
+		for i := 0; i < value.Len(); i++ {
+			elemErrs, err := validateValue(value.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			errs = append(errs, elemErrs...)
+		}
+		return errs, nil
+	case reflect.Struct:
+		return validateStruct(value, path)
+	}
+	return nil, nil
+}
+
+func validateStruct(value reflect.Value, path string) ([]FieldError, error) {
+	fields, err := validatedFields(value.Type())
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]FieldError, 0)
+	for _, field := range fields {
+		fieldValue := value.Field(field.index)
+		fieldPath := joinPath(path, field.name)
+		if field.embedded {
+			fieldPath = path
+		}
+		fieldErrs := checkRules(fieldValue, fieldPath, field.omitempty, field.rules)
+		errs = append(errs, fieldErrs...)
+		if len(fieldErrs) > 0 || !isNestedType(fieldValue.Type()) {
+			continue
+		}
+		nestedErrs, err := validateValue(fieldValue, fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		errs = append(errs, nestedErrs...)
+	}
+
+	validatorErrs, err := callValidator(value, path)
+	if err != nil {
+		return nil, err
+	}
+	return append(errs, validatorErrs...), nil
+}
+
+// callValidator calls Validate of value, which may be implemented on the pointer.
+func callValidator(value reflect.Value, path string) ([]FieldError, error) {
+	var validator Validator
+	if value.Type().Implements(validatorType) {
+		validator = value.Interface().(Validator)
+	} else if value.CanAddr() && value.Addr().Type().Implements(validatorType) {
+		validator = value.Addr().Interface().(Validator)
+	}
+	if validator == nil {
+		return nil, nil
+	}
+	err := validator.Validate()
+	if err == nil {
+		return nil, nil
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return []FieldError{{Field: path, Rule: "validate", Message: err.Error()}}, nil
+	}
+	errs := make([]FieldError, len(validationErr.Errors))
+	for i, fieldErr := range validationErr.Errors {
+		fieldErr.Field = joinPath(path, fieldErr.Field)
+		errs[i] = fieldErr
+	}
+	return errs, nil
+}
+
+func joinPath(path string, name string) string {
+	if path == "" || name == "" {
+		return path + name
+	}
+	return path + "." + name
+}
+
+// isNestedType reports whether values of the type are validated recursively.
+func isNestedType(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && t != timeType && t != deletedAtType && !t.Implements(encryptedValueType)
+}
+
+// validatedFields returns the fields of the struct type that have rules or may contain fields
+// with rules.
+func validatedFields(t reflect.Type) ([]validatedField, error) {
+	if cached, ok := validatedFieldsCache.Load(t); ok {
+		return cached.([]validatedField), nil
+	}
+	fields := make([]validatedField, 0)
+	for i := 0; i < t.NumField(); i++ {
+		structField := t.Field(i)
+		tag := structField.Tag.Get("validate")
+		if !structField.IsExported() || tag == "-" {
+			continue
+		}
+		field := validatedField{index: i, name: structField.Name, embedded: structField.Anonymous}
+		for _, rule := range strings.Split(tag, ",") {
+			name, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+			switch {
+			case name == "":
+				continue
+			case name == "omitempty":
+				field.omitempty = true
+				continue
+			case !knownRuleNames[name]:
+				return nil, fmt.Errorf("egorm: Unknown validation rule %q on %s.%s", name, t.Name(), structField.Name)
+			case name == "min" || name == "max":
+				if _, err := strconv.ParseFloat(param, 64); err != nil {
+					return nil, fmt.Errorf("egorm: Validation rule %s of %s.%s needs a number, got %q", name, t.Name(), structField.Name, param)
+				}
+			case name == "oneof" && param == "":
+				return nil, fmt.Errorf("egorm: Validation rule oneof of %s.%s needs values", t.Name(), structField.Name)
+			}
+			field.rules = append(field.rules, validationRule{name: name, param: param})
+		}
+		if len(field.rules) > 0 || isNestedType(structField.Type) {
+			fields = append(fields, field)
+		}
+	}
+	validatedFieldsCache.Store(t, fields)
+	return fields, nil
+}
+
+// checkRules checks the rules of a single value and returns the first failed rule.
+func checkRules(value reflect.Value, path string, omitempty bool, rules []validationRule) []FieldError {
+	value = ruleValue(value)
+	empty := !value.IsValid() || value.IsZero()
+	if empty && omitempty {
+		return nil
+	}
+	for _, rule := range rules {
+		if message := checkRule(value, empty, rule); message != "" {
+			return []FieldError{{Field: path, Rule: rule.name, Message: message}}
+		}
+	}
+	return nil
+}
+
+// ruleValue dereferences pointers and decrypts Encrypted values, an invalid value is returned
+// for nil.
+func ruleValue(value reflect.Value) reflect.Value {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return reflect.Value{}
+		}
+		value = value.Elem()
+	}
+	if value.Type().Implements(encryptedValueType) {
+		return ruleValue(reflect.ValueOf(value.Interface().(encryptedValue).plain()))
+	}
+	return value
+}
+
+func checkRule(value reflect.Value, empty bool, rule validationRule) string {
+	switch rule.name {
+	case "required":
+		if empty {
+			return "is required"
+		}
+	case "email":
+		if value.Kind() != reflect.String {
+			return "must be a valid email address"
+		}
+		address, err := mail.ParseAddress(value.String())
+		if err != nil || address.Address != value.String() {
+			return "must be a valid email address"
+		}
+	case "min", "max":
+		limit, _ := strconv.ParseFloat(rule.param, 64)
+		var size float64
+		unit := ""
+		switch value.Kind() {
+		case reflect.String:
+			size, unit = float64(utf8.RuneCountInString(value.String())), " characters"
+		case reflect.Slice, reflect.Array, reflect.Map:
+			size, unit = float64(value.Len()), " items"
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			size = float64(value.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			size = float64(value.Uint())
+		case reflect.Float32, reflect.Float64:
+			size = value.Float()
+		}
+		if rule.name == "min" && size < limit {
+			if unit == "" {
+				return "must be at least " + rule.param
+			}
+			return "must have at least " + rule.param + unit
+		}
+		if rule.name == "max" && size > limit {
+			if unit == "" {
+				return "must be at most " + rule.param
+			}
+			return "must have at most " + rule.param + unit
+		}
+	case "oneof":
+		allowed := strings.Fields(rule.param)
+		if !value.IsValid() {
+			return "must be one of " + strings.Join(allowed, ", ")
+		}
+		for _, candidate := range allowed {
+			if fmt.Sprint(value.Interface()) == candidate {
+				return ""
+			}
+		}
+		return "must be one of " + strings.Join(allowed, ", ")
+	}
+	return ""
+}
+
+// validateBeforeCreate validates the created models.
+func validateBeforeCreate(db *gorm.DB) {
+	validateStatement(db, true)
+}
+
+// validateBeforeUpdate validates the models of saves. Partial updates, e.g. of DbUpdate, only
+// check the tag rules of the written fields, since the other fields may not be loaded.
+func validateBeforeUpdate(db *gorm.DB) {
+	full := false
+	for _, selected := range db.Statement.Selects {
+		full = full || selected == "*"
+	}
+	validateStatement(db, full)
+}
+
+func validateStatement(db *gorm.DB, full bool) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || isInternalTable(stmt.Schema.Table) {
+		return
+	}
+
+	var errs []FieldError
+	var err error
+	dest := reflect.Indirect(reflect.ValueOf(stmt.Dest))
+	if values, ok := stmt.Dest.(map[string]interface{}); ok {
+		errs, err = validateColumns(stmt.Schema, values)
+	} else if !full && dest.Kind() == reflect.Struct && dest.Type() == stmt.Schema.ModelType {
+		errs, err = validateWrittenFields(stmt, dest)
+	} else if full {
+		errs, err = validateValue(dest, "")
+	}
+	if err != nil {
+		db.AddError(err)
+	} else if len(errs) > 0 {
+		db.AddError(&ValidationError{Errors: errs})
+	}
+}
+
+// validateColumns checks the rules of the fields of a column map.
+func validateColumns(s *schema.Schema, values map[string]interface{}) ([]FieldError, error) {
+	fields, err := validatedFields(s.ModelType)
+	if err != nil {
+		return nil, err
+	}
+	errs := make([]FieldError, 0)
+	for name, value := range values {
+		field := s.LookUpField(name)
+		if field == nil || len(field.StructField.Index) == 0 {
+			continue
+		}
+		if _, isExpression := value.(clause.Expression); isExpression {
+			continue
+		}
+		rules, omitempty, ok := rulesOf(s, fields, field)
+		if !ok {
+			continue
+		}
+		errs = append(errs, checkRules(reflect.ValueOf(&value).Elem(), field.Name, omitempty, rules)...)
+	}
+	sortFieldErrors(errs)
+	return errs, nil
+}
+
+// validateWrittenFields checks the rules of the fields an update of a struct writes, which are
+// the selected or non-zero fields.
+func validateWrittenFields(stmt *gorm.Statement, dest reflect.Value) ([]FieldError, error) {
+	fields, err := validatedFields(stmt.Schema.ModelType)
+	if err != nil {
+		return nil, err
+	}
+	selected := make(map[string]bool)
+	for _, name := range stmt.Selects {
+		if field := stmt.Schema.LookUpField(name); field != nil {
+			selected[field.DBName] = true
+		}
+	}
+	errs := make([]FieldError, 0)
+	for _, field := range stmt.Schema.Fields {
+		if field.DBName == "" {
+			continue
+		}
+		value, isZero := field.ValueOf(stmt.Context, dest)
+		if (len(selected) > 0 && !selected[field.DBName]) || (len(selected) == 0 && isZero) {
+			continue
+		}
+		rules, omitempty, ok := rulesOf(stmt.Schema, fields, field)
+		if !ok {
+			continue
+		}
+		errs = append(errs, checkRules(reflect.ValueOf(&value).Elem(), field.Name, omitempty, rules)...)
+	}
+	return errs, nil
+}
+
+// rulesOf returns the rules of a top level or embedded field of the schema.
+func rulesOf(s *schema.Schema, fields []validatedField, field *schema.Field) ([]validationRule, bool, bool) {
+	index := field.StructField.Index
+	for _, candidate := range fields {
+		if candidate.index != index[0] {
+			continue
+		}
+		if len(index) == 1 {
+			return candidate.rules, candidate.omitempty, len(candidate.rules) > 0
+		}
+		embeddedType := s.ModelType.Field(index[0]).Type
+		if embeddedType.Kind() == reflect.Pointer {
+			embeddedType = embeddedType.Elem()
+		}
+		if len(index) != 2 || embeddedType.Kind() != reflect.Struct {
+			return nil, false, false
+		}
+		embedded, err := validatedFields(embeddedType)
+		if err != nil {
+			return nil, false, false
+		}
+		for _, nested := range embedded {
+			if nested.index == index[1] {
+				return nested.rules, nested.omitempty, len(nested.rules) > 0
+			}
+		}
+	}
+	return nil, false, false
+}
+
+func sortFieldErrors(errs []FieldError) {
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Field < errs[j].Field
+	})
+}
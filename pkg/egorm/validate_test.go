@@ -0,0 +1,202 @@
+package egorm
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type ValidatedAddress struct {
+	City string `validate:"required"`
+	Zip  string `validate:"omitempty,min=4,max=5"`
+}
+
+type ValidatedSample struct {
+	gorm.Model
+	Name      string           `validate:"required,min=3,max=64"`
+	Email     string           `validate:"required,email"`
+	Role      string           `validate:"omitempty,oneof=admin member"`
+	Score     int              `validate:"min=0,max=100"`
+	Nickname  *string          `validate:"omitempty,min=2"`
+	Address   ValidatedAddress `gorm:"serializer:json"`
+	StartYear int
+	EndYear   int
+}
+
+func (s ValidatedSample) Validate() error {
+	if s.EndYear != 0 && s.EndYear < s.StartYear {
+		return &ValidationError{Errors: []FieldError{{Field: "EndYear", Rule: "validate", Message: "must not be before StartYear"}}}
+	}
+	return nil
+}
+
+type InvalidTagSample struct {
+	ID   uint
+	Name string `validate:"required,uppercase"`
+}
+
+func validSample() ValidatedSample {
+	return ValidatedSample{Name: "Jane", Email: "jane@example.com", Role: "admin", Score: 50, Address: ValidatedAddress{City: "Berlin", Zip: "10115"}}
+}
+
+// fieldErrors returns the field errors of a *ValidationError by field.
+func fieldErrors(err error) (map[string]FieldError, error) {
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		return nil, fmt.Errorf("egorm: Expected a ValidationError, got %v", err)
+	}
+	errs := make(map[string]FieldError)
+	for _, fieldErr := range validationErr.Errors {
+		errs[fieldErr.Field] = fieldErr
+	}
+	return errs, nil
+}
+
+func TestValidate(t *testing.T) {
+	t.Run("TestRules", func(t *testing.T) {
+		if err := Validate(validSample()); err != nil {
+			t.Error(err)
+			return
+		}
+
+		short := "x"
+		sample := ValidatedSample{Name: "Jo", Email: "not an email", Role: "guest", Score: 101, Nickname: &short,
+			Address: ValidatedAddress{Zip: "123"}, StartYear: 2020, EndYear: 2019}
+		errs, err := fieldErrors(Validate(&sample))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		expected := map[string]string{
+			"Name":         "min",
+			"Email":        "email",
+			"Role":         "oneof",
+			"Score":        "max",
+			"Nickname":     "min",
+			"Address.City": "required",
+			"Address.Zip":  "min",
+			"EndYear":      "validate",
+		}
+		if len(errs) != len(expected) {
+			t.Errorf("egorm: Expected %d field errors, got %d: %v", len(expected), len(errs), errs)
+		}
+		for field, rule := range expected {
+			if errs[field].Rule != rule {
+				t.Errorf("egorm: Expected rule %s to fail for %s, got %q", rule, field, errs[field].Rule)
+			}
+		}
+		if !errors.Is(Validate(&sample), ErrValidation) {
+			t.Errorf("egorm: Expected ErrValidation")
+		}
+	})
+
+	t.Run("TestSlices", func(t *testing.T) {
+		samples := []ValidatedSample{validSample(), validSample()}
+		samples[1].Email = ""
+		errs, err := fieldErrors(Validate(samples))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if errs["[1].Email"].Rule != "required" {
+			t.Errorf("egorm: Expected [1].Email to be required, got %v", errs)
+		}
+	})
+
+	t.Run("TestUnknownRule", func(t *testing.T) {
+		err := Validate(InvalidTagSample{Name: "x"})
+		if err == nil || errors.Is(err, ErrValidation) {
+			t.Errorf("egorm: Expected an error for the unknown rule, got %v", err)
+		}
+	})
+}
+
+func TestValidateBeforeWrite(t *testing.T) {
+	t.Run("TestCreate", func(t *testing.T) {
+		fakeSetup()
+		invalid := validSample()
+		invalid.Email = "invalid"
+		errs, err := fieldErrors(DbCreate(&invalid))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, ok := errs["Email"]; !ok || len(errs) != 1 {
+			t.Errorf("egorm: Expected only Email to fail, got %v", errs)
+		}
+		if invalid.ID != 0 {
+			t.Errorf("egorm: Expected the invalid sample not to be created")
+		}
+
+		valid := validSample()
+		if err := DbCreate(&valid); err != nil {
+			t.Error(err)
+			return
+		}
+		samples := make([]ValidatedSample, 0)
+		if err := DbGet(&samples, Where{"email": "invalid"}); err != nil {
+			t.Error(err)
+			return
+		}
+		if len(samples) != 0 {
+			t.Errorf("egorm: Expected %d items, got %d", 0, len(samples))
+		}
+	})
+
+	t.Run("TestSave", func(t *testing.T) {
+		fakeSetup()
+		sample := validSample()
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		sample.StartYear, sample.EndYear = 2020, 2010
+		if _, err := fieldErrors(DbSave(&sample)); err != nil {
+			t.Error(err)
+		}
+		sample.EndYear = 2021
+		if err := DbSave(&sample); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("TestPartialUpdate", func(t *testing.T) {
+		fakeSetup()
+		sample := validSample()
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		_, err := DbUpdate[ValidatedSample](sample.ID, map[string]interface{}{"role": "owner", "score": 10})
+		errs, err := fieldErrors(err)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if _, ok := errs["Role"]; !ok || len(errs) != 1 {
+			t.Errorf("egorm: Expected only Role to fail, got %v", errs)
+		}
+
+		// Only the written fields are checked, the other fields are not loaded
+		if _, err := DbUpdate[ValidatedSample](sample.ID, ValidatedSample{Score: 80}, Fields("Score")); err != nil {
+			t.Error(err)
+		}
+		if _, err := DbUpdate[ValidatedSample](sample.ID, ValidatedSample{Name: "X"}); !errors.Is(err, ErrValidation) {
+			t.Errorf("egorm: Expected ErrValidation, got %v", err)
+		}
+		if err := Db.Model(&ValidatedSample{}).Where("id = ?", sample.ID).Update("email", "invalid").Error; !errors.Is(err, ErrValidation) {
+			t.Errorf("egorm: Expected ErrValidation for a gorm update, got %v", err)
+		}
+
+		var found ValidatedSample
+		if err := DbFind(&found, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if found.Score != 80 || found.Role != "admin" || found.Email != "jane@example.com" {
+			t.Errorf("egorm: Expected only the score to change, got %+v", found)
+		}
+	})
+}
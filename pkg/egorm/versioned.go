@@ -0,0 +1,151 @@
+package egorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+// ErrStaleObject is returned when saving a versioned model that was changed in the
+// database since it was loaded.
+var ErrStaleObject = errors.New("egorm: object was modified concurrently")
+
+// Versioned enables optimistic locking when embedded into a model. Saves only succeed
+// if the version in the database still matches the one of the model and increment it.
+// Alternatively, tag an integer field with `egorm:"version"`.
+type Versioned struct {
+	Version uint64 `gorm:"not null;default:1" egorm:"version"`
+}
+
+// lookupVersionField returns the version field of the model or nil if it is not versioned.
+func lookupVersionField(model interface{}) (*schema.Schema, *schema.Field, error) {
+	s, err := parseSchema(model)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, lookupTaggedField(s, "version"), nil
+}
+
+func getVersion(field *schema.Field, reflectValue reflect.Value) (uint64, error) {
+	value := reflect.Indirect(field.ReflectValueOf(context.Background(), reflectValue))
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(value.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return value.Uint(), nil
+	default:
+		return 0, fmt.Errorf("egorm: Version field %s must be an integer, got %s", field.Name, value.Kind())
+	}
+}
+
+// initVersion sets the version of a versioned model to 1 before it is created.
+func initVersion(model interface{}) error {
+	_, field, err := lookupVersionField(model)
+	if err != nil || field == nil {
+		return err
+	}
+
+	reflectValue := reflect.ValueOf(model).Elem()
+	version, err := getVersion(field, reflectValue)
+	if err != nil || version != 0 {
+		return err
+	}
+	return field.Set(context.Background(), reflectValue, uint64(1))
+}
+
+// saveVersioned saves the model only if the version in the database matches the version
+// of the model, and increments the version. Returns ErrStaleObject otherwise.
+func saveVersioned(ctx context.Context, model interface{}, s *schema.Schema, field *schema.Field) error {
+	reflectValue := reflect.ValueOf(model).Elem()
+
+	for _, primaryField := range s.PrimaryFields {
+		if _, isZero := primaryField.ValueOf(ctx, reflectValue); isZero {
+			if err := initVersion(model); err != nil {
+				return err
+			}
+			return withRetryAfterCommit(ctx, "create", func(ctx context.Context) error {
+				return Db.WithContext(ctx).Create(model).Error
+			})
+		}
+	}
+
+	version, err := getVersion(field, reflectValue)
+	if err != nil {
+		return err
+	}
+	if err := field.Set(ctx, reflectValue, version+1); err != nil {
+		return err
+	}
+
+	var rowsAffected int64
+	err = withRetryAfterCommit(ctx, "save", func(ctx context.Context) error {
+		result := Db.WithContext(ctx).Select("*").
+			Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: field.DBName}, Value: version}).
+			Save(model)
+		rowsAffected = result.RowsAffected
+		return result.Error
+	})
+
+	if err == nil && rowsAffected == 0 {
+		err = ErrStaleObject
+	}
+	if err != nil {
+		// Restore the version, so the caller can still see which version was loaded
+		if setErr := field.Set(ctx, reflectValue, version); setErr != nil {
+			return setErr
+		}
+		return err
+	}
+	return nil
+}
+
+// DbMutate applies mutate to input and saves it. If the save fails with ErrStaleObject,
+// the entity is reloaded from the database and mutate is applied again, up to attempts times.
+func DbMutate[T any](input *T, attempts int, mutate func(*T) error) error {
+	for attempt := 1; ; attempt++ {
+		if err := mutate(input); err != nil {
+			return err
+		}
+
+		err := DbSave(input)
+		if err == nil || !errors.Is(err, ErrStaleObject) || attempt >= attempts {
+			return err
+		}
+
+		if err := dbReload(input); err != nil {
+			return err
+		}
+	}
+}
+
+// dbReload replaces input with the current state of the database, based on its primary key.
+func dbReload[T any](input *T) error {
+	s, err := parseSchema(input)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var fresh T
+	reflectValue := reflect.ValueOf(input).Elem()
+	freshValue := reflect.ValueOf(&fresh).Elem()
+	for _, primaryField := range s.PrimaryFields {
+		value, _ := primaryField.ValueOf(ctx, reflectValue)
+		if err := primaryField.Set(ctx, freshValue, value); err != nil {
+			return err
+		}
+	}
+
+	err = withRetry(ctx, "reload", func() error {
+		return Db.First(&fresh).Error
+	})
+	if err != nil {
+		return err
+	}
+	*input = fresh
+	return nil
+}
@@ -0,0 +1,69 @@
+package egorm
+
+import (
+	"errors"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+type VersionedSample struct {
+	gorm.Model
+	Versioned
+	Name string
+}
+
+func TestOptimisticLocking(t *testing.T) {
+	t.Run("TestOptimisticLocking", func(t *testing.T) {
+		fakeSetup()
+		sample := VersionedSample{Name: "Initial"}
+		if err := DbCreate(&sample); err != nil {
+			t.Error(err)
+			return
+		}
+		if sample.Version != 1 {
+			t.Errorf("egorm: Expected version %d, got %d", 1, sample.Version)
+		}
+
+		var first, second VersionedSample
+		if err := DbFind(&first, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+		if err := DbFind(&second, int(sample.ID)); err != nil {
+			t.Error(err)
+			return
+		}
+
+		first.Name = "First"
+		if err := DbSave(&first); err != nil {
+			t.Error(err)
+			return
+		}
+		if first.Version != 2 {
+			t.Errorf("egorm: Expected version %d, got %d", 2, first.Version)
+		}
+
+		second.Name = "Second"
+		err := DbSave(&second)
+		if !errors.Is(err, ErrStaleObject) {
+			t.Errorf("egorm: Expected ErrStaleObject, got %v", err)
+			return
+		}
+		if second.Version != 1 {
+			t.Errorf("egorm: Expected version to stay at %d, got %d", 1, second.Version)
+		}
+
+		err = DbMutate(&second, 2, func(s *VersionedSample) error {
+			s.Name = "Second"
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if second.Version != 3 || second.Name != "Second" {
+			t.Errorf("egorm: Expected version %d and name Second, got %d and %s", 3, second.Version, second.Name)
+		}
+	})
+}
@@ -0,0 +1,130 @@
+package egorm
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Where operators that can follow the column in a key of Where, e.g. Where{"age >=": 18}.
+var whereOperators = []string{"=", "!=", "<>", "<", "<=", ">", ">=", "like", "not like", "in", "not in"}
+
+var whereColumnPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+var wherePathPattern = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+
+// whereScope applies the conditions of a Where map to a query. Keys are column names, optionally
+// followed by one of the whereOperators and may address fields inside JSON columns with "->",
+// e.g. Where{"settings->theme": "dark", "age >=": 18}. Keys without operator and path are passed
+// to gorm unchanged, which compares them with = or IN for slices.
+func whereScope(where map[string]interface{}) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		plain := make(map[string]interface{})
+		keys := make([]string, 0, len(where))
+		for key := range where {
+			keys = append(keys, key)
+		}
+		// Sort the keys, so the generated SQL is stable
+		sort.Strings(keys)
+
+		exprs := make([]clause.Expression, 0)
+		for _, key := range keys {
+			if !strings.Contains(key, "->") && !strings.Contains(strings.TrimSpace(key), " ") {
+				plain[key] = where[key]
+				continue
+			}
+			expr, err := whereExpression(db.Dialector.Name(), key, where[key])
+			if err != nil {
+				db.AddError(err)
+				return db
+			}
+			exprs = append(exprs, expr)
+		}
+
+		if len(plain) > 0 || len(exprs) == 0 {
+			db = db.Where(plain)
+		}
+		if len(exprs) > 0 {
+			db = db.Where(clause.And(exprs...))
+		}
+		return db
+	}
+}
+
+func whereExpression(dialect string, key string, value interface{}) (clause.Expression, error) {
+	tokens := strings.Fields(key)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("egorm: Empty key in Where")
+	}
+
+	operator := "="
+	if len(tokens) > 1 {
+		operator = strings.ToLower(strings.Join(tokens[1:], " "))
+		supported := false
+		for _, whereOperator := range whereOperators {
+			supported = supported || operator == whereOperator
+		}
+		if !supported {
+			return nil, fmt.Errorf("egorm: Unsupported operator %q in Where key %q", operator, key)
+		}
+	}
+
+	path := strings.Split(tokens[0], "->")
+	column := path[0]
+	if !whereColumnPattern.MatchString(column) {
+		return nil, fmt.Errorf("egorm: Invalid column %q in Where key %q", column, key)
+	}
+	path = path[1:]
+	for _, segment := range path {
+		if !wherePathPattern.MatchString(segment) {
+			return nil, fmt.Errorf("egorm: Invalid JSON path %q in Where key %q", segment, key)
+		}
+	}
+
+	var columnExpr clause.Expression = clause.Expr{SQL: "?", Vars: []interface{}{clause.Column{Name: column}}}
+	if table, name, found := strings.Cut(column, "."); found {
+		columnExpr = clause.Expr{SQL: "?", Vars: []interface{}{clause.Column{Table: table, Name: name}}}
+	}
+	if len(path) > 0 {
+		columnExpr = jsonPathExpression(dialect, columnExpr, path, value)
+	}
+
+	if value == nil {
+		switch operator {
+		case "=":
+			return clause.Expr{SQL: "? IS NULL", Vars: []interface{}{columnExpr}}, nil
+		case "!=", "<>":
+			return clause.Expr{SQL: "? IS NOT NULL", Vars: []interface{}{columnExpr}}, nil
+		}
+	}
+	return clause.Expr{SQL: fmt.Sprintf("? %s ?", strings.ToUpper(operator)), Vars: []interface{}{columnExpr, value}}, nil
+}
+
+// jsonPathExpression extracts the field at path from a JSON column. sqlite returns the JSON
+// type of the field, postgres returns text, which is cast to the type of the compared value.
+func jsonPathExpression(dialect string, column clause.Expression, path []string, value interface{}) clause.Expression {
+	if dialect != "postgres" {
+		return clause.Expr{SQL: fmt.Sprintf("json_extract(?, '$.%s')", strings.Join(path, ".")), Vars: []interface{}{column}}
+	}
+
+	extracted := fmt.Sprintf("(? #>> '{%s}')", strings.Join(path, ","))
+	valueType := reflect.TypeOf(value)
+	if valueType != nil && (valueType.Kind() == reflect.Slice || valueType.Kind() == reflect.Array) {
+		valueType = valueType.Elem()
+	}
+	if valueType != nil {
+		switch valueType.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+			reflect.Float32, reflect.Float64:
+			extracted += "::numeric"
+		case reflect.Bool:
+			extracted += "::boolean"
+		}
+	}
+	return clause.Expr{SQL: extracted, Vars: []interface{}{column}}
+}
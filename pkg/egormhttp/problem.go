@@ -0,0 +1,133 @@
+package egormhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/martenwallewein/easy-going/pkg/egorm"
+	"gorm.io/gorm"
+)
+
+// Problem is an RFC 7807 problem details response.
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	// InvalidParams lists the rejected parameters or fields of a validation problem
+	InvalidParams []InvalidParam `json:"invalid-params,omitempty"`
+}
+
+// InvalidParam is a single rejected parameter or field of a request.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return fmt.Sprintf("egormhttp: %s", p.Title)
+	}
+	return fmt.Sprintf("egormhttp: %s: %s", p.Title, p.Detail)
+}
+
+// NewProblem returns a problem with the standard title of the status.
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{Type: "about:blank", Title: http.StatusText(status), Status: status, Detail: detail}
+}
+
+// validationProblem returns a 400 problem for the rejected parameters.
+func validationProblem(params ...InvalidParam) *Problem {
+	problem := NewProblem(http.StatusBadRequest, "The request contains invalid parameters")
+	problem.InvalidParams = params
+	return problem
+}
+
+// WriteProblem writes the problem as application/problem+json.
+func WriteProblem(w http.ResponseWriter, problem *Problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(problem.Status)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// problemOf maps an error of egorm or a hook to a problem. The fields of validation errors
+// are reported by their JSON path in the model type.
+func problemOf(err error, model reflect.Type) *Problem {
+	var problem *Problem
+	if errors.As(err, &problem) {
+		return problem
+	}
+	var validationErr *egorm.ValidationError
+	if errors.As(err, &validationErr) {
+		problem = NewProblem(http.StatusUnprocessableEntity, "The entity is invalid")
+		for _, fieldErr := range validationErr.Errors {
+			problem.InvalidParams = append(problem.InvalidParams, InvalidParam{Name: jsonPath(model, fieldErr.Field), Reason: fieldErr.Message})
+		}
+		return problem
+	}
+
+	var dbErr *egorm.DbError
+	errors.As(err, &dbErr)
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return NewProblem(http.StatusNotFound, "")
+	case errors.Is(err, egorm.ErrStaleObject):
+		return NewProblem(http.StatusConflict, "The entity was modified concurrently")
+	case errors.Is(err, egorm.ErrUniqueViolation):
+		problem = NewProblem(http.StatusConflict, "An entity with the same unique values exists")
+	case errors.Is(err, egorm.ErrForeignKeyViolation), errors.Is(err, egorm.ErrNotNullViolation), errors.Is(err, egorm.ErrCheckViolation):
+		problem = NewProblem(http.StatusUnprocessableEntity, "The entity violates a constraint")
+	case errors.Is(err, egorm.ErrCrossTenant):
+		// Entities of other tenants are reported like missing ones, so their existence is not revealed
+		return NewProblem(http.StatusNotFound, "")
+	case errors.Is(err, egorm.ErrMissingTenant):
+		return NewProblem(http.StatusForbidden, "No tenant")
+	case errors.Is(err, egorm.ErrBusy), errors.Is(err, egorm.ErrConnection), errors.Is(err, egorm.ErrSerialization):
+		return NewProblem(http.StatusServiceUnavailable, "")
+	default:
+		return NewProblem(http.StatusInternalServerError, "")
+	}
+	if dbErr != nil && dbErr.Column != "" {
+		problem.InvalidParams = []InvalidParam{{Name: dbErr.Column, Reason: dbErr.Kind.Error()}}
+	}
+	return problem
+}
+
+// pathSegment matches a segment of a field path like Items[2].
+var pathSegment = regexp.MustCompile(`^([^\[]*)(.*)$`)
+
+// jsonPath translates a field path of an egorm.ValidationError, e.g. Address.City or
+// Items[2].Name, into the JSON names of the fields.
+func jsonPath(model reflect.Type, path string) string {
+	if path == "" {
+		return path
+	}
+	segments := strings.Split(path, ".")
+	for i, segment := range segments {
+		match := pathSegment.FindStringSubmatch(segment)
+		for model != nil && (model.Kind() == reflect.Pointer || model.Kind() == reflect.Slice || model.Kind() == reflect.Array) {
+			model = model.Elem()
+		}
+		if match[1] == "" || model == nil || model.Kind() != reflect.Struct {
+			continue
+		}
+		field, ok := model.FieldByName(match[1])
+		if !ok {
+			model = nil
+			continue
+		}
+		if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+			segments[i] = name + match[2]
+		}
+		model = field.Type
+		if match[2] != "" {
+			model = model.Elem()
+		}
+	}
+	return strings.Join(segments, ".")
+}
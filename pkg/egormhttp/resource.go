@@ -0,0 +1,583 @@
+// Package egormhttp serves egorm models as JSON REST resources.
+package egormhttp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/martenwallewein/easy-going/pkg/egorm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// Operation is one of the operations served by a resource.
+type Operation string
+
+const (
+	OperationList   Operation = "list"
+	OperationGet    Operation = "get"
+	OperationCreate Operation = "create"
+	OperationUpdate Operation = "update"
+	OperationDelete Operation = "delete"
+)
+
+// Defaults of the pagination, overridden by ResourceOpts.
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// maxBodySize limits the size of request bodies.
+const maxBodySize = 1 << 20
+
+// queryOperators map the operators of filters in the query string, e.g. ?age[gte]=18,
+// to the operators of egorm.Where.
+var queryOperators = map[string]string{
+	"eq":   "=",
+	"ne":   "!=",
+	"lt":   "<",
+	"lte":  "<=",
+	"gt":   ">",
+	"gte":  ">=",
+	"like": "like",
+	"in":   "in",
+	"nin":  "not in",
+}
+
+// ResourceOpts configures a resource.
+type ResourceOpts[T any] struct {
+	// Authorize is called before each operation. The entity is nil for OperationList, the decoded
+	// entity for OperationCreate and the stored entity otherwise. Returning an error rejects the
+	// request with 403 Forbidden, or with the status of a returned *Problem.
+	Authorize func(r *http.Request, op Operation, entity *T) error
+	// Operations lists the served operations, defaults to all
+	Operations []Operation
+	// DefaultPageSize is the page size of lists without page_size parameter
+	DefaultPageSize int
+	// MaxPageSize caps the page_size parameter
+	MaxPageSize int
+}
+
+type resource[T any] struct {
+	opts        ResourceOpts[T]
+	schemaLock  sync.Mutex
+	schema      *schema.Schema
+	schemaCache sync.Map
+}
+
+// ListResponse is the body of a list request.
+type ListResponse struct {
+	Items    []json.RawMessage `json:"items"`
+	Total    int64             `json:"total"`
+	Page     int               `json:"page"`
+	PageSize int               `json:"page_size"`
+}
+
+// Resource returns a handler serving the entities of type T as JSON:
+//
+//	GET    /       lists entities, see below
+//	POST   /       creates an entity
+//	GET    /{id}   returns an entity
+//	PATCH  /{id}   updates the fields contained in the body, PUT behaves the same
+//	DELETE /{id}   deletes an entity
+//
+// Lists are filtered with query parameters by field, e.g. ?name=jane&age[gte]=18&role[in]=a,b,
+// using the operators eq, ne, lt, lte, gt, gte, like, in and nin. They are sorted with
+// ?sort=-created_at,name, paged with ?page=2&page_size=10 and ?fields=ID,name selects the
+// returned fields. Mount the handler with http.StripPrefix, e.g.
+//
+//	mux.Handle("/users/", http.StripPrefix("/users", egormhttp.Resource[User](egormhttp.ResourceOpts[User]{})))
+func Resource[T any](opts ResourceOpts[T]) http.Handler {
+	if opts.DefaultPageSize <= 0 {
+		opts.DefaultPageSize = DefaultPageSize
+	}
+	if opts.MaxPageSize <= 0 {
+		opts.MaxPageSize = MaxPageSize
+	}
+	if len(opts.Operations) == 0 {
+		opts.Operations = []Operation{OperationList, OperationGet, OperationCreate, OperationUpdate, OperationDelete}
+	}
+	return &resource[T]{opts: opts}
+}
+
+func (res *resource[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.Trim(r.URL.Path, "/")
+	if strings.Contains(id, "/") {
+		WriteProblem(w, NewProblem(http.StatusNotFound, ""))
+		return
+	}
+
+	var op Operation
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		op = OperationList
+	case id == "" && r.Method == http.MethodPost:
+		op = OperationCreate
+	case id != "" && r.Method == http.MethodGet:
+		op = OperationGet
+	case id != "" && (r.Method == http.MethodPatch || r.Method == http.MethodPut):
+		op = OperationUpdate
+	case id != "" && r.Method == http.MethodDelete:
+		op = OperationDelete
+	}
+	if op == "" || !res.serves(op) {
+		WriteProblem(w, NewProblem(http.StatusMethodNotAllowed, ""))
+		return
+	}
+
+	s, err := res.loadSchema()
+	if err != nil {
+		WriteProblem(w, problemOf(err, reflect.TypeOf((*T)(nil)).Elem()))
+		return
+	}
+
+	switch op {
+	case OperationList:
+		err = res.list(w, r, s)
+	case OperationCreate:
+		err = res.create(w, r, s)
+	case OperationGet:
+		err = res.get(w, r, s, id)
+	case OperationUpdate:
+		err = res.update(w, r, s, id)
+	case OperationDelete:
+		err = res.delete(w, r, s, id)
+	}
+	if err != nil {
+		WriteProblem(w, problemOf(err, reflect.TypeOf((*T)(nil)).Elem()))
+	}
+}
+
+func (res *resource[T]) serves(op Operation) bool {
+	for _, served := range res.opts.Operations {
+		if served == op {
+			return true
+		}
+	}
+	return false
+}
+
+func (res *resource[T]) loadSchema() (*schema.Schema, error) {
+	res.schemaLock.Lock()
+	defer res.schemaLock.Unlock()
+	if res.schema != nil {
+		return res.schema, nil
+	}
+	if err := egorm.InitDB(); err != nil {
+		return nil, err
+	}
+	s, err := schema.Parse(new(T), &res.schemaCache, egorm.Db.NamingStrategy)
+	if err != nil {
+		return nil, err
+	}
+	if s.PrioritizedPrimaryField == nil {
+		return nil, fmt.Errorf("egormhttp: %s needs a primary key", s.Name)
+	}
+	res.schema = s
+	return s, nil
+}
+
+func (res *resource[T]) authorize(r *http.Request, op Operation, entity *T) error {
+	if res.opts.Authorize == nil {
+		return nil
+	}
+	if err := res.opts.Authorize(r, op, entity); err != nil {
+		var problem *Problem
+		if errors.As(err, &problem) {
+			return problem
+		}
+		return NewProblem(http.StatusForbidden, err.Error())
+	}
+	return nil
+}
+
+func (res *resource[T]) list(w http.ResponseWriter, r *http.Request, s *schema.Schema) error {
+	query := r.URL.Query()
+	where := egorm.Where{}
+	invalid := make([]InvalidParam, 0)
+
+	page, pageSize := 1, res.opts.DefaultPageSize
+	if value := query.Get("page"); value != "" {
+		var err error
+		if page, err = strconv.Atoi(value); err != nil || page < 1 {
+			invalid = append(invalid, InvalidParam{Name: "page", Reason: "must be a positive number"})
+		}
+	}
+	if value := query.Get("page_size"); value != "" {
+		var err error
+		if pageSize, err = strconv.Atoi(value); err != nil || pageSize < 1 || pageSize > res.opts.MaxPageSize {
+			invalid = append(invalid, InvalidParam{Name: "page_size", Reason: fmt.Sprintf("must be between 1 and %d", res.opts.MaxPageSize)})
+		}
+	}
+
+	order := make([]string, 0)
+	for _, name := range splitParam(query.Get("sort")) {
+		field := lookupField(s, strings.TrimPrefix(name, "-"))
+		if field == nil {
+			invalid = append(invalid, InvalidParam{Name: "sort", Reason: fmt.Sprintf("unknown field %q", name)})
+			continue
+		}
+		if strings.HasPrefix(name, "-") {
+			order = append(order, "-"+field.DBName)
+		} else {
+			order = append(order, field.DBName)
+		}
+	}
+
+	selected := make(map[string]bool)
+	for _, name := range splitParam(query.Get("fields")) {
+		field := lookupField(s, name)
+		if field == nil {
+			invalid = append(invalid, InvalidParam{Name: "fields", Reason: fmt.Sprintf("unknown field %q", name)})
+			continue
+		}
+		selected[jsonName(field)] = true
+	}
+
+	for key, values := range query {
+		if key == "page" || key == "page_size" || key == "sort" || key == "fields" {
+			continue
+		}
+		name, op := key, "eq"
+		if open := strings.Index(key, "["); open > 0 && strings.HasSuffix(key, "]") {
+			name, op = key[:open], key[open+1:len(key)-1]
+		}
+		field := lookupField(s, name)
+		operator, supported := queryOperators[op]
+		if field == nil || !supported {
+			invalid = append(invalid, InvalidParam{Name: key, Reason: "unknown field or operator"})
+			continue
+		}
+
+		var value interface{}
+		var err error
+		if op == "in" || op == "nin" {
+			items := make([]interface{}, 0)
+			for _, raw := range splitParam(values[len(values)-1]) {
+				item, itemErr := parseValue(field, raw)
+				err = errors.Join(err, itemErr)
+				items = append(items, item)
+			}
+			value = items
+		} else {
+			value, err = parseValue(field, values[len(values)-1])
+		}
+		if err != nil {
+			invalid = append(invalid, InvalidParam{Name: key, Reason: err.Error()})
+			continue
+		}
+		where[field.DBName+" "+operator] = value
+	}
+	if len(invalid) > 0 {
+		return validationProblem(invalid...)
+	}
+
+	if err := res.authorize(r, OperationList, nil); err != nil {
+		return err
+	}
+
+	items := make([]T, 0)
+	total, err := egorm.DbListContext(r.Context(), &items, where, egorm.ListOpts{
+		Order:  order,
+		Limit:  pageSize,
+		Offset: (page - 1) * pageSize,
+	})
+	if err != nil {
+		return err
+	}
+
+	response := ListResponse{Items: make([]json.RawMessage, 0, len(items)), Total: total, Page: page, PageSize: pageSize}
+	for i := range items {
+		encoded, err := selectFields(&items[i], selected)
+		if err != nil {
+			return err
+		}
+		response.Items = append(response.Items, encoded)
+	}
+	writeJSON(w, http.StatusOK, response)
+	return nil
+}
+
+func (res *resource[T]) get(w http.ResponseWriter, r *http.Request, s *schema.Schema, id string) error {
+	entity, err := res.load(r, s, id)
+	if err != nil {
+		return err
+	}
+	if err := res.authorize(r, OperationGet, entity); err != nil {
+		return err
+	}
+	writeJSON(w, http.StatusOK, entity)
+	return nil
+}
+
+func (res *resource[T]) create(w http.ResponseWriter, r *http.Request, s *schema.Schema) error {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return decodeProblem(err)
+	}
+	values := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body, &values); err != nil {
+		return decodeProblem(err)
+	}
+	invalid := make([]InvalidParam, 0)
+	for name := range values {
+		if field := lookupJSONField(s, name); field != nil && readOnly(field) {
+			invalid = append(invalid, InvalidParam{Name: name, Reason: "read only"})
+		}
+	}
+	if len(invalid) > 0 {
+		return validationProblem(invalid...)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.DisallowUnknownFields()
+	entity := new(T)
+	if err := decoder.Decode(entity); err != nil {
+		return decodeProblem(err)
+	}
+	if err := res.authorize(r, OperationCreate, entity); err != nil {
+		return err
+	}
+	if err := egorm.DbCreateContext(r.Context(), entity); err != nil {
+		return err
+	}
+
+	if id, isZero := s.PrioritizedPrimaryField.ValueOf(r.Context(), reflect.ValueOf(entity).Elem()); !isZero {
+		location := r.URL.Path
+		if requestURI, err := url.ParseRequestURI(r.RequestURI); err == nil {
+			location = requestURI.Path
+		}
+		w.Header().Set("Location", strings.TrimSuffix(location, "/")+"/"+url.PathEscape(fmt.Sprint(id)))
+	}
+	writeJSON(w, http.StatusCreated, entity)
+	return nil
+}
+
+func (res *resource[T]) update(w http.ResponseWriter, r *http.Request, s *schema.Schema, id string) error {
+	entity, err := res.load(r, s, id)
+	if err != nil {
+		return err
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+	if err != nil {
+		return decodeProblem(err)
+	}
+	changes := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(body, &changes); err != nil {
+		return decodeProblem(err)
+	}
+	fields := make([]string, 0, len(changes))
+	invalid := make([]InvalidParam, 0)
+	for name := range changes {
+		field := lookupJSONField(s, name)
+		switch {
+		case field == nil:
+			invalid = append(invalid, InvalidParam{Name: name, Reason: "unknown field"})
+		case readOnly(field):
+			invalid = append(invalid, InvalidParam{Name: name, Reason: "read only"})
+		default:
+			fields = append(fields, field.Name)
+		}
+	}
+	if len(invalid) > 0 {
+		return validationProblem(invalid...)
+	}
+
+	if err := res.authorize(r, OperationUpdate, entity); err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		updated := *entity
+		if err := json.Unmarshal(body, &updated); err != nil {
+			return decodeProblem(err)
+		}
+		primaryKey, _ := s.PrioritizedPrimaryField.ValueOf(r.Context(), reflect.ValueOf(entity).Elem())
+		rows, err := egorm.DbUpdateContext[T](r.Context(), primaryKey, &updated, egorm.Fields(fields...))
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return gorm.ErrRecordNotFound
+		}
+		if entity, err = res.load(r, s, id); err != nil {
+			return err
+		}
+	}
+	writeJSON(w, http.StatusOK, entity)
+	return nil
+}
+
+func (res *resource[T]) delete(w http.ResponseWriter, r *http.Request, s *schema.Schema, id string) error {
+	entity, err := res.load(r, s, id)
+	if err != nil {
+		return err
+	}
+	if err := res.authorize(r, OperationDelete, entity); err != nil {
+		return err
+	}
+	if err := egorm.DbDeleteContext(r.Context(), entity); err != nil {
+		return err
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// load returns the entity with the primary key id or gorm.ErrRecordNotFound.
+func (res *resource[T]) load(r *http.Request, s *schema.Schema, id string) (*T, error) {
+	primaryKey := s.PrioritizedPrimaryField
+	value, err := parseValue(primaryKey, id)
+	if err != nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	found := make([]T, 0, 1)
+	if err := egorm.DbGetContext(r.Context(), &found, egorm.Where{primaryKey.DBName: value}); err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &found[0], nil
+}
+
+// lookupField returns the field with the given field, column or JSON name. Fields hidden
+// from JSON are never returned, so they cannot be filtered or sorted by.
+func lookupField(s *schema.Schema, name string) *schema.Field {
+	if field := s.LookUpField(name); field != nil && field.DBName != "" && !hidden(field) {
+		return field
+	}
+	return lookupJSONField(s, name)
+}
+
+// lookupJSONField returns the field with the given JSON name. Like encoding/json, it prefers
+// an exact match and otherwise ignores the case.
+func lookupJSONField(s *schema.Schema, name string) *schema.Field {
+	var folded *schema.Field
+	for _, field := range s.Fields {
+		if field.DBName == "" || hidden(field) {
+			continue
+		}
+		if jsonName(field) == name {
+			return field
+		}
+		if folded == nil && strings.EqualFold(jsonName(field), name) {
+			folded = field
+		}
+	}
+	return folded
+}
+
+// readOnly reports whether the field is maintained by gorm or egorm and may not be written
+// by clients: the primary key, timestamps, the version and the tenant.
+func readOnly(field *schema.Field) bool {
+	if field.PrimaryKey || field.AutoCreateTime != 0 || field.AutoUpdateTime != 0 ||
+		field.FieldType == reflect.TypeOf(gorm.DeletedAt{}) || field.Name == "TenantID" {
+		return true
+	}
+	for _, option := range strings.Split(field.Tag.Get("egorm"), ",") {
+		if option = strings.TrimSpace(option); option == "version" || option == "tenant" {
+			return true
+		}
+	}
+	return false
+}
+
+// hidden reports whether the field is left out of the JSON encoding, e.g. a password hash.
+func hidden(field *schema.Field) bool {
+	return field.Tag.Get("json") == "-"
+}
+
+// jsonName returns the name of the field in the JSON encoding of the entity.
+func jsonName(field *schema.Field) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" && name != "-" {
+		return name
+	}
+	return field.Name
+}
+
+// parseValue converts a value of the query string or path to the type of the field.
+func parseValue(field *schema.Field, raw string) (interface{}, error) {
+	var value interface{}
+	var err error
+	switch field.DataType {
+	case schema.Bool:
+		value, err = strconv.ParseBool(raw)
+	case schema.Int:
+		value, err = strconv.ParseInt(raw, 10, 64)
+	case schema.Uint:
+		value, err = strconv.ParseUint(raw, 10, 64)
+	case schema.Float:
+		value, err = strconv.ParseFloat(raw, 64)
+	case schema.Time:
+		if value, err = time.Parse(time.RFC3339Nano, raw); err != nil {
+			value, err = time.Parse(time.DateOnly, raw)
+		}
+	default:
+		value = raw
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q for %s", raw, field.DataType)
+	}
+	return value, nil
+}
+
+// selectFields encodes the entity and keeps only the selected JSON fields, all if none are selected.
+func selectFields(entity interface{}, selected map[string]bool) (json.RawMessage, error) {
+	encoded, err := json.Marshal(entity)
+	if err != nil || len(selected) == 0 {
+		return encoded, err
+	}
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(encoded, &fields); err != nil {
+		return nil, err
+	}
+	for name := range fields {
+		if !selected[name] {
+			delete(fields, name)
+		}
+	}
+	return json.Marshal(fields)
+}
+
+// decodeProblem reports an invalid request body.
+func decodeProblem(err error) *Problem {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return validationProblem(InvalidParam{Name: typeErr.Field, Reason: fmt.Sprintf("must be %s", typeErr.Type)})
+	}
+	var sizeErr *http.MaxBytesError
+	if errors.As(err, &sizeErr) {
+		return NewProblem(http.StatusRequestEntityTooLarge, "")
+	}
+	problem := NewProblem(http.StatusBadRequest, "The body is not valid JSON")
+	if strings.HasPrefix(err.Error(), "json: unknown field ") {
+		problem.InvalidParams = []InvalidParam{{Name: strings.Trim(strings.TrimPrefix(err.Error(), "json: unknown field "), `"`), Reason: "unknown field"}}
+	}
+	return problem
+}
+
+func splitParam(value string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,250 @@
+package egormhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/martenwallewein/easy-going/pkg/egorm"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+type User struct {
+	gorm.Model
+	Name  string `json:"name" gorm:"not null"`
+	Email string `json:"email" gorm:"uniqueIndex" validate:"omitempty,email"`
+	Age   int    `json:"age" validate:"max=150"`
+	Owner string `json:"owner"`
+	// Secret is hidden from clients
+	Secret string `json:"-"`
+}
+
+func TestMain(m *testing.M) {
+	dbPath := path.Join(os.TempDir(), "egormhttp_test.sqlite")
+	os.Remove(dbPath)
+	egorm.SetSQLiteConnectOpts(&egorm.SQLiteConnectOpts{Path: dbPath})
+	code := m.Run()
+	egorm.CloseDB()
+	os.Remove(dbPath)
+	os.Exit(code)
+}
+
+func request(handler http.Handler, method string, target string, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, r)
+	return recorder
+}
+
+func TestResource(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/users/", http.StripPrefix("/users", Resource(ResourceOpts[User]{
+		Authorize: func(r *http.Request, op Operation, user *User) error {
+			if op == OperationDelete && user.Owner != r.Header.Get("X-User") {
+				return errors.New("only the owner may delete a user")
+			}
+			return nil
+		},
+	})))
+
+	t.Run("TestCreate", func(t *testing.T) {
+		for i, name := range []string{"Ann", "Bob", "Cid", "Dan"} {
+			body := fmt.Sprintf(`{"name": %q, "email": "%s@example.com", "age": %d, "owner": "admin"}`, name, strings.ToLower(name), 20+i*10)
+			response := request(mux, "POST", "/users/", body)
+			if response.Code != http.StatusCreated {
+				t.Errorf("egormhttp: Expected status %d, got %d: %s", http.StatusCreated, response.Code, response.Body.String())
+				return
+			}
+			if location := response.Header().Get("Location"); location != fmt.Sprintf("/users/%d", i+1) {
+				t.Errorf("egormhttp: Unexpected location %s", location)
+			}
+		}
+
+		response := request(mux, "POST", "/users/", `{"name": "Eve", "email": "ann@example.com"}`)
+		if response.Code != http.StatusConflict || response.Header().Get("Content-Type") != "application/problem+json" {
+			t.Errorf("egormhttp: Expected a conflict problem, got %d: %s", response.Code, response.Body.String())
+		}
+
+		response = request(mux, "POST", "/users/", `{"name": "Eve", "unknown": 1}`)
+		var problem Problem
+		json.Unmarshal(response.Body.Bytes(), &problem)
+		if response.Code != http.StatusBadRequest || len(problem.InvalidParams) != 1 || problem.InvalidParams[0].Name != "unknown" {
+			t.Errorf("egormhttp: Expected a validation problem, got %d: %s", response.Code, response.Body.String())
+		}
+
+		for _, body := range []string{`{"name": "Eve", "ID": 42}`, `{"name": "Eve", "id": 42}`, `{"name": "Eve", "CreatedAt": "2000-01-01T00:00:00Z"}`, `{"name": "Eve", "DeletedAt": "2000-01-01T00:00:00Z"}`} {
+			response = request(mux, "POST", "/users/", body)
+			problem = Problem{}
+			json.Unmarshal(response.Body.Bytes(), &problem)
+			if response.Code != http.StatusBadRequest || len(problem.InvalidParams) != 1 || problem.InvalidParams[0].Reason != "read only" {
+				t.Errorf("egormhttp: Expected a read only problem for %s, got %d: %s", body, response.Code, response.Body.String())
+			}
+		}
+
+		response = request(mux, "POST", "/users/", `{"name": "Eve", "email": "eve", "age": 200}`)
+		problem = Problem{}
+		json.Unmarshal(response.Body.Bytes(), &problem)
+		if response.Code != http.StatusUnprocessableEntity || len(problem.InvalidParams) != 2 || problem.InvalidParams[0].Name != "email" {
+			t.Errorf("egormhttp: Expected an invalid entity problem, got %d: %s", response.Code, response.Body.String())
+		}
+	})
+
+	t.Run("TestList", func(t *testing.T) {
+		response := request(mux, "GET", "/users/?age[gte]=30&sort=-age&page_size=2&fields=name,age", "")
+		if response.Code != http.StatusOK {
+			t.Errorf("egormhttp: Expected status %d, got %d: %s", http.StatusOK, response.Code, response.Body.String())
+			return
+		}
+		var list struct {
+			Items []map[string]interface{} `json:"items"`
+			Total int64                    `json:"total"`
+		}
+		if err := json.Unmarshal(response.Body.Bytes(), &list); err != nil {
+			t.Error(err)
+			return
+		}
+		if list.Total != 3 || len(list.Items) != 2 || list.Items[0]["name"] != "Dan" || list.Items[1]["name"] != "Cid" {
+			t.Errorf("egormhttp: Unexpected list %+v", list)
+		}
+		if _, ok := list.Items[0]["email"]; ok || len(list.Items[0]) != 2 {
+			t.Errorf("egormhttp: Expected only the selected fields, got %v", list.Items[0])
+		}
+
+		response = request(mux, "GET", "/users/?name[in]=Ann,Bob&page=2&page_size=1", "")
+		json.Unmarshal(response.Body.Bytes(), &list)
+		if list.Total != 2 || len(list.Items) != 1 || list.Items[0]["name"] != "Bob" {
+			t.Errorf("egormhttp: Unexpected list %+v", list)
+		}
+
+		for _, query := range []string{"Secret=x", "secret=x", "sort=Secret", "sort=-secret"} {
+			if response := request(mux, "GET", "/users/?"+query, ""); response.Code != http.StatusBadRequest {
+				t.Errorf("egormhttp: Expected hidden fields to be rejected in %s, got %d", query, response.Code)
+			}
+		}
+
+		response = request(mux, "GET", "/users/?age[gte]=old&secret=1", "")
+		var problem Problem
+		json.Unmarshal(response.Body.Bytes(), &problem)
+		if response.Code != http.StatusBadRequest || len(problem.InvalidParams) != 2 {
+			t.Errorf("egormhttp: Expected a validation problem, got %d: %s", response.Code, response.Body.String())
+		}
+	})
+
+	t.Run("TestGetUpdateDelete", func(t *testing.T) {
+		if response := request(mux, "GET", "/users/42", ""); response.Code != http.StatusNotFound {
+			t.Errorf("egormhttp: Expected status %d, got %d", http.StatusNotFound, response.Code)
+		}
+
+		response := request(mux, "PATCH", "/users/2", `{"age": 31}`)
+		var user User
+		json.Unmarshal(response.Body.Bytes(), &user)
+		if response.Code != http.StatusOK || user.Age != 31 || user.Name != "Bob" {
+			t.Errorf("egormhttp: Expected the updated user, got %d: %s", response.Code, response.Body.String())
+		}
+
+		if response := request(mux, "PATCH", "/users/2", `{"age": -1, "email": "bob"}`); response.Code != http.StatusUnprocessableEntity {
+			t.Errorf("egormhttp: Expected status %d, got %d: %s", http.StatusUnprocessableEntity, response.Code, response.Body.String())
+		}
+		for _, body := range []string{`{"ID": 7}`, `{"id": 7}`, `{"CreatedAt": "2000-01-01T00:00:00Z"}`, `{"updatedat": "2000-01-01T00:00:00Z"}`, `{"DeletedAt": "2000-01-01T00:00:00Z"}`} {
+			if response := request(mux, "PATCH", "/users/2", body); response.Code != http.StatusBadRequest {
+				t.Errorf("egormhttp: Expected %s to be read only, got %d", body, response.Code)
+			}
+		}
+		// Names are matched like encoding/json does, ignoring the case
+		response = request(mux, "PATCH", "/users/2", `{"Age": 32}`)
+		user = User{}
+		json.Unmarshal(response.Body.Bytes(), &user)
+		if response.Code != http.StatusOK || user.Age != 32 {
+			t.Errorf("egormhttp: Expected the age to be updated, got %d: %s", response.Code, response.Body.String())
+		}
+
+		if response := request(mux, "DELETE", "/users/2", "", "X-User", "mallory"); response.Code != http.StatusForbidden {
+			t.Errorf("egormhttp: Expected status %d, got %d", http.StatusForbidden, response.Code)
+		}
+		if response := request(mux, "DELETE", "/users/2", "", "X-User", "admin"); response.Code != http.StatusNoContent {
+			t.Errorf("egormhttp: Expected status %d, got %d: %s", http.StatusNoContent, response.Code, response.Body.String())
+		}
+		if response := request(mux, "GET", "/users/2", ""); response.Code != http.StatusNotFound {
+			t.Errorf("egormhttp: Expected status %d, got %d", http.StatusNotFound, response.Code)
+		}
+	})
+
+	t.Run("TestOperations", func(t *testing.T) {
+		readOnly := Resource(ResourceOpts[User]{Operations: []Operation{OperationList, OperationGet}})
+		if response := request(readOnly, "POST", "/", `{"name": "Eve"}`); response.Code != http.StatusMethodNotAllowed {
+			t.Errorf("egormhttp: Expected status %d, got %d", http.StatusMethodNotAllowed, response.Code)
+		}
+	})
+}
+
+func TestJSONPath(t *testing.T) {
+	t.Run("TestJSONPath", func(t *testing.T) {
+		type item struct {
+			Name string `json:"item_name"`
+		}
+		type order struct {
+			Items   []*item `json:"items"`
+			Comment string
+		}
+		model := reflect.TypeOf(order{})
+		for path, expected := range map[string]string{
+			"Items[2].Name": "items[2].item_name",
+			"Comment":       "Comment",
+			"Unknown.Name":  "Unknown.Name",
+			"":              "",
+		} {
+			if name := jsonPath(model, path); name != expected {
+				t.Errorf("egormhttp: Expected %s for %s, got %s", expected, path, name)
+			}
+		}
+	})
+}
+
+func TestReadOnly(t *testing.T) {
+	t.Run("TestReadOnly", func(t *testing.T) {
+		type document struct {
+			gorm.Model
+			egorm.Versioned
+			TenantID string
+			Owner    string `egorm:"tenant"`
+			Title    string
+		}
+		s, err := schema.Parse(&document{}, &sync.Map{}, schema.NamingStrategy{})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		for name, expected := range map[string]bool{"ID": true, "CreatedAt": true, "UpdatedAt": true, "DeletedAt": true, "Version": true, "TenantID": true, "Owner": true, "Title": false} {
+			if field := s.LookUpField(name); field == nil || readOnly(field) != expected {
+				t.Errorf("egormhttp: Expected %s to be read only: %t", name, expected)
+			}
+		}
+	})
+}
+
+func TestProblemOf(t *testing.T) {
+	t.Run("TestProblemOf", func(t *testing.T) {
+		for err, expected := range map[error]int{
+			fmt.Errorf("%w: User belongs to tenant b", egorm.ErrCrossTenant): http.StatusNotFound,
+			fmt.Errorf("%w for User", egorm.ErrMissingTenant):                http.StatusForbidden,
+			gorm.ErrRecordNotFound:   http.StatusNotFound,
+			errors.New("unexpected"): http.StatusInternalServerError,
+		} {
+			if problem := problemOf(err, reflect.TypeOf(User{})); problem.Status != expected {
+				t.Errorf("egormhttp: Expected status %d for %s, got %d", expected, err, problem.Status)
+			}
+		}
+	})
+}